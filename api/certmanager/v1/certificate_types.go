@@ -0,0 +1,101 @@
+/*
+Copyright 2020 The cert-manager Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NOTE: only the fields used by the operator are kept, unknown fields are dropped when the object is updated.
+
+// CertificateSpec defines the desired state of Certificate
+type CertificateSpec struct {
+	// DNSNames is a list of DNS subjectAltNames to be set on the Certificate.
+	DNSNames []string `json:"dnsNames,omitempty"`
+
+	// SecretName is the name of the secret resource that will be automatically
+	// created and managed by this Certificate resource.
+	SecretName string `json:"secretName"`
+
+	// IssuerRef is a reference to the issuer for this certificate.
+	IssuerRef ObjectReference `json:"issuerRef"`
+}
+
+// ObjectReference is a reference to an object with a given name, kind and group.
+type ObjectReference struct {
+	// Name of the resource being referred to.
+	Name string `json:"name"`
+	// Kind of the resource being referred to.
+	// +optional
+	Kind string `json:"kind,omitempty"`
+	// Group of the resource being referred to.
+	// +optional
+	Group string `json:"group,omitempty"`
+}
+
+// CertificateCondition contains condition information for a Certificate.
+type CertificateCondition struct {
+	// Type of the condition, known values are (`Ready`, `Issuing`).
+	Type string `json:"type"`
+
+	// Status of the condition, one of (`True`, `False`, `Unknown`).
+	Status metav1.ConditionStatus `json:"status"`
+
+	// Reason is a brief machine readable explanation for the condition's last
+	// transition.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Message is a human readable description of the details of the last
+	// transition, complementing reason.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// CertificateStatus defines the observed state of Certificate
+type CertificateStatus struct {
+	// List of status conditions to indicate the status of certificates.
+	// +optional
+	Conditions []CertificateCondition `json:"conditions,omitempty"`
+
+	// The expiration time of the certificate stored in the secret named
+	// by this resource in `spec.secretName`.
+	// +optional
+	NotAfter *metav1.Time `json:"notAfter,omitempty"`
+}
+
+// +kubebuilder:object:root=false
+// Certificate is a type to represent a Certificate from ACME
+type Certificate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CertificateSpec   `json:"spec,omitempty"`
+	Status CertificateStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=false
+// CertificateList is a list of Certificates
+type CertificateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Certificate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Certificate{}, &CertificateList{})
+}
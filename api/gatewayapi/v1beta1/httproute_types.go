@@ -0,0 +1,279 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NOTE: only the fields used by the operator are kept, unknown fields are dropped when the object is updated.
+
+// HTTPRouteSpec defines the desired state of HTTPRoute
+type HTTPRouteSpec struct {
+	// ParentRefs references the resources (usually Gateways) that a Route wants
+	// to be attached to.
+	// +optional
+	ParentRefs []ParentReference `json:"parentRefs,omitempty"`
+
+	// Hostnames defines a set of hostname that should match against the HTTP
+	// Host header to select a HTTPRoute to process the request.
+	// +optional
+	Hostnames []Hostname `json:"hostnames,omitempty"`
+
+	// Rules are a list of HTTP matchers, filters and actions.
+	// +optional
+	Rules []HTTPRouteRule `json:"rules,omitempty"`
+}
+
+// Hostname is the fully qualified domain name of a network host.
+type Hostname string
+
+// ParentReference identifies an API object (usually a Gateway) that can be considered
+// a parent of this resource (usually a route).
+type ParentReference struct {
+	// Group is the group of the referent.
+	// +optional
+	Group *string `json:"group,omitempty"`
+
+	// Kind is kind of the referent.
+	// +optional
+	Kind *string `json:"kind,omitempty"`
+
+	// Namespace is the namespace of the referent. When unspecified, this refers
+	// to the local namespace of the Route.
+	// +optional
+	Namespace *string `json:"namespace,omitempty"`
+
+	// Name is the name of the referent.
+	Name string `json:"name"`
+
+	// SectionName is the name of a section within the target resource, for
+	// Gateways this is the listener name.
+	// +optional
+	SectionName *string `json:"sectionName,omitempty"`
+}
+
+// HTTPRouteRule defines semantics for matching an HTTP request based on
+// conditions (matches), processing it (filters), and forwarding the request to
+// an API object (backendRefs).
+type HTTPRouteRule struct {
+	// Matches define conditions used for matching the rule against incoming
+	// HTTP requests.
+	// +optional
+	Matches []HTTPRouteMatch `json:"matches,omitempty"`
+
+	// Filters define the filters that are applied to requests that match
+	// this rule.
+	// +optional
+	Filters []HTTPRouteFilter `json:"filters,omitempty"`
+
+	// BackendRefs defines the backend(s) where matching requests should be
+	// sent. A rule without backendRefs and filters responds with a 503 status code.
+	// +optional
+	BackendRefs []HTTPBackendRef `json:"backendRefs,omitempty"`
+}
+
+// PathMatchType specifies the semantics of how HTTP paths should be compared.
+// Valid PathMatchType values are "Exact", "PathPrefix" and "RegularExpression".
+type PathMatchType string
+
+const (
+	PathMatchExact             PathMatchType = "Exact"
+	PathMatchPathPrefix        PathMatchType = "PathPrefix"
+	PathMatchRegularExpression PathMatchType = "RegularExpression"
+)
+
+// HTTPPathMatch describes how to select a HTTP route by matching the HTTP request path.
+type HTTPPathMatch struct {
+	// Type specifies how to match against the path Value.
+	// +optional
+	Type *PathMatchType `json:"type,omitempty"`
+
+	// Value of the HTTP path to match against.
+	// +optional
+	Value *string `json:"value,omitempty"`
+}
+
+// HeaderMatchType specifies the semantics of how HTTP header values should be compared.
+type HeaderMatchType string
+
+const (
+	HeaderMatchExact             HeaderMatchType = "Exact"
+	HeaderMatchRegularExpression HeaderMatchType = "RegularExpression"
+)
+
+// HTTPHeaderMatch describes how to select a HTTP route by matching HTTP request headers.
+type HTTPHeaderMatch struct {
+	// Type specifies how to match against the value of the header.
+	// +optional
+	Type *HeaderMatchType `json:"type,omitempty"`
+
+	// Name is the name of the HTTP Header to be matched.
+	Name string `json:"name"`
+
+	// Value is the value of HTTP Header to be matched.
+	Value string `json:"value"`
+}
+
+// HTTPRouteMatch defines the predicate used to match requests to a given action.
+type HTTPRouteMatch struct {
+	// Path specifies a HTTP request path matcher.
+	// +optional
+	Path *HTTPPathMatch `json:"path,omitempty"`
+
+	// Headers specifies HTTP request header matchers. Multiple match values are
+	// ANDed together.
+	// +optional
+	Headers []HTTPHeaderMatch `json:"headers,omitempty"`
+}
+
+// HTTPRouteFilterType identifies a type of HTTPRoute filter.
+type HTTPRouteFilterType string
+
+const (
+	HTTPRouteFilterRequestHeaderModifier HTTPRouteFilterType = "RequestHeaderModifier"
+)
+
+// HTTPRouteFilter defines processing steps that must be completed during the
+// request or response lifecycle.
+type HTTPRouteFilter struct {
+	// Type identifies the type of filter to apply.
+	Type HTTPRouteFilterType `json:"type"`
+
+	// RequestHeaderModifier defines a schema for a filter that modifies request
+	// headers.
+	// +optional
+	RequestHeaderModifier *HTTPHeaderFilter `json:"requestHeaderModifier,omitempty"`
+}
+
+// HTTPHeader represents an HTTP Header name and value as defined by RFC 7230.
+type HTTPHeader struct {
+	// Name is the name of the HTTP Header to be matched.
+	Name string `json:"name"`
+
+	// Value is the value of HTTP Header to be matched.
+	Value string `json:"value"`
+}
+
+// HTTPHeaderFilter defines a filter that modifies the headers of an HTTP request
+// or response.
+type HTTPHeaderFilter struct {
+	// Set overwrites the request with the given header (name, value) before the action.
+	// +optional
+	Set []HTTPHeader `json:"set,omitempty"`
+
+	// Add adds the given header(s) (name, value) to the request before the action.
+	// +optional
+	Add []HTTPHeader `json:"add,omitempty"`
+
+	// Remove the given header(s) from the HTTP request before the action.
+	// +optional
+	Remove []string `json:"remove,omitempty"`
+}
+
+// BackendObjectReference defines how an ObjectReference that is specific to BackendRef.
+type BackendObjectReference struct {
+	// Group is the group of the referent. When unspecified or empty string,
+	// core API group is inferred.
+	// +optional
+	Group *string `json:"group,omitempty"`
+
+	// Kind is kind of the referent, defaults to "Service".
+	// +optional
+	Kind *string `json:"kind,omitempty"`
+
+	// Name is the name of the referent.
+	Name string `json:"name"`
+
+	// Namespace is the namespace of the backend. When unspecified, the local
+	// namespace is inferred.
+	// +optional
+	Namespace *string `json:"namespace,omitempty"`
+
+	// Port specifies the destination port number to use for this resource.
+	// +optional
+	Port *int32 `json:"port,omitempty"`
+}
+
+// BackendRef defines how a Route should forward a request to a Kubernetes resource.
+type BackendRef struct {
+	// BackendObjectReference references a Kubernetes object.
+	BackendObjectReference `json:",inline"`
+
+	// Weight specifies the proportion of requests forwarded to the referenced
+	// backend.
+	// +optional
+	Weight *int32 `json:"weight,omitempty"`
+}
+
+// HTTPBackendRef defines how a HTTPRoute should forward an HTTP request.
+type HTTPBackendRef struct {
+	// BackendRef is a reference to a backend to forward matched requests to.
+	BackendRef `json:",inline"`
+
+	// Filters defined at this level should be executed if and only if the
+	// request is being forwarded to the backend defined here.
+	// +optional
+	Filters []HTTPRouteFilter `json:"filters,omitempty"`
+}
+
+// RouteParentStatus describes the status of a route with respect to an
+// associated Parent.
+type RouteParentStatus struct {
+	// ParentRef corresponds with a ParentRef in the spec that this
+	// RouteParentStatus struct describes the status of.
+	ParentRef ParentReference `json:"parentRef"`
+
+	// ControllerName is a domain/path string that indicates the name of the
+	// controller that wrote this status.
+	ControllerName string `json:"controllerName"`
+
+	// Conditions describes the status of the route with respect to the Gateway.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// HTTPRouteStatus defines the observed state of HTTPRoute.
+type HTTPRouteStatus struct {
+	// Parents is a list of parent resources (usually Gateways) that are
+	// associated with the route, and the status of the route with respect to
+	// each parent.
+	// +optional
+	Parents []RouteParentStatus `json:"parents,omitempty"`
+}
+
+// +kubebuilder:object:root=false
+// HTTPRoute provides a way to route HTTP requests.
+type HTTPRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HTTPRouteSpec   `json:"spec,omitempty"`
+	Status HTTPRouteStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=false
+// HTTPRouteList contains a list of HTTPRoute.
+type HTTPRouteList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HTTPRoute `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HTTPRoute{}, &HTTPRouteList{})
+}
@@ -0,0 +1,137 @@
+/*
+Copyright Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NOTE: only the fields used by the operator are kept, unknown fields are dropped when the object is updated.
+
+// DestinationRuleSpec defines policies that apply to traffic intended for a service after routing has occurred.
+type DestinationRuleSpec struct {
+	// The name of a service from the service registry.
+	Host string `json:"host"`
+
+	// Traffic policies to apply (load balancing policy, connection pool sizes, outlier detection).
+	// +optional
+	TrafficPolicy *TrafficPolicy `json:"trafficPolicy,omitempty"`
+
+	// One or more named sets that represent individual versions of a service.
+	// +optional
+	Subsets []Subset `json:"subsets,omitempty"`
+}
+
+// Subset is a subset of endpoints of a service.
+type Subset struct {
+	// Name of the subset.
+	Name string `json:"name"`
+
+	// Labels apply a filter over the endpoints of a service in the service registry.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Traffic policies that apply to this subset. Subsets inherit the traffic policies
+	// specified at the DestinationRule level. Settings specified at the subset level
+	// will override the corresponding settings specified at the DestinationRule level.
+	// +optional
+	TrafficPolicy *TrafficPolicy `json:"trafficPolicy,omitempty"`
+}
+
+// TrafficPolicy is the traffic policy to apply for a specific destination, across all destination ports.
+type TrafficPolicy struct {
+	// Settings controlling the volume of connections to an upstream service.
+	// +optional
+	ConnectionPool *ConnectionPoolSettings `json:"connectionPool,omitempty"`
+
+	// Settings controlling eviction of unhealthy hosts from the load balancing pool.
+	// +optional
+	OutlierDetection *OutlierDetection `json:"outlierDetection,omitempty"`
+}
+
+// ConnectionPoolSettings are connection-pool settings for an upstream host.
+type ConnectionPoolSettings struct {
+	// Settings common to both HTTP and TCP upstream connections.
+	// +optional
+	Tcp *TCPSettings `json:"tcp,omitempty"`
+
+	// HTTP connection pool settings.
+	// +optional
+	Http *HTTPSettings `json:"http,omitempty"`
+}
+
+// TCPSettings are settings common to both HTTP and TCP upstream connections.
+type TCPSettings struct {
+	// Maximum number of HTTP1 /TCP connections to a destination host.
+	// +optional
+	MaxConnections int32 `json:"maxConnections,omitempty"`
+}
+
+// HTTPSettings are settings applicable to HTTP1.1/HTTP2/GRPC connections.
+type HTTPSettings struct {
+	// Maximum number of requests that will be queued while waiting for a ready connection pool connection.
+	// +optional
+	Http1MaxPendingRequests int32 `json:"http1MaxPendingRequests,omitempty"`
+
+	// Maximum number of active requests to a destination.
+	// +optional
+	Http2MaxRequests int32 `json:"http2MaxRequests,omitempty"`
+
+	// Maximum number of requests per connection to a backend.
+	// +optional
+	MaxRequestsPerConnection int32 `json:"maxRequestsPerConnection,omitempty"`
+}
+
+// OutlierDetection is a circuit breaker implementation that tracks the status of each individual host in the upstream service.
+type OutlierDetection struct {
+	// Number of 5xx errors before a host is ejected from the connection pool.
+	// +optional
+	Consecutive5xxErrors *uint32 `json:"consecutive5xxErrors,omitempty"`
+
+	// Time interval between ejection sweep analysis, e.g. "10s".
+	// +optional
+	Interval string `json:"interval,omitempty"`
+
+	// Minimum ejection duration, e.g. "30s".
+	// +optional
+	BaseEjectionTime string `json:"baseEjectionTime,omitempty"`
+
+	// Maximum % of hosts in the load balancing pool for the upstream service that can be ejected.
+	// +optional
+	MaxEjectionPercent int32 `json:"maxEjectionPercent,omitempty"`
+}
+
+// +kubebuilder:object:root=false
+// DestinationRule is the Schema for the destinationrules API
+type DestinationRule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec DestinationRuleSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=false
+// DestinationRuleList contains a list of DestinationRule
+type DestinationRuleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DestinationRule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DestinationRule{}, &DestinationRuleList{})
+}
@@ -0,0 +1,213 @@
+/*
+Copyright Istio Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NOTE: only the fields used by the operator are kept, unknown fields are dropped when the object is updated.
+
+// VirtualServiceSpec defines a set of traffic routing rules to apply when a host is addressed.
+type VirtualServiceSpec struct {
+	// The destination hosts to which traffic is being sent.
+	Hosts []string `json:"hosts,omitempty"`
+
+	// The names of gateways and sidecars that should apply these routes.
+	// The reserved word `mesh` is used to imply all the sidecars in the mesh.
+	// +optional
+	Gateways []string `json:"gateways,omitempty"`
+
+	// An ordered list of route rules for HTTP traffic.
+	// +optional
+	Http []HTTPRoute `json:"http,omitempty"`
+
+	// An ordered list of route rules for opaque TCP traffic.
+	// +optional
+	Tcp []TCPRoute `json:"tcp,omitempty"`
+}
+
+// HTTPRoute describes match conditions and actions for routing HTTP/1.1, HTTP2, and gRPC traffic.
+type HTTPRoute struct {
+	// The name assigned to the route for debugging purposes.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Match conditions to be satisfied for the rule to be activated.
+	// All conditions inside a single match block have AND semantics,
+	// while the list of match blocks have OR semantics.
+	// +optional
+	Match []HTTPMatchRequest `json:"match,omitempty"`
+
+	// A HTTP rule can either return a direct_response, redirect or forward (default) traffic.
+	// +optional
+	Route []HTTPRouteDestination `json:"route,omitempty"`
+
+	// Timeout for HTTP requests, e.g. "30s".
+	// +optional
+	Timeout string `json:"timeout,omitempty"`
+
+	// Retry policy for HTTP requests.
+	// +optional
+	Retries *HTTPRetry `json:"retries,omitempty"`
+
+	// Mirror HTTP traffic to another destination in addition to forwarding the requests to the intended destination.
+	// Mirrored traffic is on a best effort basis where the sidecar/gateway will not wait for the mirrored cluster
+	// to respond before returning the response from the original destination.
+	// +optional
+	Mirror *Destination `json:"mirror,omitempty"`
+
+	// Percentage of the traffic to be mirrored by the mirror field. Defaults to 100.
+	// +optional
+	MirrorPercentage *Percent `json:"mirrorPercentage,omitempty"`
+}
+
+// Percent specifies a percentage in the range of [0.0, 100.0].
+type Percent struct {
+	Value float64 `json:"value,omitempty"`
+}
+
+// HTTPRetry describes the retry policy to use when a HTTP request fails.
+type HTTPRetry struct {
+	// Number of retries to be allowed for a given request.
+	Attempts int32 `json:"attempts"`
+
+	// Timeout per attempt for a given request, including the initial call and any retries, e.g. "2s".
+	// +optional
+	PerTryTimeout string `json:"perTryTimeout,omitempty"`
+
+	// Specifies the conditions under which retry takes place, e.g. "5xx,connect-failure".
+	// +optional
+	RetryOn string `json:"retryOn,omitempty"`
+}
+
+// HTTPMatchRequest specifies a set of criterion to be met in order for the rule to be applied.
+type HTTPMatchRequest struct {
+	// The name assigned to a match.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// URI to match values are case-sensitive.
+	// +optional
+	Uri *StringMatch `json:"uri,omitempty"`
+
+	// HTTP Authority values are case-sensitive.
+	// +optional
+	Authority *StringMatch `json:"authority,omitempty"`
+
+	// The header keys must be lowercase and use hyphen as the separator.
+	// +optional
+	Headers map[string]StringMatch `json:"headers,omitempty"`
+
+	// Names of gateways where the rule should be applied.
+	// +optional
+	Gateways []string `json:"gateways,omitempty"`
+}
+
+// StringMatch describes how to match a given string in HTTP headers, only one of the fields may be set.
+type StringMatch struct {
+	// exact string match
+	// +optional
+	Exact string `json:"exact,omitempty"`
+
+	// prefix-based match
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// RE2 style regex-based match
+	// +optional
+	Regex string `json:"regex,omitempty"`
+}
+
+// HTTPRouteDestination is a HTTP rule forwarding traffic to the destination.
+type HTTPRouteDestination struct {
+	// Destination uniquely identifies the instances of a service to which the request/connection should be forwarded to.
+	Destination *Destination `json:"destination"`
+
+	// Weight specifies the relative proportion of traffic to be forwarded to the destination.
+	// +optional
+	Weight int32 `json:"weight,omitempty"`
+}
+
+// Destination indicates the network addressable service to which the request/connection will be sent after processing a routing rule.
+type Destination struct {
+	// The name of a service from the service registry.
+	Host string `json:"host"`
+
+	// The name of a subset within the service.
+	// +optional
+	Subset string `json:"subset,omitempty"`
+
+	// Specifies the port on the host that is being addressed.
+	// +optional
+	Port *PortSelector `json:"port,omitempty"`
+}
+
+// PortSelector specifies the number of a port to be used for matching or selection for final routing.
+type PortSelector struct {
+	// Valid port number
+	Number uint32 `json:"number,omitempty"`
+}
+
+// TCPRoute describes match conditions and actions for routing TCP traffic.
+type TCPRoute struct {
+	// Match conditions to be satisfied for the rule to be activated.
+	// +optional
+	Match []L4MatchAttributes `json:"match,omitempty"`
+
+	// The destination to which the connection should be forwarded to.
+	// +optional
+	Route []RouteDestination `json:"route,omitempty"`
+}
+
+// L4MatchAttributes are L4 connection match attributes.
+type L4MatchAttributes struct {
+	// Specifies the port on the host that is being addressed.
+	// +optional
+	Port uint32 `json:"port,omitempty"`
+}
+
+// RouteDestination is a L4 routing rule forwarding traffic to the destination.
+type RouteDestination struct {
+	// Destination uniquely identifies the instances of a service to which the request/connection should be forwarded to.
+	Destination *Destination `json:"destination"`
+
+	// Weight specifies the relative proportion of traffic to be forwarded to the destination.
+	// +optional
+	Weight int32 `json:"weight,omitempty"`
+}
+
+// +kubebuilder:object:root=false
+// VirtualService is the Schema for the virtualservices API
+type VirtualService struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec VirtualServiceSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=false
+// VirtualServiceList contains a list of VirtualService
+type VirtualServiceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VirtualService `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VirtualService{}, &VirtualServiceList{})
+}
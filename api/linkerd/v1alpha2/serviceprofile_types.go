@@ -0,0 +1,95 @@
+/*
+Copyright 2020 The Linkerd Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NOTE: only the fields used by the operator are kept, unknown fields are dropped when the object is updated.
+
+// ServiceProfileSpec specifies a ServiceProfile resource.
+type ServiceProfileSpec struct {
+	// Routes is a list of routes, the first route that matches a request is used.
+	// +optional
+	Routes []*RouteSpec `json:"routes,omitempty"`
+
+	// RetryBudget limits the number of retries that are sent to this service.
+	// +optional
+	RetryBudget *RetryBudget `json:"retryBudget,omitempty"`
+}
+
+// RouteSpec specifies a Route resource.
+type RouteSpec struct {
+	// Name of the route, used in metrics.
+	Name string `json:"name"`
+
+	// Condition matches the requests of the route.
+	Condition *RequestMatch `json:"condition"`
+
+	// IsRetryable indicates that requests to this route may be retried.
+	// +optional
+	IsRetryable bool `json:"isRetryable,omitempty"`
+
+	// Timeout is the maximum amount of time to wait for a response, e.g. "30s".
+	// +optional
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// RequestMatch describes the conditions under which to match a Route.
+type RequestMatch struct {
+	// PathRegex is a regular expression matched against the request path.
+	// +optional
+	PathRegex string `json:"pathRegex,omitempty"`
+
+	// Method is the HTTP method of the request.
+	// +optional
+	Method string `json:"method,omitempty"`
+}
+
+// RetryBudget describes the maximum number of retries that should be issued to this service.
+type RetryBudget struct {
+	// RetryRatio is the ratio of additional requests that may be sent as retries.
+	RetryRatio float32 `json:"retryRatio"`
+
+	// MinRetriesPerSecond is the number of retries allowed per second regardless of the retry ratio.
+	MinRetriesPerSecond uint32 `json:"minRetriesPerSecond"`
+
+	// TTL is the time window over which the retry ratio is calculated, e.g. "10s".
+	TTL string `json:"ttl"`
+}
+
+// +kubebuilder:object:root=false
+// ServiceProfile describes a service's routes, retries and timeouts.
+type ServiceProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ServiceProfileSpec `json:"spec"`
+}
+
+// +kubebuilder:object:root=false
+// ServiceProfileList is a list of ServiceProfile resources.
+type ServiceProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []ServiceProfile `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ServiceProfile{}, &ServiceProfileList{})
+}
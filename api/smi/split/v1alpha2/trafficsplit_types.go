@@ -0,0 +1,65 @@
+/*
+Copyright 2020 The SMI Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NOTE: only the fields used by the operator are kept, unknown fields are dropped when the object is updated.
+
+// TrafficSplitSpec is the specification for a TrafficSplit
+type TrafficSplitSpec struct {
+	// Service represents the apex service
+	Service string `json:"service"`
+
+	// Backends defines a list of Kubernetes services
+	// used as the traffic split destination
+	Backends []TrafficSplitBackend `json:"backends"`
+}
+
+// TrafficSplitBackend defines a backend
+type TrafficSplitBackend struct {
+	// Service is the name of a Kubernetes service
+	Service string `json:"service"`
+
+	// Weight defines the traffic split percentage
+	Weight int `json:"weight"`
+}
+
+// +kubebuilder:object:root=false
+// TrafficSplit allows users to incrementally direct percentages of traffic
+// between various services
+type TrafficSplit struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec TrafficSplitSpec `json:"spec"`
+}
+
+// +kubebuilder:object:root=false
+// TrafficSplitList satisfy K8s code gen requirements
+type TrafficSplitList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []TrafficSplit `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TrafficSplit{}, &TrafficSplitList{})
+}
@@ -0,0 +1,37 @@
+package v1alpha1
+
+// SQBDeployment及其下游资源的label，与selector保持一致
+const (
+	AppLabelKey   = "app"
+	PlaneLabelKey = "version"
+)
+
+// 透传到下游资源的annotation，value为json格式的map[string]string
+const (
+	DeploymentAnnotationKey      = "qa.shouqianba.com/passthrough-deployment"
+	PodAnnotationKey             = "qa.shouqianba.com/passthrough-pod"
+	ServiceAnnotationKey         = "qa.shouqianba.com/passthrough-service"
+	DestinationRuleAnnotationKey = "qa.shouqianba.com/passthrough-destinationrule"
+	VirtualServiceAnnotationKey  = "qa.shouqianba.com/passthrough-virtualservice"
+)
+
+// ServiceMonitorAnnotationKey servicemonitor的endpoints，value为json格式的数组
+const ServiceMonitorAnnotationKey = "qa.shouqianba.com/service-monitor"
+
+// 开关类的annotation，value为"true"或"false"
+const (
+	IstioInjectAnnotationKey = "qa.shouqianba.com/istio-inject"
+	IngressOpenAnnotationKey = "qa.shouqianba.com/ingress-open"
+	PublicEntryAnnotationKey = "qa.shouqianba.com/public-entry"
+)
+
+// 蓝绿发布的annotation，value为"true"，operator处理后删除
+const (
+	// BlueGreenPromoteAnnotationKey 新版本可用后把流量切到新版本，autoPromote为false时需要设置
+	BlueGreenPromoteAnnotationKey = "qa.shouqianba.com/blue-green-promote"
+	// BlueGreenAbortAnnotationKey 放弃新版本并删除新版本的deployment，流量保持在当前版本
+	BlueGreenAbortAnnotationKey = "qa.shouqianba.com/blue-green-abort"
+)
+
+// ColorLabelKey 蓝绿发布时pod的颜色，service的selector按颜色切换流量
+const ColorLabelKey = "qa.shouqianba.com/color"
@@ -0,0 +1,27 @@
+package v1alpha1
+
+// status.conditions的type，CI可以通过 kubectl wait --for=condition=Ready 等待资源就绪
+const (
+	// ConditionReady 资源已就绪，SQBDeployment表示对应的deployment已经完成滚动更新
+	ConditionReady = "Ready"
+	// ConditionReconciled 最近一次调和是否成功
+	ConditionReconciled = "Reconciled"
+	// ConditionDegraded 调和失败，reason记录失败的步骤
+	ConditionDegraded = "Degraded"
+	// ConditionDeleting 已经明确删除，正在清理下游资源
+	ConditionDeleting = "Deleting"
+)
+
+// status.conditions的reason
+const (
+	ReasonReconcileSuccess      = "ReconcileSuccess"
+	ReasonReconcileFailed       = "ReconcileFailed"
+	ReasonInitializeFailed      = "InitializeFailed"
+	ReasonExplicitDelete        = "ExplicitDelete"
+	ReasonDeploymentAvailable   = "DeploymentAvailable"
+	ReasonDeploymentProgressing = "DeploymentProgressing"
+	// ReasonBlueGreenPaused 蓝绿发布的新版本已经可用，等待qa.shouqianba.com/blue-green-promote注解
+	ReasonBlueGreenPaused = "BlueGreenPaused"
+	// ReasonBlueGreenAborted 蓝绿发布的新版本被放弃，修改spec后重新发布
+	ReasonBlueGreenAborted = "BlueGreenAborted"
+)
@@ -18,6 +18,7 @@ package v1alpha1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -32,6 +33,8 @@ type SQBApplicationSpec struct {
 	IngressSpec `json:",inline"`
 	ServiceSpec `json:",inline"`
 	DeploySpec  `json:",inline"`
+	// TrafficPolicy 开启网格注入时应用的流量策略，可以在sqbdeployment中按环境覆盖
+	TrafficPolicy *TrafficPolicy `json:"trafficPolicy,omitempty"`
 }
 
 type IngressSpec struct {
@@ -43,6 +46,22 @@ type Domain struct {
 	Class      string            `json:"class"`
 	Annotation map[string]string `json:"annotation,omitempty"`
 	Host       string            `json:"host,omitempty"`
+	TLS        *DomainTLS        `json:"tls,omitempty"`
+}
+
+// DomainTLS 使用已有的证书secret，或者由cert-manager签发证书，二选一
+type DomainTLS struct {
+	SecretName  string          `json:"secretName,omitempty"`
+	CertManager *CertManagerTLS `json:"certManager,omitempty"`
+}
+
+// CertManagerTLS operator配置开启cert-manager时，为域名创建Certificate
+type CertManagerTLS struct {
+	// Issuer 签发证书的issuer，默认使用operator配置中的issuer
+	Issuer string `json:"issuer,omitempty"`
+	// IssuerKind 默认使用operator配置中的kind，都没有时为Issuer
+	// +kubebuilder:validation:Enum=Issuer;ClusterIssuer
+	IssuerKind string `json:"issuerKind,omitempty"`
 }
 
 type Subpath struct {
@@ -54,33 +73,160 @@ type Subpath struct {
 
 type ServiceSpec struct {
 	Ports []corev1.ServicePort `json:"ports"`
+	// Service 生成的service的配置，没有配置时保留service上已有的配置
+	Service *ServiceConfig `json:"service,omitempty"`
 }
 
+// ServiceConfig service的类型、会话保持等配置，annotations优先于passthrough-service注解
+type ServiceConfig struct {
+	// Type 默认ClusterIP，Headless表示clusterIP为None的ClusterIP service，与其他类型互相切换时会重建service
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer;Headless
+	Type string `json:"type,omitempty"`
+	// +kubebuilder:validation:Enum=None;ClientIP
+	SessionAffinity corev1.ServiceAffinity `json:"sessionAffinity,omitempty"`
+	// SessionAffinityTimeoutSeconds sessionAffinity为ClientIP时会话保持的时间
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=86400
+	SessionAffinityTimeoutSeconds *int32 `json:"sessionAffinityTimeoutSeconds,omitempty"`
+	// ExternalTrafficPolicy 只对NodePort和LoadBalancer生效
+	// +kubebuilder:validation:Enum=Cluster;Local
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType `json:"externalTrafficPolicy,omitempty"`
+	IPFamilies            []corev1.IPFamily                       `json:"ipFamilies,omitempty"`
+	IPFamilyPolicy        *corev1.IPFamilyPolicyType              `json:"ipFamilyPolicy,omitempty"`
+	Labels                map[string]string                       `json:"labels,omitempty"`
+	Annotations           map[string]string                       `json:"annotations,omitempty"`
+}
+
+// ServiceTypeHeadless clusterIP为None的ClusterIP service
+const ServiceTypeHeadless = "Headless"
+
 type DeploySpec struct {
-	Replicas     *int32                       `json:"replicas,omitempty"`
+	Replicas       *int32                       `json:"replicas,omitempty"`
+	Image          string                       `json:"image,omitempty"`
+	Command        []string                     `json:"command,omitempty"`
+	Args           []string                     `json:"args,omitempty"`
+	HostAlias      []corev1.HostAlias           `json:"hostAliases,omitempty"`
+	Resources      *corev1.ResourceRequirements `json:"resources,omitempty"`
+	Env            []corev1.EnvVar              `json:"env,omitempty"`
+	EnvFrom        []corev1.EnvFromSource       `json:"envFrom,omitempty"`
+	HealthCheck    *corev1.Probe                `json:"healthCheck,omitempty"`
+	LivenessProbe  *corev1.Probe                `json:"livenessProbe,omitempty"`
+	ReadinessProbe *corev1.Probe                `json:"readinessProbe,omitempty"`
+	StartupProbe   *corev1.Probe                `json:"startupProbe,omitempty"`
+	Volumes        []*VolumeSpec                `json:"volumes,omitempty"`
+	NodeAffinity   *NodeAffinity                `json:"nodeAffinity,omitempty"`
+	Lifecycle      *Lifecycle                   `json:"lifecycle,omitempty"`
+	Sidecars       []SidecarSpec                `json:"sidecars,omitempty"`
+	InitContainers []InitContainerSpec          `json:"initContainers,omitempty"`
+	// 调度相关配置，没有配置时使用operator配置中的默认值
+	Tolerations               []corev1.Toleration               `json:"tolerations,omitempty"`
+	NodeSelector              map[string]string                 `json:"nodeSelector,omitempty"`
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+	PodAntiAffinity           *PodAntiAffinitySpec              `json:"podAntiAffinity,omitempty"`
+	// 安全配置，没有配置时使用operator配置中默认的security profile，securityContext同时应用到初始化容器和sidecar
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+	SecurityContext    *corev1.SecurityContext    `json:"securityContext,omitempty"`
+	// StartupTimeoutSeconds 由healthCheck生成startupProbe时，允许的最长启动时间，默认使用operator配置
+	// +kubebuilder:validation:Minimum=1
+	StartupTimeoutSeconds *int32 `json:"startupTimeoutSeconds,omitempty"`
+	// Unset 合并时从继承的env、hostAliases和volumes中删除的key
+	Unset *UnsetSpec `json:"unset,omitempty"`
+}
+
+// UnsetSpec env按name、hostAliases按ip、volumes按mountPath删除继承的配置，
+// sidecars和initContainers按name、envFrom按引用的configMap或secret的名字删除继承的配置
+type UnsetSpec struct {
+	Env            []string `json:"env,omitempty"`
+	HostAliases    []string `json:"hostAliases,omitempty"`
+	Volumes        []string `json:"volumes,omitempty"`
+	EnvFrom        []string `json:"envFrom,omitempty"`
+	Sidecars       []string `json:"sidecars,omitempty"`
+	InitContainers []string `json:"initContainers,omitempty"`
+}
+
+// SidecarSpec 与业务容器运行在同一个pod中的辅助容器，如日志采集、本地代理、缓存agent
+type SidecarSpec struct {
+	Name           string                       `json:"name"`
+	Image          string                       `json:"image"`
+	Command        []string                     `json:"command,omitempty"`
+	Args           []string                     `json:"args,omitempty"`
+	Env            []corev1.EnvVar              `json:"env,omitempty"`
+	Resources      *corev1.ResourceRequirements `json:"resources,omitempty"`
+	Ports          []corev1.ContainerPort       `json:"ports,omitempty"`
+	LivenessProbe  *corev1.Probe                `json:"livenessProbe,omitempty"`
+	ReadinessProbe *corev1.Probe                `json:"readinessProbe,omitempty"`
+	StartupProbe   *corev1.Probe                `json:"startupProbe,omitempty"`
+	VolumeMounts   []ContainerVolumeMount       `json:"volumeMounts,omitempty"`
+	// SecurityContext 默认与业务容器相同
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+}
+
+// InitContainerSpec 初始化容器，在业务容器启动前按顺序运行，位于lifecycle.init生成的init0之后
+type InitContainerSpec struct {
+	Name string `json:"name"`
+	// Image 默认使用sqbdeployment的qa.shouqianba.com/init-container-image注解，其次是operator配置中的镜像
 	Image        string                       `json:"image,omitempty"`
 	Command      []string                     `json:"command,omitempty"`
 	Args         []string                     `json:"args,omitempty"`
-	HostAlias    []corev1.HostAlias           `json:"hostAliases,omitempty"`
-	Resources    *corev1.ResourceRequirements `json:"resources,omitempty"`
 	Env          []corev1.EnvVar              `json:"env,omitempty"`
-	HealthCheck  *corev1.Probe                `json:"healthCheck,omitempty"`
-	Volumes      []*VolumeSpec                `json:"volumes,omitempty"`
-	NodeAffinity *NodeAffinity                `json:"nodeAffinity,omitempty"`
-	Lifecycle    *Lifecycle                   `json:"lifecycle,omitempty"`
+	Resources    *corev1.ResourceRequirements `json:"resources,omitempty"`
+	VolumeMounts []ContainerVolumeMount       `json:"volumeMounts,omitempty"`
+	// SecurityContext 默认与业务容器相同
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
 }
 
+// ContainerVolumeMount sidecar和初始化容器挂载volume
+type ContainerVolumeMount struct {
+	// Volume 引用volumes中声明的volume，值为该volume的mountPath
+	Volume string `json:"volume"`
+	// MountPath 容器中的挂载路径，默认与volume相同
+	MountPath string `json:"mountPath,omitempty"`
+	SubPath   string `json:"subPath,omitempty"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+}
+
+// VolumeSpec 每个volume只能声明一种来源，items只对configMap和secret生效，emptyDirMedium和emptyDirSizeLimit只对emptyDir生效
 type VolumeSpec struct {
-	MountPath                 string            `json:"mountPath"`
-	HostPath                  string            `json:"hostPath,omitempty"`
-	ConfigMap                 string            `json:"configMap,omitempty"`
-	Secret                    string            `json:"secret,omitempty"`
-	EmptyDir                  bool              `json:"emptyDir,omitempty"`
-	PersistentVolumeClaim     bool              `json:"persistentVolumeClaim,omitempty"`
-	PersistentVolumeClaimName string            `json:"persistentVolumeClaimName,omitempty"`
-	DownwardAPI               []DownwardAPIFile `json:"downwardAPI,omitempty"`
+	MountPath                 string                        `json:"mountPath"`
+	SubPath                   string                        `json:"subPath,omitempty"`
+	ReadOnly                  bool                          `json:"readOnly,omitempty"`
+	HostPath                  string                        `json:"hostPath,omitempty"`
+	ConfigMap                 string                        `json:"configMap,omitempty"`
+	Secret                    string                        `json:"secret,omitempty"`
+	Items                     []corev1.KeyToPath            `json:"items,omitempty"`
+	EmptyDir                  bool                          `json:"emptyDir,omitempty"`
+	EmptyDirMedium            corev1.StorageMedium          `json:"emptyDirMedium,omitempty"`
+	EmptyDirSizeLimit         *resource.Quantity            `json:"emptyDirSizeLimit,omitempty"`
+	PersistentVolumeClaim     bool                          `json:"persistentVolumeClaim,omitempty"`
+	PersistentVolumeClaimName string                        `json:"persistentVolumeClaimName,omitempty"`
+	DownwardAPI               []DownwardAPIFile             `json:"downwardAPI,omitempty"`
+	Projected                 *corev1.ProjectedVolumeSource `json:"projected,omitempty"`
+	CSI                       *corev1.CSIVolumeSource       `json:"csi,omitempty"`
+	// PVC相关配置，只对operator创建的PVC(persistentVolumeClaim: true)生效，没有配置时使用operator配置中的默认值
+	// Size 只能扩容，不能缩容
+	Size             *resource.Quantity                  `json:"size,omitempty"`
+	StorageClassName *string                             `json:"storageClassName,omitempty"`
+	AccessModes      []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+	VolumeMode       *corev1.PersistentVolumeMode        `json:"volumeMode,omitempty"`
+	// RetentionPolicy 默认Delete，Retain时volume不再使用或者sqbdeployment删除后保留PVC
+	// +kubebuilder:validation:Enum=Retain;Delete
+	RetentionPolicy PVCRetentionPolicy `json:"retentionPolicy,omitempty"`
+	// CloneFrom 特性环境新建PVC时，从基础环境同一个mountPath的PVC复制数据
+	// +kubebuilder:validation:Enum=base
+	CloneFrom string `json:"cloneFrom,omitempty"`
 }
 
+// PVCRetentionPolicy volume不再使用或者sqbdeployment删除时PVC的处理方式
+type PVCRetentionPolicy string
+
+const (
+	PVCRetentionPolicyDelete PVCRetentionPolicy = "Delete"
+	PVCRetentionPolicyRetain PVCRetentionPolicy = "Retain"
+)
+
+// CloneFromBase 从基础环境复制PVC的数据
+const CloneFromBase = "base"
+
 type DownwardAPIFile struct {
 	FileName  string `json:"fileName"`
 	FieldPath string `json:"fieldPath"`
@@ -91,6 +237,14 @@ type NodeAffinity struct {
 	Prefer  []NodeSelector `json:"prefer,omitempty"`
 }
 
+// PodAntiAffinitySpec 默认按app在hostname上设置preferred反亲和，可以关闭或者替换
+type PodAntiAffinitySpec struct {
+	// Disabled 不设置反亲和
+	Disabled bool `json:"disabled,omitempty"`
+	// Custom 使用自定义的反亲和替换默认配置
+	Custom *corev1.PodAntiAffinity `json:"custom,omitempty"`
+}
+
 type NodeSelector struct {
 	// +kubebuilder:default:=100
 	Weight                         int32 `json:"weight"`
@@ -106,18 +260,69 @@ type InitHandler struct {
 	Exec *corev1.ExecAction `json:"exec"`
 }
 
+// TrafficPolicy 网格的超时、重试、连接池和异常点检测配置，没有配置的字段使用operator的默认配置
+type TrafficPolicy struct {
+	// Timeout 请求超时时间，默认使用operator配置中的istioTimeout
+	Timeout          *metav1.Duration        `json:"timeout,omitempty"`
+	Retries          *RetryPolicy            `json:"retries,omitempty"`
+	ConnectionPool   *ConnectionPoolPolicy   `json:"connectionPool,omitempty"`
+	OutlierDetection *OutlierDetectionPolicy `json:"outlierDetection,omitempty"`
+}
+
+type RetryPolicy struct {
+	// Attempts 重试次数，0表示不重试
+	// +kubebuilder:validation:Minimum=0
+	Attempts      int32            `json:"attempts"`
+	PerTryTimeout *metav1.Duration `json:"perTryTimeout,omitempty"`
+	// RetryOn 重试的条件，如5xx,connect-failure
+	RetryOn string `json:"retryOn,omitempty"`
+}
+
+// ConnectionPoolPolicy 连接池限制，超过限制的请求会被熔断
+type ConnectionPoolPolicy struct {
+	// +kubebuilder:validation:Minimum=1
+	MaxConnections int32 `json:"maxConnections,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	MaxPendingRequests int32 `json:"maxPendingRequests,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	MaxRequests int32 `json:"maxRequests,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	MaxRequestsPerConnection int32 `json:"maxRequestsPerConnection,omitempty"`
+}
+
+// OutlierDetectionPolicy 连续返回5xx的pod被驱逐出负载均衡池
+type OutlierDetectionPolicy struct {
+	// +kubebuilder:validation:Minimum=0
+	Consecutive5xxErrors *int32           `json:"consecutive5xxErrors,omitempty"`
+	Interval             *metav1.Duration `json:"interval,omitempty"`
+	BaseEjectionTime     *metav1.Duration `json:"baseEjectionTime,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	MaxEjectionPercent int32 `json:"maxEjectionPercent,omitempty"`
+}
+
 // SQBApplicationStatus defines the observed state of SQBApplication
 type SQBApplicationStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	Planes    map[string]int `json:"planes,omitempty"`
-	Mirrors   map[string]int `json:"mirrors,omitempty"`
-	ErrorInfo string         `json:"errorInfo,omitempty"`
+	Planes  map[string]int `json:"planes,omitempty"`
+	Mirrors map[string]int `json:"mirrors,omitempty"`
+	// ObservedGeneration 最近一次调和的generation
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// +listType=map
+	// +listMapKey=type
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].reason"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // SQBApplication is the Schema for the sqbapplications API
 type SQBApplication struct {
@@ -148,6 +353,12 @@ func (old *SQBApplication) Merge(news *SQBApplication) {
 	old.Spec.Subpaths = news.Spec.Subpaths
 	// ports用新的覆盖
 	old.Spec.Ports = news.Spec.Ports
+	if news.Spec.Service != nil {
+		old.Spec.Service = news.Spec.Service
+	}
+	if news.Spec.TrafficPolicy != nil {
+		old.Spec.TrafficPolicy = news.Spec.TrafficPolicy
+	}
 	// deploy去重
 	old.Spec.DeploySpec.merge(&news.Spec.DeploySpec)
 }
@@ -165,23 +376,119 @@ func (old *DeploySpec) merge(news *DeploySpec) {
 	if len(news.Args) != 0 {
 		old.Args = news.Args
 	}
-	if len(news.HostAlias) != 0 {
-		old.HostAlias = news.HostAlias
+	// env、hostAliases和volumes按key合并，先删除unset中的key
+	var unset UnsetSpec
+	if news.Unset != nil {
+		unset = *news.Unset
+		if old.Unset == nil {
+			old.Unset = &UnsetSpec{}
+		}
+		old.Unset.Env = mergeStrings(old.Unset.Env, unset.Env)
+		old.Unset.HostAliases = mergeStrings(old.Unset.HostAliases, unset.HostAliases)
+		old.Unset.Volumes = mergeStrings(old.Unset.Volumes, unset.Volumes)
+		old.Unset.EnvFrom = mergeStrings(old.Unset.EnvFrom, unset.EnvFrom)
+		old.Unset.Sidecars = mergeStrings(old.Unset.Sidecars, unset.Sidecars)
+		old.Unset.InitContainers = mergeStrings(old.Unset.InitContainers, unset.InitContainers)
 	}
+	old.HostAlias = mergeByKey(old.HostAlias, news.HostAlias,
+		func(hostAlias corev1.HostAlias) string { return hostAlias.IP }, unset.HostAliases)
 	if news.Resources != nil {
 		old.Resources = news.Resources
 	}
-	if len(news.Env) != 0 {
-		old.Env = news.Env
+	old.Env = mergeByKey(old.Env, news.Env, func(env corev1.EnvVar) string { return env.Name }, unset.Env)
+	// envFrom、sidecars和initContainers声明时整体覆盖，没有声明时继承的配置中删除unset中的key
+	old.EnvFrom = mergeByKey(old.EnvFrom, nil, envFromName, unset.EnvFrom)
+	if len(news.EnvFrom) != 0 {
+		old.EnvFrom = news.EnvFrom
+	}
+	if news.HealthCheck != nil {
+		old.HealthCheck = news.HealthCheck
+	}
+	if news.LivenessProbe != nil {
+		old.LivenessProbe = news.LivenessProbe
+	}
+	if news.ReadinessProbe != nil {
+		old.ReadinessProbe = news.ReadinessProbe
+	}
+	if news.StartupProbe != nil {
+		old.StartupProbe = news.StartupProbe
+	}
+	if news.StartupTimeoutSeconds != nil {
+		old.StartupTimeoutSeconds = news.StartupTimeoutSeconds
 	}
-	old.HealthCheck = news.HealthCheck
-	if len(news.Volumes) != 0 {
-		old.Volumes = news.Volumes
+	if len(news.Tolerations) != 0 {
+		old.Tolerations = news.Tolerations
 	}
+	if len(news.NodeSelector) != 0 {
+		old.NodeSelector = news.NodeSelector
+	}
+	if len(news.TopologySpreadConstraints) != 0 {
+		old.TopologySpreadConstraints = news.TopologySpreadConstraints
+	}
+	if news.PodAntiAffinity != nil {
+		old.PodAntiAffinity = news.PodAntiAffinity
+	}
+	if news.PodSecurityContext != nil {
+		old.PodSecurityContext = news.PodSecurityContext
+	}
+	if news.SecurityContext != nil {
+		old.SecurityContext = news.SecurityContext
+	}
+	old.Volumes = mergeByKey(old.Volumes, news.Volumes,
+		func(volume *VolumeSpec) string { return volume.MountPath }, unset.Volumes)
 	if news.NodeAffinity != nil {
 		old.NodeAffinity = news.NodeAffinity
 	}
-	old.Lifecycle = news.Lifecycle
+	if news.Lifecycle != nil {
+		old.Lifecycle = news.Lifecycle
+	}
+	old.Sidecars = mergeByKey(old.Sidecars, nil, func(sidecar SidecarSpec) string { return sidecar.Name }, unset.Sidecars)
+	if len(news.Sidecars) != 0 {
+		old.Sidecars = news.Sidecars
+	}
+	old.InitContainers = mergeByKey(old.InitContainers, nil,
+		func(initContainer InitContainerSpec) string { return initContainer.Name }, unset.InitContainers)
+	if len(news.InitContainers) != 0 {
+		old.InitContainers = news.InitContainers
+	}
+}
+
+// envFromName envFrom引用的configMap或secret的名字
+func envFromName(envFrom corev1.EnvFromSource) string {
+	if envFrom.ConfigMapRef != nil {
+		return envFrom.ConfigMapRef.Name
+	}
+	if envFrom.SecretRef != nil {
+		return envFrom.SecretRef.Name
+	}
+	return ""
+}
+
+// Override 环境的流量策略按timeout、retries、connectionPool和outlierDetection覆盖应用的配置
+func (p *TrafficPolicy) Override(plane *TrafficPolicy) *TrafficPolicy {
+	if p == nil && plane == nil {
+		return nil
+	}
+	policy := &TrafficPolicy{}
+	if p != nil {
+		policy = p.DeepCopy()
+	}
+	if plane == nil {
+		return policy
+	}
+	if plane.Timeout != nil {
+		policy.Timeout = plane.Timeout.DeepCopy()
+	}
+	if plane.Retries != nil {
+		policy.Retries = plane.Retries.DeepCopy()
+	}
+	if plane.ConnectionPool != nil {
+		policy.ConnectionPool = plane.ConnectionPool.DeepCopy()
+	}
+	if plane.OutlierDetection != nil {
+		policy.OutlierDetection = plane.OutlierDetection.DeepCopy()
+	}
+	return policy
 }
 
 func init() {
@@ -6,6 +6,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"testing"
+	"time"
 )
 
 func TestAnnotation(t *testing.T) {
@@ -125,9 +126,24 @@ func TestEnv(t *testing.T) {
 		},
 	}
 	old.Merge(news)
+	assert.Equal(t, len(old.Spec.Env), 2)
+	assert.Equal(t, old.Spec.Env[0].Name, "a")
+	assert.Equal(t, old.Spec.Env[1].Name, "b")
+	assert.Equal(t, old.Spec.Env[1].Value, "2")
+
+	// 相同name原位覆盖，unset删除继承的key
+	news = &SQBApplication{
+		Spec: SQBApplicationSpec{
+			DeploySpec: DeploySpec{
+				Env:   []v1.EnvVar{{Name: "b", Value: "3"}},
+				Unset: &UnsetSpec{Env: []string{"a"}},
+			},
+		},
+	}
+	old.Merge(news)
 	assert.Equal(t, len(old.Spec.Env), 1)
 	assert.Equal(t, old.Spec.Env[0].Name, "b")
-	assert.Equal(t, old.Spec.Env[0].Value, "2")
+	assert.Equal(t, old.Spec.Env[0].Value, "3")
 }
 
 func TestVolume(t *testing.T) {
@@ -157,11 +173,29 @@ func TestVolume(t *testing.T) {
 		},
 	}
 	old.Merge(news)
+	assert.Equal(t, len(old.Spec.Volumes), 2)
+	assert.Equal(t, old.Spec.Volumes[0].MountPath, "/path1")
+	assert.Equal(t, old.Spec.Volumes[1].MountPath, "/path2")
+	assert.Equal(t, old.Spec.Volumes[1].HostPath, "/host2")
+
+	news = &SQBApplication{
+		Spec: SQBApplicationSpec{
+			DeploySpec: DeploySpec{
+				Volumes: []*VolumeSpec{
+					{
+						MountPath: "/path2",
+						ConfigMap: "configmap2",
+					},
+				},
+				Unset: &UnsetSpec{Volumes: []string{"/path1"}},
+			},
+		},
+	}
+	old.Merge(news)
 	assert.Equal(t, len(old.Spec.Volumes), 1)
 	assert.Equal(t, old.Spec.Volumes[0].MountPath, "/path2")
-	assert.Equal(t, old.Spec.Volumes[0].ConfigMap, "")
-	assert.Equal(t, old.Spec.Volumes[0].HostPath, "/host2")
-
+	assert.Equal(t, old.Spec.Volumes[0].ConfigMap, "configmap2")
+	assert.Equal(t, old.Spec.Volumes[0].HostPath, "")
 }
 
 func TestPorts(t *testing.T) {
@@ -193,3 +227,80 @@ func TestPorts(t *testing.T) {
 	assert.Equal(t, len(old.Spec.Ports), 1)
 	assert.Equal(t, old.Spec.Ports[0].Port, int32(8080))
 }
+
+func TestEffectiveDeploySpec(t *testing.T) {
+	app := &SQBApplication{
+		Spec: SQBApplicationSpec{
+			DeploySpec: DeploySpec{
+				Image:       "app:1",
+				Replicas:    proto.Int(2),
+				HealthCheck: &v1.Probe{InitialDelaySeconds: 10},
+				Env:         []v1.EnvVar{{Name: "a", Value: "1"}},
+			},
+		},
+	}
+	deployment := &SQBDeployment{
+		Spec: SQBDeploymentSpec{
+			DeploySpec: DeploySpec{
+				Image: "app:2",
+			},
+		},
+	}
+	spec := deployment.EffectiveDeploySpec(app)
+	assert.Equal(t, spec.Image, "app:2")
+	assert.Equal(t, *spec.Replicas, int32(2))
+	assert.Equal(t, spec.HealthCheck.InitialDelaySeconds, int32(10))
+	assert.Equal(t, spec.Env[0].Name, "a")
+	// 不修改sqbapplication
+	assert.Equal(t, app.Spec.Image, "app:1")
+
+	// sqbdeployment修改一个env时保留继承的env
+	app.Spec.Env = append(app.Spec.Env, v1.EnvVar{Name: "b", Value: "2"})
+	app.Spec.HostAlias = []v1.HostAlias{{IP: "10.0.0.1", Hostnames: []string{"db"}}}
+	deployment.Spec.Env = []v1.EnvVar{{Name: "b", Value: "3"}}
+	deployment.Spec.Unset = &UnsetSpec{HostAliases: []string{"10.0.0.1"}}
+	spec = deployment.EffectiveDeploySpec(app)
+	assert.DeepEqual(t, spec.Env, []v1.EnvVar{{Name: "a", Value: "1"}, {Name: "b", Value: "3"}})
+	assert.Equal(t, len(spec.HostAlias), 0)
+	assert.Equal(t, len(app.Spec.HostAlias), 1)
+
+	// sidecars、initContainers和envFrom按key删除继承的配置
+	app.Spec.Sidecars = []SidecarSpec{{Name: "filebeat", Image: "filebeat"}, {Name: "envoy", Image: "envoy"}}
+	app.Spec.InitContainers = []InitContainerSpec{{Name: "migrate"}}
+	app.Spec.EnvFrom = []v1.EnvFromSource{
+		{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "config"}}},
+		{SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "secret"}}},
+	}
+	deployment.Spec.Unset = &UnsetSpec{Sidecars: []string{"filebeat"}, InitContainers: []string{"migrate"}, EnvFrom: []string{"secret"}}
+	spec = deployment.EffectiveDeploySpec(app)
+	assert.DeepEqual(t, spec.Sidecars, []SidecarSpec{{Name: "envoy", Image: "envoy"}})
+	assert.Equal(t, len(spec.InitContainers), 0)
+	assert.Equal(t, len(spec.EnvFrom), 1)
+	assert.Equal(t, spec.EnvFrom[0].ConfigMapRef.Name, "config")
+	assert.Equal(t, len(app.Spec.Sidecars), 2)
+
+	// 生效配置的摘要
+	status := NewEffectiveDeployStatus(spec)
+	assert.Equal(t, status.Image, "app:2")
+	assert.Equal(t, *status.Replicas, int32(2))
+	assert.DeepEqual(t, status.Env, []string{"a", "b"})
+	assert.DeepEqual(t, status.Sidecars, []string{"envoy"})
+	assert.DeepEqual(t, status.EnvFrom, []string{"config"})
+	deployment.Spec.Image = "app:3"
+	assert.Assert(t, NewEffectiveDeployStatus(deployment.EffectiveDeploySpec(app)).Hash != status.Hash)
+}
+
+func TestTrafficPolicyOverride(t *testing.T) {
+	var empty *TrafficPolicy
+	assert.Assert(t, empty.Override(nil) == nil)
+	app := &TrafficPolicy{
+		Timeout: &metav1.Duration{Duration: 10 * time.Second},
+		Retries: &RetryPolicy{Attempts: 2},
+	}
+	policy := app.Override(&TrafficPolicy{Retries: &RetryPolicy{Attempts: 5, RetryOn: "5xx"}})
+	assert.Equal(t, policy.Timeout.Duration, 10*time.Second)
+	assert.Equal(t, policy.Retries.Attempts, int32(5))
+	// 不修改应用的配置
+	assert.Equal(t, app.Retries.Attempts, int32(2))
+	assert.Equal(t, empty.Override(app).Timeout.Duration, 10*time.Second)
+}
@@ -0,0 +1,361 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var sqbapplicationlog = logf.Log.WithName("sqbapplication-resource")
+
+// istio支持的协议，port name命名规则：{protocol}-{port}
+var istioProtocols = []string{"http", "http2", "https", "grpc", "grpc-web", "tcp", "tls", "mongo", "mysql", "redis", "udp"}
+
+// GatewayMode operator是否配置为gateway模式，由main函数设置
+var GatewayMode = func() bool {
+	return false
+}
+
+func (r *SQBApplication) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-qa-shouqianba-com-v1alpha1-sqbapplication,mutating=false,failurePolicy=fail,groups=qa.shouqianba.com,resources=sqbapplications,versions=v1alpha1,name=vsqbapplication.kb.io
+
+var _ webhook.Validator = &SQBApplication{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *SQBApplication) ValidateCreate() error {
+	sqbapplicationlog.Info("validate create", "name", r.Name)
+	return r.toError(r.validate())
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *SQBApplication) ValidateUpdate(old runtime.Object) error {
+	sqbapplicationlog.Info("validate update", "name", r.Name)
+	// 删除中的对象只会去掉finalizer，不校验
+	if r.DeletionTimestamp != nil {
+		return nil
+	}
+	// 存量对象可能不符合新增的校验规则，只拒绝这次更新引入的错误，保证operator可以继续更新
+	return r.toError(newErrors(r.validate(), old.(*SQBApplication).validate()))
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *SQBApplication) ValidateDelete() error {
+	return nil
+}
+
+func (r *SQBApplication) validate() field.ErrorList {
+	var allErrs field.ErrorList
+	allErrs = append(allErrs, validatePassthroughAnnotations(r.Annotations,
+		ServiceAnnotationKey, DestinationRuleAnnotationKey, VirtualServiceAnnotationKey)...)
+	if endpoints, ok := r.Annotations[ServiceMonitorAnnotationKey]; ok && endpoints != "" {
+		if err := json.Unmarshal([]byte(endpoints), &[]map[string]interface{}{}); err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("metadata", "annotations").Key(ServiceMonitorAnnotationKey),
+				endpoints, fmt.Sprintf("must be a json array of endpoints: %v", err)))
+		}
+	}
+	allErrs = append(allErrs, r.validatePorts()...)
+	allErrs = append(allErrs, r.validateIngress()...)
+	allErrs = append(allErrs, r.validateService()...)
+	allErrs = append(allErrs, validateSidecars(r.Spec.Sidecars, field.NewPath("spec", "sidecars"))...)
+	allErrs = append(allErrs, validateInitContainers(r.Spec.InitContainers, field.NewPath("spec", "initContainers"))...)
+	allErrs = append(allErrs, validateVolumes(r.Spec.Volumes, field.NewPath("spec", "volumes"))...)
+	return allErrs
+}
+
+func (r *SQBApplication) toError(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "SQBApplication"}, r.Name, allErrs)
+}
+
+// newErrors 返回oldErrs中不存在的错误
+func newErrors(allErrs, oldErrs field.ErrorList) field.ErrorList {
+	existing := make(map[string]struct{}, len(oldErrs))
+	for _, err := range oldErrs {
+		existing[err.Error()] = struct{}{}
+	}
+	var errs field.ErrorList
+	for _, err := range allErrs {
+		if _, ok := existing[err.Error()]; !ok {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func (r *SQBApplication) validatePorts() field.ErrorList {
+	var allErrs field.ErrorList
+	portsPath := field.NewPath("spec", "ports")
+	names := make(map[string]struct{})
+	ports := make(map[string]struct{})
+	for i, port := range r.Spec.Ports {
+		path := portsPath.Index(i)
+		if err := validatePortName(port.Name, port.Port, port.TargetPort.IntValue()); err != "" {
+			allErrs = append(allErrs, field.Invalid(path.Child("name"), port.Name, err))
+		}
+		if _, ok := names[port.Name]; ok {
+			allErrs = append(allErrs, field.Duplicate(path.Child("name"), port.Name))
+		}
+		names[port.Name] = struct{}{}
+		key := fmt.Sprintf("%d/%s", port.Port, port.Protocol)
+		if _, ok := ports[key]; ok {
+			allErrs = append(allErrs, field.Duplicate(path.Child("port"), port.Port))
+		}
+		ports[key] = struct{}{}
+	}
+	return allErrs
+}
+
+// validatePortName 校验port name是否符合{protocol}-{port}，port可以是port或者targetPort
+func validatePortName(name string, port int32, targetPort int) string {
+	index := strings.LastIndex(name, "-")
+	if index <= 0 {
+		return "must be in the form of {protocol}-{port}"
+	}
+	protocol, number := strings.ToLower(name[:index]), name[index+1:]
+	if !containString(istioProtocols, protocol) {
+		return fmt.Sprintf("protocol %q is not supported, must be one of %s", protocol, strings.Join(istioProtocols, ","))
+	}
+	if n, err := strconv.Atoi(number); err != nil || (int32(n) != port && n != targetPort) {
+		return fmt.Sprintf("port %q must be the port or targetPort number", number)
+	}
+	return ""
+}
+
+func (r *SQBApplication) validateIngress() field.ErrorList {
+	var allErrs field.ErrorList
+	for i, domain := range r.Spec.Domains {
+		path := field.NewPath("spec", "domains").Index(i)
+		if domain.Class == "" {
+			allErrs = append(allErrs, field.Required(path.Child("class"), "ingress class must not be empty"))
+		}
+		if tls := domain.TLS; tls != nil && (tls.SecretName == "") == (tls.CertManager == nil) {
+			allErrs = append(allErrs, field.Invalid(path.Child("tls"), "",
+				"exactly one of secretName and certManager must be specified"))
+		}
+		// gateway模式下证书配置在Gateway的listener上，HTTPRoute不能指定证书
+		if domain.TLS != nil && GatewayMode() {
+			allErrs = append(allErrs, field.Forbidden(path.Child("tls"),
+				"may not be specified in gateway mode, configure the certificate on the Gateway listener"))
+		}
+	}
+	for i, subpath := range r.Spec.Subpaths {
+		path := field.NewPath("spec", "subpaths").Index(i)
+		if subpath.ServicePort <= 0 || subpath.ServicePort > 65535 {
+			allErrs = append(allErrs, field.Invalid(path.Child("servicePort"), subpath.ServicePort,
+				"must be between 1 and 65535"))
+			continue
+		}
+		// 指向本服务的subpath，servicePort需要在ports中声明
+		if subpath.ServiceName == r.Name && !r.hasPort(subpath.ServicePort) {
+			allErrs = append(allErrs, field.NotFound(path.Child("servicePort"), subpath.ServicePort))
+		}
+	}
+	return allErrs
+}
+
+// validateService sessionAffinityTimeoutSeconds只对ClientIP生效，externalTrafficPolicy只对NodePort和LoadBalancer生效
+func (r *SQBApplication) validateService() field.ErrorList {
+	var allErrs field.ErrorList
+	service := r.Spec.Service
+	if service == nil {
+		return nil
+	}
+	path := field.NewPath("spec", "service")
+	if service.SessionAffinityTimeoutSeconds != nil && service.SessionAffinity != corev1.ServiceAffinityClientIP {
+		allErrs = append(allErrs, field.Forbidden(path.Child("sessionAffinityTimeoutSeconds"),
+			"may only be specified when sessionAffinity is ClientIP"))
+	}
+	if service.ExternalTrafficPolicy != "" && service.Type != string(corev1.ServiceTypeNodePort) &&
+		service.Type != string(corev1.ServiceTypeLoadBalancer) {
+		allErrs = append(allErrs, field.Forbidden(path.Child("externalTrafficPolicy"),
+			"may only be specified when type is NodePort or LoadBalancer"))
+	}
+	for _, key := range []string{AppLabelKey, PlaneLabelKey} {
+		if _, ok := service.Labels[key]; ok {
+			allErrs = append(allErrs, field.Forbidden(path.Child("labels").Key(key), "label is managed by the operator"))
+		}
+	}
+	return allErrs
+}
+
+func (r *SQBApplication) hasPort(number int) bool {
+	for _, port := range r.Spec.Ports {
+		if int(port.Port) == number || port.TargetPort.IntValue() == number {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePassthroughAnnotations 透传的annotation必须是json格式的map[string]string
+func validatePassthroughAnnotations(annotations map[string]string, keys ...string) field.ErrorList {
+	var allErrs field.ErrorList
+	for _, key := range keys {
+		value, ok := annotations[key]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal([]byte(value), &map[string]string{}); err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("metadata", "annotations").Key(key), value,
+				fmt.Sprintf("must be a json object of string values: %v", err)))
+		}
+	}
+	return allErrs
+}
+
+// validateSidecars sidecar的名字不能重复，也不能和业务容器重名
+func validateSidecars(sidecars []SidecarSpec, path *field.Path, reserved ...string) field.ErrorList {
+	var allErrs field.ErrorList
+	names := make(map[string]struct{})
+	for _, name := range reserved {
+		names[name] = struct{}{}
+	}
+	for i, sidecar := range sidecars {
+		sidecarPath := path.Index(i)
+		allErrs = append(allErrs, validateContainerName(sidecar.Name, sidecarPath.Child("name"), names)...)
+		if sidecar.Image == "" {
+			allErrs = append(allErrs, field.Required(sidecarPath.Child("image"), "sidecar image must not be empty"))
+		}
+		allErrs = append(allErrs, validateContainerVolumeMounts(sidecar.VolumeMounts, sidecarPath.Child("volumeMounts"))...)
+	}
+	return allErrs
+}
+
+// validateInitContainers 初始化容器的名字不能重复，init0由lifecycle.init使用
+func validateInitContainers(initContainers []InitContainerSpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	names := map[string]struct{}{"init0": {}}
+	for i, initContainer := range initContainers {
+		containerPath := path.Index(i)
+		allErrs = append(allErrs, validateContainerName(initContainer.Name, containerPath.Child("name"), names)...)
+		allErrs = append(allErrs, validateContainerVolumeMounts(initContainer.VolumeMounts,
+			containerPath.Child("volumeMounts"))...)
+	}
+	return allErrs
+}
+
+// validateVolumes mountPath不能重复，每个volume只能声明一种来源
+func validateVolumes(volumes []*VolumeSpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	mountPaths := make(map[string]struct{})
+	for i, volume := range volumes {
+		volumePath := path.Index(i)
+		if volume == nil {
+			allErrs = append(allErrs, field.Required(volumePath, "volume must not be null"))
+			continue
+		}
+		if volume.MountPath == "" {
+			allErrs = append(allErrs, field.Required(volumePath.Child("mountPath"), "mountPath must not be empty"))
+		} else if _, ok := mountPaths[volume.MountPath]; ok {
+			allErrs = append(allErrs, field.Duplicate(volumePath.Child("mountPath"), volume.MountPath))
+		}
+		mountPaths[volume.MountPath] = struct{}{}
+		var sources []string
+		for name, declared := range map[string]bool{
+			"hostPath":              volume.HostPath != "",
+			"configMap":             volume.ConfigMap != "",
+			"secret":                volume.Secret != "",
+			"emptyDir":              volume.EmptyDir,
+			"persistentVolumeClaim": volume.PersistentVolumeClaim || volume.PersistentVolumeClaimName != "",
+			"downwardAPI":           volume.DownwardAPI != nil,
+			"projected":             volume.Projected != nil,
+			"csi":                   volume.CSI != nil,
+		} {
+			if declared {
+				sources = append(sources, name)
+			}
+		}
+		if len(sources) > 1 {
+			sort.Strings(sources)
+			allErrs = append(allErrs, field.Forbidden(volumePath,
+				fmt.Sprintf("only one volume source may be specified, got %s", strings.Join(sources, ","))))
+		}
+		if len(volume.Items) != 0 && volume.ConfigMap == "" && volume.Secret == "" {
+			allErrs = append(allErrs, field.Forbidden(volumePath.Child("items"),
+				"items may only be specified for configMap or secret"))
+		}
+		if (volume.Size != nil || volume.StorageClassName != nil || len(volume.AccessModes) != 0 ||
+			volume.VolumeMode != nil || volume.RetentionPolicy != "" || volume.CloneFrom != "") &&
+			!volume.PersistentVolumeClaim {
+			allErrs = append(allErrs, field.Forbidden(volumePath.Child("persistentVolumeClaim"),
+				"size, storageClassName, accessModes, volumeMode, retentionPolicy and cloneFrom "+
+					"may only be specified for persistentVolumeClaim"))
+		}
+		if volume.Size != nil && volume.Size.Sign() <= 0 {
+			allErrs = append(allErrs, field.Invalid(volumePath.Child("size"), volume.Size.String(), "must be greater than 0"))
+		}
+		if (volume.EmptyDirMedium != "" || volume.EmptyDirSizeLimit != nil) && !volume.EmptyDir {
+			allErrs = append(allErrs, field.Forbidden(volumePath.Child("emptyDir"),
+				"emptyDirMedium and emptyDirSizeLimit may only be specified for emptyDir"))
+		}
+	}
+	return allErrs
+}
+
+func validateContainerName(name string, path *field.Path, names map[string]struct{}) field.ErrorList {
+	var allErrs field.ErrorList
+	for _, msg := range validation.IsDNS1123Label(name) {
+		allErrs = append(allErrs, field.Invalid(path, name, msg))
+	}
+	if _, ok := names[name]; ok {
+		allErrs = append(allErrs, field.Duplicate(path, name))
+	}
+	names[name] = struct{}{}
+	return allErrs
+}
+
+func validateContainerVolumeMounts(mounts []ContainerVolumeMount, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, mount := range mounts {
+		if mount.Volume == "" {
+			allErrs = append(allErrs, field.Required(path.Index(i).Child("volume"),
+				"mountPath of the referenced volume must not be empty"))
+		}
+	}
+	return allErrs
+}
+
+func containString(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package v1alpha1
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"gotest.tools/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func newValidApplication() *SQBApplication {
+	return &SQBApplication{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "demo",
+			Annotations: map[string]string{
+				ServiceAnnotationKey:        `{"type":"service"}`,
+				ServiceMonitorAnnotationKey: `[{"port": "http-8080", "interval": "15s", "path": "/metrics"}]`,
+			},
+		},
+		Spec: SQBApplicationSpec{
+			IngressSpec: IngressSpec{
+				Domains:  []Domain{{Class: "nginx"}},
+				Subpaths: []Subpath{{Path: "/v1", ServiceName: "demo", ServicePort: 80}},
+			},
+			ServiceSpec: ServiceSpec{
+				Ports: []v1.ServicePort{
+					{Name: "http-80", Port: 80, TargetPort: intstr.FromInt(8080), Protocol: v1.ProtocolTCP},
+					{Name: "grpc-web-9090", Port: 9090, TargetPort: intstr.FromInt(9090), Protocol: v1.ProtocolTCP},
+				},
+			},
+		},
+	}
+}
+
+func TestValidateApplication(t *testing.T) {
+	assert.NilError(t, newValidApplication().ValidateCreate())
+}
+
+func TestValidatePortName(t *testing.T) {
+	app := newValidApplication()
+	app.Spec.Ports[0].Name = "web"
+	err := app.ValidateCreate()
+	assert.ErrorContains(t, err, "spec.ports[0].name")
+
+	app = newValidApplication()
+	app.Spec.Ports[0].Name = "foo-80"
+	assert.ErrorContains(t, app.ValidateCreate(), "protocol \"foo\" is not supported")
+
+	app = newValidApplication()
+	app.Spec.Ports[0].Name = "http-81"
+	assert.ErrorContains(t, app.ValidateCreate(), "spec.ports[0].name")
+
+	// 使用targetPort命名
+	app = newValidApplication()
+	app.Spec.Ports[0].Name = "http-8080"
+	assert.NilError(t, app.ValidateCreate())
+}
+
+func TestValidateUpdateLegacy(t *testing.T) {
+	old := newValidApplication()
+	old.Spec.Ports[0].Name = "web"
+	old.Annotations[ServiceAnnotationKey] = "not json"
+
+	// 存量的错误不影响operator添加finalizer
+	app := old.DeepCopy()
+	app.Finalizers = []string{"qa.shouqianba.com/finalizer"}
+	assert.NilError(t, app.ValidateUpdate(old))
+
+	// 新引入的错误仍然拒绝
+	app.Spec.Ports[1].Name = "foo-9090"
+	err := app.ValidateUpdate(old)
+	assert.ErrorContains(t, err, "spec.ports[1].name")
+	assert.Assert(t, !strings.Contains(err.Error(), "spec.ports[0].name"))
+
+	// 删除中的对象只去掉finalizer，不校验
+	now := metav1.Now()
+	app.DeletionTimestamp = &now
+	app.Finalizers = nil
+	assert.NilError(t, app.ValidateUpdate(old))
+}
+
+func TestValidateDuplicatePorts(t *testing.T) {
+	app := newValidApplication()
+	app.Spec.Ports[1] = app.Spec.Ports[0]
+	err := app.ValidateCreate()
+	assert.ErrorContains(t, err, "spec.ports[1].name: Duplicate value")
+	assert.ErrorContains(t, err, "spec.ports[1].port: Duplicate value")
+}
+
+func TestValidateIngress(t *testing.T) {
+	app := newValidApplication()
+	app.Spec.Domains[0].Class = ""
+	assert.ErrorContains(t, app.ValidateCreate(), "spec.domains[0].class: Required value")
+
+	app = newValidApplication()
+	app.Spec.Subpaths[0].ServicePort = 81
+	assert.ErrorContains(t, app.ValidateCreate(), "spec.subpaths[0].servicePort: Not found")
+
+	// 其他服务的端口不校验
+	app.Spec.Subpaths[0].ServiceName = "other"
+	assert.NilError(t, app.ValidateCreate())
+}
+
+func TestValidatePassthroughAnnotations(t *testing.T) {
+	app := newValidApplication()
+	app.Annotations[ServiceAnnotationKey] = `{"type":1}`
+	app.Annotations[ServiceMonitorAnnotationKey] = `{"port": "http-8080"}`
+	err := app.ValidateCreate()
+	assert.Assert(t, strings.Contains(err.Error(), ServiceAnnotationKey))
+	assert.Assert(t, strings.Contains(err.Error(), ServiceMonitorAnnotationKey))
+
+	deployment := &SQBDeployment{ObjectMeta: metav1.ObjectMeta{
+		Name:        "demo-base",
+		Annotations: map[string]string{PodAnnotationKey: "not json"},
+	}}
+	assert.ErrorContains(t, deployment.ValidateCreate(), PodAnnotationKey)
+}
+
+func TestValidateSidecars(t *testing.T) {
+	app := newValidApplication()
+	app.Spec.Sidecars = []SidecarSpec{
+		{Name: "filebeat", Image: "filebeat:7"},
+		{Name: "filebeat"},
+		{Name: "Envoy", Image: "envoy", VolumeMounts: []ContainerVolumeMount{{MountPath: "/data"}}},
+	}
+	err := app.ValidateCreate()
+	assert.ErrorContains(t, err, "spec.sidecars[1].name: Duplicate value")
+	assert.ErrorContains(t, err, "spec.sidecars[1].image: Required value")
+	assert.ErrorContains(t, err, "spec.sidecars[2].name: Invalid value")
+	assert.ErrorContains(t, err, "spec.sidecars[2].volumeMounts[0].volume: Required value")
+
+	deployment := newSQBDeployment("demo", "base")
+	deployment.Spec.Sidecars = []SidecarSpec{{Name: "demo-base", Image: "proxy"}}
+	assert.ErrorContains(t, deployment.ValidateUpdate(newSQBDeployment("demo", "base")),
+		"spec.sidecars[0].name: Duplicate value")
+}
+
+func TestValidateInitContainers(t *testing.T) {
+	app := newValidApplication()
+	app.Spec.InitContainers = []InitContainerSpec{
+		{Name: "init0"},
+		{Name: "migrate", Image: "flyway"},
+		{Name: "migrate"},
+	}
+	err := app.ValidateCreate()
+	assert.ErrorContains(t, err, "spec.initContainers[0].name: Duplicate value")
+	assert.ErrorContains(t, err, "spec.initContainers[2].name: Duplicate value")
+	assert.Assert(t, !strings.Contains(err.Error(), "spec.initContainers[1]"))
+}
+
+func TestValidateVolumes(t *testing.T) {
+	app := newValidApplication()
+	app.Spec.Volumes = []*VolumeSpec{
+		{MountPath: "/etc/config", ConfigMap: "config", Items: []v1.KeyToPath{{Key: "a", Path: "a.yaml"}}},
+		{MountPath: "/etc/config", Secret: "secret", HostPath: "/tmp"},
+		{MountPath: "/data", HostPath: "/data", Items: []v1.KeyToPath{{Key: "a", Path: "a"}}, EmptyDirMedium: v1.StorageMediumMemory},
+	}
+	err := app.ValidateCreate()
+	assert.ErrorContains(t, err, "spec.volumes[1].mountPath: Duplicate value")
+	assert.ErrorContains(t, err, "only one volume source may be specified, got hostPath,secret")
+	assert.ErrorContains(t, err, "spec.volumes[2].items: Forbidden")
+	assert.ErrorContains(t, err, "spec.volumes[2].emptyDir: Forbidden")
+	assert.Assert(t, !strings.Contains(err.Error(), "spec.volumes[0]"))
+
+	size := resource.MustParse("0")
+	app = newValidApplication()
+	app.Spec.Volumes = []*VolumeSpec{
+		{MountPath: "/data", PersistentVolumeClaim: true, Size: &size},
+		{MountPath: "/tmp", HostPath: "/tmp", AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce}},
+	}
+	err = app.ValidateCreate()
+	assert.ErrorContains(t, err, "spec.volumes[0].size: Invalid value")
+	assert.ErrorContains(t, err, "spec.volumes[1].persistentVolumeClaim: Forbidden")
+}
+
+func TestValidateService(t *testing.T) {
+	app := newValidApplication()
+	app.Spec.Service = &ServiceConfig{
+		Type:                          ServiceTypeHeadless,
+		SessionAffinityTimeoutSeconds: proto.Int32(60),
+		ExternalTrafficPolicy:         v1.ServiceExternalTrafficPolicyTypeLocal,
+		Labels:                        map[string]string{AppLabelKey: "other"},
+	}
+	err := app.ValidateCreate()
+	assert.ErrorContains(t, err, "spec.service.sessionAffinityTimeoutSeconds: Forbidden")
+	assert.ErrorContains(t, err, "spec.service.externalTrafficPolicy: Forbidden")
+	assert.ErrorContains(t, err, "spec.service.labels[app]: Forbidden")
+
+	app.Spec.Service = &ServiceConfig{
+		Type:                          "LoadBalancer",
+		SessionAffinity:               v1.ServiceAffinityClientIP,
+		SessionAffinityTimeoutSeconds: proto.Int32(60),
+		ExternalTrafficPolicy:         v1.ServiceExternalTrafficPolicyTypeLocal,
+	}
+	assert.NilError(t, app.ValidateCreate())
+}
+
+func TestValidateDomainTLS(t *testing.T) {
+	app := newValidApplication()
+	app.Spec.Domains[0].TLS = &DomainTLS{SecretName: "wildcard", CertManager: &CertManagerTLS{}}
+	assert.ErrorContains(t, app.ValidateCreate(), "spec.domains[0].tls: Invalid value")
+
+	app.Spec.Domains[0].TLS = &DomainTLS{CertManager: &CertManagerTLS{Issuer: "letsencrypt"}}
+	assert.NilError(t, app.ValidateCreate())
+
+	// gateway模式下证书配置在Gateway上
+	GatewayMode = func() bool { return true }
+	defer func() { GatewayMode = func() bool { return false } }()
+	assert.ErrorContains(t, app.ValidateCreate(), "spec.domains[0].tls: Forbidden")
+}
@@ -17,6 +17,10 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -29,6 +33,69 @@ type SQBDeploymentSpec struct {
 	// Important: Run "make" to regenerate code after modifying this file
 	Selector   Selector `json:"selector"`
 	DeploySpec `json:",inline"`
+	// TrafficPolicy 覆盖SQBApplication中该环境的流量策略
+	TrafficPolicy *TrafficPolicy `json:"trafficPolicy,omitempty"`
+	// Mirror 把来源环境的请求复制一份到该环境，响应被丢弃，只对开启istio注入的应用生效
+	Mirror *MirrorSpec `json:"mirror,omitempty"`
+	// Canary 灰度发布配置，只对gray环境的sqbdeployment生效，镜像变化时按步骤把基础环境的流量切到gray环境
+	Canary *CanarySpec `json:"canary,omitempty"`
+	// Strategy 发布策略，rollingUpdate(默认)为deployment的滚动更新，blueGreen为新版本全部可用后一次性切换流量
+	// +kubebuilder:validation:Enum=rollingUpdate;blueGreen
+	Strategy  string         `json:"strategy,omitempty"`
+	BlueGreen *BlueGreenSpec `json:"blueGreen,omitempty"`
+}
+
+// 发布策略
+const (
+	StrategyRollingUpdate = "rollingUpdate"
+	StrategyBlueGreen     = "blueGreen"
+)
+
+// BlueGreenSpec 蓝绿发布的配置，只在strategy为blueGreen时生效
+type BlueGreenSpec struct {
+	// AutoPromote 新版本可用后是否自动切换流量，默认为true，false时需要设置qa.shouqianba.com/blue-green-promote注解
+	AutoPromote *bool `json:"autoPromote,omitempty"`
+	// ScaleDownDelay 切换流量后旧版本缩容到0之前等待的时间，默认30s
+	ScaleDownDelay *metav1.Duration `json:"scaleDownDelay,omitempty"`
+}
+
+// 蓝绿发布中两个deployment的颜色，blue与sqbdeployment同名，green为{sqbdeployment名}-green
+const (
+	ColorBlue  = "blue"
+	ColorGreen = "green"
+)
+
+// BlueGreenPhase 蓝绿发布的阶段
+type BlueGreenPhase string
+
+const (
+	// BlueGreenPhaseActive 没有新版本，流量在active的deployment
+	BlueGreenPhaseActive BlueGreenPhase = "Active"
+	// BlueGreenPhasePreviewing 新版本部署在另一个颜色的deployment，等待可用或手动切换
+	BlueGreenPhasePreviewing BlueGreenPhase = "Previewing"
+	// BlueGreenPhaseAborted 新版本被放弃，spec再次变化后重新发布
+	BlueGreenPhaseAborted BlueGreenPhase = "Aborted"
+)
+
+// BlueGreenStatus 蓝绿发布的进度
+type BlueGreenStatus struct {
+	Phase BlueGreenPhase `json:"phase"`
+	// ActiveColor 接收流量的deployment的颜色
+	ActiveColor string `json:"activeColor"`
+	// SwitchTime 最近一次切换流量的时间，旧版本在scaleDownDelay之后缩容
+	SwitchTime *metav1.Time `json:"switchTime,omitempty"`
+	// AbortedHash 被放弃的pod模板的hash，spec没有变化时不再发布
+	AbortedHash string `json:"abortedHash,omitempty"`
+}
+
+// MirrorSpec 流量镜像的来源环境和比例
+type MirrorSpec struct {
+	// From 被复制请求的环境，webhook默认设置为operator配置中的baseFlag
+	From string `json:"from,omitempty"`
+	// Percent 复制请求的百分比，默认100
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	Percent *int32 `json:"percent,omitempty"`
 }
 
 type Selector struct {
@@ -36,15 +103,129 @@ type Selector struct {
 	Plane string `json:"plane"`
 }
 
+// CanaryPlane 灰度发布的环境
+const CanaryPlane = "gray"
+
+// CanarySpec 灰度发布的步骤和每一步结束时的指标分析，全部步骤通过后把镜像发布到基础环境，分析不通过时回滚
+type CanarySpec struct {
+	// +kubebuilder:validation:MinItems=1
+	Steps    []CanaryStep    `json:"steps"`
+	Analysis *CanaryAnalysis `json:"analysis,omitempty"`
+}
+
+type CanaryStep struct {
+	// Weight gray环境的流量百分比
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	Weight int32 `json:"weight"`
+	// Pause 保持该权重的时间，到期后分析指标并进入下一步
+	Pause *metav1.Duration `json:"pause,omitempty"`
+}
+
+// CanaryAnalysis 查询prometheus或victoria metrics的指标，query中的{{.Namespace}}、{{.Name}}、{{.App}}会被替换为gray环境的值
+type CanaryAnalysis struct {
+	// Address 查询指标的地址，默认使用operator配置中的metricsAddress
+	Address string `json:"address,omitempty"`
+	// MaxErrorRate 5xx请求占比的上限，如"0.01"
+	// +kubebuilder:validation:Pattern=`^(0(\.[0-9]+)?|1(\.0+)?)$`
+	MaxErrorRate string `json:"maxErrorRate,omitempty"`
+	// ErrorRateQuery 默认按istio_requests_total计算最近1分钟的5xx请求占比
+	ErrorRateQuery string `json:"errorRateQuery,omitempty"`
+	// MaxLatency P99延迟的上限
+	MaxLatency *metav1.Duration `json:"maxLatency,omitempty"`
+	// LatencyQuery 查询结果的单位为毫秒，默认按istio_request_duration_milliseconds_bucket计算最近1分钟的P99
+	LatencyQuery string `json:"latencyQuery,omitempty"`
+	// MaxInconclusive 查询没有数据时无法判断，按步骤的pause(没有配置时为1分钟)重新分析，连续超过该次数后回滚，默认3
+	// +kubebuilder:validation:Minimum=0
+	MaxInconclusive *int32 `json:"maxInconclusive,omitempty"`
+}
+
+// CanaryPhase 灰度发布的阶段
+type CanaryPhase string
+
+const (
+	// CanaryPhasePending 镜像变化后等待gray环境的deployment就绪
+	CanaryPhasePending CanaryPhase = "Pending"
+	// CanaryPhaseProgressing 按步骤切换流量
+	CanaryPhaseProgressing CanaryPhase = "Progressing"
+	// CanaryPhasePaused 全部步骤通过，基础环境继承SQBApplication的镜像，保持最后一步的权重等待在SQBApplication上发布镜像
+	CanaryPhasePaused CanaryPhase = "Paused"
+	// CanaryPhasePromoted 全部步骤通过，镜像已经发布到基础环境
+	CanaryPhasePromoted CanaryPhase = "Promoted"
+	// CanaryPhaseRolledBack 指标分析不通过，流量已经全部切回基础环境
+	CanaryPhaseRolledBack CanaryPhase = "RolledBack"
+)
+
+// CanaryStatus 灰度发布的进度
+type CanaryStatus struct {
+	Phase CanaryPhase `json:"phase"`
+	// Image 灰度发布的镜像，变化时重新开始
+	Image string `json:"image"`
+	// Step 当前步骤的序号，从0开始
+	Step int32 `json:"step"`
+	// Weight 当前gray环境的流量百分比
+	Weight int32 `json:"weight"`
+	// StepStartTime 当前步骤开始的时间
+	StepStartTime *metav1.Time `json:"stepStartTime,omitempty"`
+	// Message 最近一次指标分析的结果
+	Message string `json:"message,omitempty"`
+	// Inconclusive 当前步骤连续无法判断的次数
+	Inconclusive int32 `json:"inconclusive,omitempty"`
+}
+
+// EffectiveDeployStatus 生效的deploy配置的摘要，列表字段只记录key
+type EffectiveDeployStatus struct {
+	// Hash 生效的deploy配置的hash，配置变化时改变
+	Hash     string `json:"hash"`
+	Image    string `json:"image,omitempty"`
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Env env的name
+	Env []string `json:"env,omitempty"`
+	// EnvFrom envFrom引用的configMap或secret的名字
+	EnvFrom []string `json:"envFrom,omitempty"`
+	// HostAliases hostAliases的ip
+	HostAliases []string `json:"hostAliases,omitempty"`
+	// Volumes volumes的mountPath
+	Volumes []string `json:"volumes,omitempty"`
+	// Sidecars sidecars的name
+	Sidecars []string `json:"sidecars,omitempty"`
+	// InitContainers initContainers的name
+	InitContainers []string `json:"initContainers,omitempty"`
+}
+
 // SQBDeploymentStatus defines the observed state of SQBDeployment
 type SQBDeploymentStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
-	ErrorInfo string `json:"errorInfo,omitempty"`
+	// ObservedGeneration 最近一次调和的generation
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// ApplicationGeneration 计算生效的deploy配置时SQBApplication的generation
+	ApplicationGeneration int64 `json:"applicationGeneration,omitempty"`
+	// Effective 继承SQBApplication之后生效的deploy配置
+	Effective *EffectiveDeployStatus `json:"effective,omitempty"`
+	// Canary 灰度发布的进度
+	Canary *CanaryStatus `json:"canary,omitempty"`
+	// BlueGreen 蓝绿发布的进度
+	BlueGreen *BlueGreenStatus `json:"blueGreen,omitempty"`
+	// +listType=map
+	// +listMapKey=type
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+// +kubebuilder:printcolumn:name="App",type="string",JSONPath=".spec.selector.app"
+// +kubebuilder:printcolumn:name="Plane",type="string",JSONPath=".spec.selector.plane"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].reason"
+// +kubebuilder:printcolumn:name="Image",type="string",JSONPath=".status.effective.image",priority=1
+// +kubebuilder:printcolumn:name="Canary",type="string",JSONPath=".status.canary.phase",priority=1
+// +kubebuilder:printcolumn:name="Weight",type="integer",JSONPath=".status.canary.weight",priority=1
+// +kubebuilder:printcolumn:name="Active",type="string",JSONPath=".status.blueGreen.activeColor",priority=1
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // SQBDeployment is the Schema for the sqbdeployments API
 type SQBDeployment struct {
@@ -70,6 +251,48 @@ func (old *SQBDeployment) Merge(new *SQBDeployment) {
 	old.Spec.DeploySpec.merge(&new.Spec.DeploySpec)
 }
 
+// EffectiveDeploySpec 生效的deploy配置：继承SQBApplication的deploy配置，SQBDeployment中声明的字段覆盖继承的配置
+func (r *SQBDeployment) EffectiveDeploySpec(sqbapplication *SQBApplication) DeploySpec {
+	spec := sqbapplication.Spec.DeploySpec.DeepCopy()
+	spec.merge(r.Spec.DeploySpec.DeepCopy())
+	return *spec
+}
+
+// NewEffectiveDeployStatus 记录生效的deploy配置的hash和主要字段
+func NewEffectiveDeployStatus(spec DeploySpec) *EffectiveDeployStatus {
+	data, _ := json.Marshal(spec)
+	status := &EffectiveDeployStatus{
+		Hash:  fmt.Sprintf("%x", md5.Sum(data)),
+		Image: spec.Image,
+	}
+	if spec.Replicas != nil {
+		replicas := *spec.Replicas
+		status.Replicas = &replicas
+	}
+	for _, env := range spec.Env {
+		status.Env = append(status.Env, env.Name)
+	}
+	for _, envFrom := range spec.EnvFrom {
+		status.EnvFrom = append(status.EnvFrom, envFromName(envFrom))
+	}
+	for _, hostAlias := range spec.HostAlias {
+		status.HostAliases = append(status.HostAliases, hostAlias.IP)
+	}
+	for _, volume := range spec.Volumes {
+		if volume == nil {
+			continue
+		}
+		status.Volumes = append(status.Volumes, volume.MountPath)
+	}
+	for _, sidecar := range spec.Sidecars {
+		status.Sidecars = append(status.Sidecars, sidecar.Name)
+	}
+	for _, initContainer := range spec.InitContainers {
+		status.InitContainers = append(status.InitContainers, initContainer.Name)
+	}
+	return status
+}
+
 func init() {
 	SchemeBuilder.Register(&SQBDeployment{}, &SQBDeploymentList{})
 }
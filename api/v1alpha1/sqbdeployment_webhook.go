@@ -17,8 +17,15 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"context"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 )
@@ -26,36 +33,147 @@ import (
 // log is for logging in this package.
 var sqbdeploymentlog = logf.Log.WithName("sqbdeployment-resource")
 
+// webhookClient 用于校验sqbdeployment引用的sqbapplication和sqbplane是否存在，直接读apiserver避免缓存延迟
+var webhookClient client.Reader
+
 func (r *SQBDeployment) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	webhookClient = mgr.GetAPIReader()
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(r).
 		Complete()
 }
 
-// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
+// DefaultPlane 未指定plane时使用的默认值，即operator配置中的baseFlag，由main函数设置
+var DefaultPlane = func() string {
+	return "base"
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/mutate-qa-shouqianba-com-v1alpha1-sqbdeployment,mutating=true,failurePolicy=fail,groups=qa.shouqianba.com,resources=sqbdeployments,versions=v1alpha1,name=msqbdeployment.kb.io
+
+var _ webhook.Defaulter = &SQBDeployment{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *SQBDeployment) Default() {
+	sqbdeploymentlog.Info("default", "name", r.Name)
+	if r.Spec.Selector.Plane == "" {
+		r.Spec.Selector.Plane = DefaultPlane()
+	}
+	if r.Spec.Mirror != nil && r.Spec.Mirror.From == "" {
+		r.Spec.Mirror.From = DefaultPlane()
+	}
+	if r.Labels == nil {
+		r.Labels = make(map[string]string)
+	}
+	if _, ok := r.Labels[AppLabelKey]; !ok && r.Spec.Selector.App != "" {
+		r.Labels[AppLabelKey] = r.Spec.Selector.App
+	}
+	if _, ok := r.Labels[PlaneLabelKey]; !ok {
+		r.Labels[PlaneLabelKey] = r.Spec.Selector.Plane
+	}
+}
 
 // TODO(user): change verbs to "verbs=create;update;delete" if you want to enable deletion validation.
-// +kubebuilder:webhook:verbs=update,path=/validate-qa-shouqianba-com-v1alpha1-sqbdeployment,mutating=false,failurePolicy=fail,groups=qa.shouqianba.com,resources=sqbdeployments,versions=v1alpha1,name=vsqbdeployment.kb.io
+// +kubebuilder:webhook:verbs=create;update,path=/validate-qa-shouqianba-com-v1alpha1-sqbdeployment,mutating=false,failurePolicy=fail,groups=qa.shouqianba.com,resources=sqbdeployments,versions=v1alpha1,name=vsqbdeployment.kb.io
 
 var _ webhook.Validator = &SQBDeployment{}
 
 // ValidateCreate implements webhook.Validator so a webhook will be registered for the type
 func (r *SQBDeployment) ValidateCreate() error {
-	return nil
+	sqbdeploymentlog.Info("validate create", "name", r.Name)
+	allErrs := r.validateReferences()
+	allErrs = append(allErrs, r.validateAnnotations()...)
+	allErrs = append(allErrs, r.validateSpec()...)
+	return r.toError(allErrs)
 }
 
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
 func (r *SQBDeployment) ValidateUpdate(old runtime.Object) error {
 	sqbdeploymentlog.Info("validate update", "name", r.Name)
+	// 删除中的对象只会去掉finalizer，不校验
+	if r.DeletionTimestamp != nil {
+		return nil
+	}
 	oldcr := old.(*SQBDeployment)
-	if r.Spec.Selector.App != oldcr.Spec.Selector.App ||
-		r.Spec.Selector.Plane != oldcr.Spec.Selector.Plane {
-		sqbdeploymentlog.Info("sqbdeployment selector updated", "name", r.Name)
+	// selector创建之后就不可修改，如果要修改则删除sqbdeployment重新创建
+	selectorPath := field.NewPath("spec", "selector")
+	allErrs := apivalidation.ValidateImmutableField(r.Spec.Selector.App, oldcr.Spec.Selector.App, selectorPath.Child("app"))
+	// 存量对象没有plane，更新时会被设置为默认值
+	oldPlane := oldcr.Spec.Selector.Plane
+	if oldPlane == "" {
+		oldPlane = DefaultPlane()
 	}
-	return nil
+	allErrs = append(allErrs, apivalidation.ValidateImmutableField(r.Spec.Selector.Plane, oldPlane,
+		selectorPath.Child("plane"))...)
+	// 存量对象可能不符合新增的校验规则，只拒绝这次更新引入的错误，保证operator可以继续更新
+	allErrs = append(allErrs, newErrors(append(r.validateAnnotations(), r.validateSpec()...),
+		append(oldcr.validateAnnotations(), oldcr.validateSpec()...))...)
+	return r.toError(allErrs)
 }
 
 // ValidateDelete implements webhook.Validator so a webhook will be registered for the type
 func (r *SQBDeployment) ValidateDelete() error {
 	return nil
 }
+
+func (r *SQBDeployment) validateAnnotations() field.ErrorList {
+	return validatePassthroughAnnotations(r.Annotations, DeploymentAnnotationKey, PodAnnotationKey)
+}
+
+func (r *SQBDeployment) validateSpec() field.ErrorList {
+	// 业务容器以sqbdeployment命名
+	allErrs := validateSidecars(r.Spec.Sidecars, field.NewPath("spec", "sidecars"), r.Name)
+	allErrs = append(allErrs, validateInitContainers(r.Spec.InitContainers, field.NewPath("spec", "initContainers"))...)
+	allErrs = append(allErrs, validateVolumes(r.Spec.Volumes, field.NewPath("spec", "volumes"))...)
+	// 不能镜像自己所在环境的请求
+	if r.Spec.Mirror != nil && r.Spec.Mirror.From == r.Spec.Selector.Plane {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "mirror", "from"), r.Spec.Mirror.From,
+			"must be different from spec.selector.plane"))
+	}
+	if r.Spec.Canary != nil && r.Spec.Selector.Plane != CanaryPlane {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "canary"),
+			"canary is only supported in plane "+CanaryPlane))
+	}
+	// 灰度发布按sqbdeployment同名的deployment分析指标，不能同时使用蓝绿发布
+	if r.Spec.Canary != nil && r.Spec.Strategy == StrategyBlueGreen {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "strategy"),
+			"blueGreen strategy can not be used with canary"))
+	}
+	return allErrs
+}
+
+// validateReferences 校验selector引用的sqbapplication和sqbplane在同一个namespace下存在
+func (r *SQBDeployment) validateReferences() field.ErrorList {
+	var allErrs field.ErrorList
+	selectorPath := field.NewPath("spec", "selector")
+	if r.Spec.Selector.App == "" {
+		return append(allErrs, field.Required(selectorPath.Child("app"), "sqbapplication name must not be empty"))
+	}
+	if webhookClient == nil {
+		return allErrs
+	}
+	ctx := context.Background()
+	err := webhookClient.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: r.Spec.Selector.App}, &SQBApplication{})
+	if apierrors.IsNotFound(err) {
+		allErrs = append(allErrs, field.NotFound(selectorPath.Child("app"), r.Spec.Selector.App))
+	} else if err != nil {
+		allErrs = append(allErrs, field.InternalError(selectorPath.Child("app"), err))
+	}
+	// 默认plane不要求存在对应的sqbplane
+	if r.Spec.Selector.Plane == "" || r.Spec.Selector.Plane == DefaultPlane() {
+		return allErrs
+	}
+	err = webhookClient.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: r.Spec.Selector.Plane}, &SQBPlane{})
+	if apierrors.IsNotFound(err) {
+		allErrs = append(allErrs, field.NotFound(selectorPath.Child("plane"), r.Spec.Selector.Plane))
+	} else if err != nil {
+		allErrs = append(allErrs, field.InternalError(selectorPath.Child("plane"), err))
+	}
+	return allErrs
+}
+
+func (r *SQBDeployment) toError(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "SQBDeployment"}, r.Name, allErrs)
+}
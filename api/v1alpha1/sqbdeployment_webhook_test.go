@@ -0,0 +1,122 @@
+package v1alpha1
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newSQBDeployment(app, plane string) *SQBDeployment {
+	return &SQBDeployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: app + "-" + plane},
+		Spec: SQBDeploymentSpec{
+			Selector: Selector{App: app, Plane: plane},
+		},
+	}
+}
+
+func TestValidateSelectorImmutable(t *testing.T) {
+	old := newSQBDeployment("demo", "base")
+	assert.NilError(t, newSQBDeployment("demo", "base").ValidateUpdate(old))
+
+	err := newSQBDeployment("demo", "test").ValidateUpdate(old)
+	assert.ErrorContains(t, err, "spec.selector.plane")
+	assert.ErrorContains(t, err, "field is immutable")
+
+	err = newSQBDeployment("demo2", "base").ValidateUpdate(old)
+	assert.ErrorContains(t, err, "spec.selector.app")
+}
+
+func TestValidateUpdateLegacyDeployment(t *testing.T) {
+	old := newSQBDeployment("demo", "base")
+	old.Annotations = map[string]string{PodAnnotationKey: "not json"}
+	old.Spec.Sidecars = []SidecarSpec{{Name: "demo-base"}}
+
+	// 存量的错误不影响operator更新，如添加finalizer、灰度发布修改基础环境的镜像
+	deployment := old.DeepCopy()
+	deployment.Finalizers = []string{"qa.shouqianba.com/finalizer"}
+	deployment.Spec.Image = "demo:v2"
+	assert.NilError(t, deployment.ValidateUpdate(old))
+
+	// 新引入的错误仍然拒绝
+	deployment.Annotations[DeploymentAnnotationKey] = "not json"
+	err := deployment.ValidateUpdate(old)
+	assert.ErrorContains(t, err, DeploymentAnnotationKey)
+	assert.Assert(t, !strings.Contains(err.Error(), PodAnnotationKey))
+
+	// 删除中的对象只去掉finalizer，不校验
+	now := metav1.Now()
+	deployment.DeletionTimestamp = &now
+	deployment.Finalizers = nil
+	assert.NilError(t, deployment.ValidateUpdate(old))
+}
+
+func TestValidateReferences(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NilError(t, AddToScheme(scheme))
+	webhookClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&SQBApplication{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo"}},
+		&SQBPlane{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "base"}},
+	).Build()
+	defer func() { webhookClient = nil }()
+
+	assert.NilError(t, newSQBDeployment("demo", "base").ValidateCreate())
+	assert.ErrorContains(t, newSQBDeployment("demo", "test").ValidateCreate(), "spec.selector.plane: Not found")
+	assert.ErrorContains(t, newSQBDeployment("demo2", "base").ValidateCreate(), "spec.selector.app: Not found")
+	assert.ErrorContains(t, newSQBDeployment("", "base").ValidateCreate(), "spec.selector.app: Required value")
+}
+
+func TestDefault(t *testing.T) {
+	deployment := newSQBDeployment("demo", "")
+	deployment.Default()
+	assert.Equal(t, "base", deployment.Spec.Selector.Plane)
+	assert.Equal(t, "demo", deployment.Labels[AppLabelKey])
+	assert.Equal(t, "base", deployment.Labels[PlaneLabelKey])
+
+	// 存量对象补充plane后可以更新
+	assert.NilError(t, deployment.ValidateUpdate(newSQBDeployment("demo", "")))
+
+	deployment = newSQBDeployment("demo", "test")
+	deployment.Labels = map[string]string{PlaneLabelKey: "custom"}
+	deployment.Default()
+	assert.Equal(t, "test", deployment.Spec.Selector.Plane)
+	assert.Equal(t, "custom", deployment.Labels[PlaneLabelKey])
+}
+
+func TestMirror(t *testing.T) {
+	deployment := newSQBDeployment("demo", "feature")
+	deployment.Spec.Mirror = &MirrorSpec{}
+	deployment.Default()
+	assert.Equal(t, "base", deployment.Spec.Mirror.From)
+	assert.NilError(t, deployment.ValidateUpdate(newSQBDeployment("demo", "feature")))
+
+	deployment = newSQBDeployment("demo", "base")
+	deployment.Spec.Mirror = &MirrorSpec{}
+	deployment.Default()
+	assert.ErrorContains(t, deployment.ValidateUpdate(newSQBDeployment("demo", "base")), "spec.mirror.from")
+}
+
+func TestValidateCanary(t *testing.T) {
+	deployment := newSQBDeployment("demo", "gray")
+	deployment.Spec.Canary = &CanarySpec{Steps: []CanaryStep{{Weight: 10}}}
+	assert.NilError(t, deployment.ValidateUpdate(newSQBDeployment("demo", "gray")))
+
+	deployment = newSQBDeployment("demo", "feature")
+	deployment.Spec.Canary = &CanarySpec{Steps: []CanaryStep{{Weight: 10}}}
+	assert.ErrorContains(t, deployment.ValidateUpdate(newSQBDeployment("demo", "feature")), "spec.canary")
+}
+
+func TestValidateBlueGreen(t *testing.T) {
+	deployment := newSQBDeployment("demo", "base")
+	deployment.Spec.Strategy = StrategyBlueGreen
+	assert.NilError(t, deployment.ValidateUpdate(newSQBDeployment("demo", "base")))
+
+	deployment = newSQBDeployment("demo", "gray")
+	deployment.Spec.Strategy = StrategyBlueGreen
+	deployment.Spec.Canary = &CanarySpec{Steps: []CanaryStep{{Weight: 10}}}
+	assert.ErrorContains(t, deployment.ValidateUpdate(newSQBDeployment("demo", "gray")), "spec.strategy")
+}
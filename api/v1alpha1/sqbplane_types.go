@@ -34,12 +34,20 @@ type SQBPlaneSpec struct {
 type SQBPlaneStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
-	Mirrors   map[string]int `json:"mirrors,omitempty"`
-	ErrorInfo string         `json:"errorInfo,omitempty"`
+	Mirrors map[string]int `json:"mirrors,omitempty"`
+	// ObservedGeneration 最近一次调和的generation
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// +listType=map
+	// +listMapKey=type
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // SQBPlane is the Schema for the sqbplanes API
 type SQBPlane struct {
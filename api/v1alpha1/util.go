@@ -10,3 +10,37 @@ func MergeStringMap(base, toMerge map[string]string) map[string]string {
 	}
 	return result
 }
+
+// mergeByKey 按key合并列表：先删除unset中的key，key相同的元素原位替换，新的key追加到末尾
+func mergeByKey[T any](base, toMerge []T, key func(T) string, unset []string) []T {
+	if len(toMerge) == 0 && len(unset) == 0 {
+		return base
+	}
+	removed := make(map[string]struct{}, len(unset))
+	for _, k := range unset {
+		removed[k] = struct{}{}
+	}
+	index := make(map[string]int)
+	result := make([]T, 0, len(base)+len(toMerge))
+	for _, item := range base {
+		if _, ok := removed[key(item)]; ok {
+			continue
+		}
+		index[key(item)] = len(result)
+		result = append(result, item)
+	}
+	for _, item := range toMerge {
+		if i, ok := index[key(item)]; ok {
+			result[i] = item
+			continue
+		}
+		index[key(item)] = len(result)
+		result = append(result, item)
+	}
+	return result
+}
+
+// mergeStrings 合并两个列表并去重
+func mergeStrings(base, toMerge []string) []string {
+	return mergeByKey(base, toMerge, func(s string) string { return s }, nil)
+}
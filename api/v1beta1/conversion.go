@@ -0,0 +1,234 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/wosai/elastic-env-operator/api/v1alpha1"
+)
+
+// v1beta1的spec字段与v1alpha1的annotation一一对应，转换时互相映射。
+// v1alpha1中无法解析的annotation原样保留，保证转换不丢失信息。
+
+var _ conversion.Convertible = &SQBApplication{}
+
+// ConvertTo converts this SQBApplication to the Hub version (v1alpha1).
+func (src *SQBApplication) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1alpha1.SQBApplication)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	spec := src.Spec.DeepCopy()
+	dst.Spec.IngressSpec = spec.IngressSpec
+	dst.Spec.ServiceSpec = spec.ServiceSpec
+	dst.Spec.DeploySpec = spec.DeploySpec
+	src.Status.DeepCopyInto(&dst.Status)
+
+	annotations := dst.Annotations
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	setBoolAnnotation(annotations, v1alpha1.IngressOpenAnnotationKey, spec.IngressOpen)
+	if spec.Mesh != nil {
+		setBoolAnnotation(annotations, v1alpha1.IstioInjectAnnotationKey, spec.Mesh.Inject)
+		dst.Spec.TrafficPolicy = spec.Mesh.TrafficPolicy
+	}
+	if spec.Monitoring != nil {
+		value, err := json.Marshal(spec.Monitoring.Endpoints)
+		if err != nil {
+			return err
+		}
+		annotations[v1alpha1.ServiceMonitorAnnotationKey] = string(value)
+	}
+	if err := setMapAnnotation(annotations, v1alpha1.ServiceAnnotationKey, spec.ServiceAnnotations); err != nil {
+		return err
+	}
+	if err := setMapAnnotation(annotations, v1alpha1.DestinationRuleAnnotationKey, spec.DestinationRuleAnnotations); err != nil {
+		return err
+	}
+	if err := setMapAnnotation(annotations, v1alpha1.VirtualServiceAnnotationKey, spec.VirtualServiceAnnotations); err != nil {
+		return err
+	}
+	dst.Annotations = annotationsOrNil(annotations)
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1alpha1) to this version.
+func (dst *SQBApplication) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1alpha1.SQBApplication)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	spec := src.Spec.DeepCopy()
+	dst.Spec = SQBApplicationSpec{
+		IngressSpec: spec.IngressSpec,
+		ServiceSpec: spec.ServiceSpec,
+		DeploySpec:  spec.DeploySpec,
+	}
+	src.Status.DeepCopyInto(&dst.Status)
+
+	annotations := dst.Annotations
+	dst.Spec.IngressOpen = popBoolAnnotation(annotations, v1alpha1.IngressOpenAnnotationKey)
+	dst.Spec.Mesh = meshSpec(popBoolAnnotation(annotations, v1alpha1.IstioInjectAnnotationKey), spec.TrafficPolicy)
+	if endpoints, ok := popEndpointsAnnotation(annotations, v1alpha1.ServiceMonitorAnnotationKey); ok {
+		dst.Spec.Monitoring = &MonitoringSpec{Endpoints: endpoints}
+	}
+	dst.Spec.ServiceAnnotations = popMapAnnotation(annotations, v1alpha1.ServiceAnnotationKey)
+	dst.Spec.DestinationRuleAnnotations = popMapAnnotation(annotations, v1alpha1.DestinationRuleAnnotationKey)
+	dst.Spec.VirtualServiceAnnotations = popMapAnnotation(annotations, v1alpha1.VirtualServiceAnnotationKey)
+	dst.Annotations = annotationsOrNil(annotations)
+	return nil
+}
+
+var _ conversion.Convertible = &SQBDeployment{}
+
+// ConvertTo converts this SQBDeployment to the Hub version (v1alpha1).
+func (src *SQBDeployment) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1alpha1.SQBDeployment)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	spec := src.Spec.DeepCopy()
+	dst.Spec.Selector = spec.Selector
+	dst.Spec.DeploySpec = spec.DeploySpec
+	dst.Spec.Mirror = spec.Mirror
+	dst.Spec.Canary = spec.Canary
+	dst.Spec.Strategy = spec.Strategy
+	dst.Spec.BlueGreen = spec.BlueGreen
+	src.Status.DeepCopyInto(&dst.Status)
+
+	annotations := dst.Annotations
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	setBoolAnnotation(annotations, v1alpha1.PublicEntryAnnotationKey, spec.PublicEntry)
+	if spec.Mesh != nil {
+		setBoolAnnotation(annotations, v1alpha1.IstioInjectAnnotationKey, spec.Mesh.Inject)
+		dst.Spec.TrafficPolicy = spec.Mesh.TrafficPolicy
+	}
+	if err := setMapAnnotation(annotations, v1alpha1.DeploymentAnnotationKey, spec.DeploymentAnnotations); err != nil {
+		return err
+	}
+	if err := setMapAnnotation(annotations, v1alpha1.PodAnnotationKey, spec.PodAnnotations); err != nil {
+		return err
+	}
+	dst.Annotations = annotationsOrNil(annotations)
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1alpha1) to this version.
+func (dst *SQBDeployment) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1alpha1.SQBDeployment)
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	spec := src.Spec.DeepCopy()
+	dst.Spec = SQBDeploymentSpec{
+		Selector:   spec.Selector,
+		DeploySpec: spec.DeploySpec,
+		Mirror:     spec.Mirror,
+		Canary:     spec.Canary,
+		Strategy:   spec.Strategy,
+		BlueGreen:  spec.BlueGreen,
+	}
+	src.Status.DeepCopyInto(&dst.Status)
+
+	annotations := dst.Annotations
+	dst.Spec.PublicEntry = popBoolAnnotation(annotations, v1alpha1.PublicEntryAnnotationKey)
+	dst.Spec.Mesh = meshSpec(popBoolAnnotation(annotations, v1alpha1.IstioInjectAnnotationKey), spec.TrafficPolicy)
+	dst.Spec.DeploymentAnnotations = popMapAnnotation(annotations, v1alpha1.DeploymentAnnotationKey)
+	dst.Spec.PodAnnotations = popMapAnnotation(annotations, v1alpha1.PodAnnotationKey)
+	dst.Annotations = annotationsOrNil(annotations)
+	return nil
+}
+
+// meshSpec sidecar注入和流量策略都没有设置时为nil
+func meshSpec(inject *bool, trafficPolicy *v1alpha1.TrafficPolicy) *MeshSpec {
+	if inject == nil && trafficPolicy == nil {
+		return nil
+	}
+	return &MeshSpec{Inject: inject, TrafficPolicy: trafficPolicy}
+}
+
+func setBoolAnnotation(annotations map[string]string, key string, value *bool) {
+	if value != nil {
+		annotations[key] = strconv.FormatBool(*value)
+	}
+}
+
+func setMapAnnotation(annotations map[string]string, key string, value map[string]string) error {
+	if value == nil {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	annotations[key] = string(data)
+	return nil
+}
+
+// popBoolAnnotation 只有"true"和"false"转换为字段，其他值保留在annotation中
+func popBoolAnnotation(annotations map[string]string, key string) *bool {
+	value, ok := annotations[key]
+	if !ok || (value != "true" && value != "false") {
+		return nil
+	}
+	delete(annotations, key)
+	result := value == "true"
+	return &result
+}
+
+func popMapAnnotation(annotations map[string]string, key string) map[string]string {
+	value, ok := annotations[key]
+	if !ok {
+		return nil
+	}
+	result := make(map[string]string)
+	if err := json.Unmarshal([]byte(value), &result); err != nil {
+		return nil
+	}
+	delete(annotations, key)
+	return result
+}
+
+// popEndpointsAnnotation MonitoringEndpoint只包含常用字段，annotation中有其他字段时保留annotation
+func popEndpointsAnnotation(annotations map[string]string, key string) ([]MonitoringEndpoint, bool) {
+	value, ok := annotations[key]
+	if !ok || value == "" {
+		return nil, false
+	}
+	endpoints := make([]MonitoringEndpoint, 0)
+	if err := json.Unmarshal([]byte(value), &endpoints); err != nil {
+		return nil, false
+	}
+	data, err := json.Marshal(endpoints)
+	if err != nil {
+		return nil, false
+	}
+	var origin, typed interface{}
+	if json.Unmarshal([]byte(value), &origin) != nil || json.Unmarshal(data, &typed) != nil ||
+		!reflect.DeepEqual(origin, typed) {
+		return nil, false
+	}
+	delete(annotations, key)
+	return endpoints, true
+}
+
+func annotationsOrNil(annotations map[string]string) map[string]string {
+	if len(annotations) == 0 {
+		return nil
+	}
+	return annotations
+}
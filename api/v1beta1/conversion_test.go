@@ -0,0 +1,107 @@
+package v1beta1
+
+import (
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"gotest.tools/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/wosai/elastic-env-operator/api/v1alpha1"
+)
+
+func TestSQBApplicationConversion(t *testing.T) {
+	hub := &v1alpha1.SQBApplication{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "demo",
+			Annotations: map[string]string{
+				v1alpha1.IstioInjectAnnotationKey:    "true",
+				v1alpha1.IngressOpenAnnotationKey:    "false",
+				v1alpha1.ServiceMonitorAnnotationKey: `[{"port":"http-8080","path":"/metrics","interval":"15s"}]`,
+				v1alpha1.ServiceAnnotationKey:        `{"a":"b"}`,
+				"other":                              "value",
+			},
+		},
+		Spec: v1alpha1.SQBApplicationSpec{
+			DeploySpec: v1alpha1.DeploySpec{Image: "demo:1", Replicas: proto.Int32(2)},
+		},
+	}
+	app := &SQBApplication{}
+	assert.NilError(t, app.ConvertFrom(hub))
+	assert.Equal(t, *app.Spec.Mesh.Inject, true)
+	assert.Equal(t, *app.Spec.IngressOpen, false)
+	assert.Equal(t, app.Spec.Monitoring.Endpoints[0].Port, "http-8080")
+	assert.Equal(t, app.Spec.ServiceAnnotations["a"], "b")
+	assert.Equal(t, app.Spec.Image, "demo:1")
+	assert.DeepEqual(t, app.Annotations, map[string]string{"other": "value"})
+	// 不修改原对象
+	assert.Equal(t, len(hub.Annotations), 5)
+
+	back := &v1alpha1.SQBApplication{}
+	assert.NilError(t, app.ConvertTo(back))
+	assert.Equal(t, back.Annotations[v1alpha1.IstioInjectAnnotationKey], "true")
+	assert.Equal(t, back.Annotations[v1alpha1.IngressOpenAnnotationKey], "false")
+	assert.Equal(t, back.Annotations[v1alpha1.ServiceMonitorAnnotationKey],
+		`[{"port":"http-8080","path":"/metrics","interval":"15s"}]`)
+	assert.Equal(t, back.Annotations[v1alpha1.ServiceAnnotationKey], `{"a":"b"}`)
+	assert.Equal(t, back.Annotations["other"], "value")
+	assert.Equal(t, *back.Spec.Replicas, int32(2))
+}
+
+func TestTrafficPolicyConversion(t *testing.T) {
+	policy := &v1alpha1.TrafficPolicy{Retries: &v1alpha1.RetryPolicy{Attempts: 3, RetryOn: "5xx"}}
+	hub := &v1alpha1.SQBDeployment{Spec: v1alpha1.SQBDeploymentSpec{TrafficPolicy: policy}}
+	sqbdeployment := &SQBDeployment{}
+	assert.NilError(t, sqbdeployment.ConvertFrom(hub))
+	assert.Assert(t, sqbdeployment.Spec.Mesh.Inject == nil)
+	assert.DeepEqual(t, sqbdeployment.Spec.Mesh.TrafficPolicy, policy)
+
+	back := &v1alpha1.SQBDeployment{}
+	assert.NilError(t, sqbdeployment.ConvertTo(back))
+	assert.DeepEqual(t, back.Spec.TrafficPolicy, policy)
+}
+
+func TestConversionKeepsUnknownAnnotations(t *testing.T) {
+	hub := &v1alpha1.SQBApplication{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				v1alpha1.IstioInjectAnnotationKey:    "yes",
+				v1alpha1.ServiceMonitorAnnotationKey: `[{"port":"http-8080","relabelings":[{"action":"drop"}]}]`,
+				v1alpha1.ServiceAnnotationKey:        `not json`,
+			},
+		},
+	}
+	app := &SQBApplication{}
+	assert.NilError(t, app.ConvertFrom(hub))
+	assert.Assert(t, app.Spec.Mesh == nil)
+	assert.Assert(t, app.Spec.Monitoring == nil)
+	assert.Assert(t, app.Spec.ServiceAnnotations == nil)
+	assert.DeepEqual(t, app.Annotations, hub.Annotations)
+
+	back := &v1alpha1.SQBApplication{}
+	assert.NilError(t, app.ConvertTo(back))
+	assert.DeepEqual(t, back.Annotations, hub.Annotations)
+}
+
+func TestSQBDeploymentConversion(t *testing.T) {
+	deployment := &SQBDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo-base"},
+		Spec: SQBDeploymentSpec{
+			Selector:       v1alpha1.Selector{App: "demo", Plane: "base"},
+			PublicEntry:    proto.Bool(true),
+			PodAnnotations: map[string]string{"prometheus.io/scrape": "true"},
+		},
+	}
+	hub := &v1alpha1.SQBDeployment{}
+	assert.NilError(t, deployment.ConvertTo(hub))
+	assert.DeepEqual(t, hub.Annotations, map[string]string{
+		v1alpha1.PublicEntryAnnotationKey: "true",
+		v1alpha1.PodAnnotationKey:         `{"prometheus.io/scrape":"true"}`,
+	})
+	assert.Equal(t, hub.Spec.Selector.App, "demo")
+
+	back := &SQBDeployment{}
+	assert.NilError(t, back.ConvertFrom(hub))
+	assert.DeepEqual(t, back.Spec, deployment.Spec)
+	assert.Assert(t, back.Annotations == nil)
+}
@@ -0,0 +1,95 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/wosai/elastic-env-operator/api/v1alpha1"
+)
+
+// SQBApplicationSpec defines the desired state of SQBApplication
+type SQBApplicationSpec struct {
+	v1alpha1.IngressSpec `json:",inline"`
+	v1alpha1.ServiceSpec `json:",inline"`
+	v1alpha1.DeploySpec  `json:",inline"`
+
+	// IngressOpen 是否开启ingress入口，不设置时使用operator的默认配置
+	IngressOpen *bool `json:"ingressOpen,omitempty"`
+	// Mesh 服务网格配置
+	Mesh *MeshSpec `json:"mesh,omitempty"`
+	// Monitoring 监控配置，设置后生成ServiceMonitor或VMServiceScrape
+	Monitoring *MonitoringSpec `json:"monitoring,omitempty"`
+	// ServiceAnnotations 透传到service的annotation
+	ServiceAnnotations map[string]string `json:"serviceAnnotations,omitempty"`
+	// DestinationRuleAnnotations 透传到destinationrule的annotation
+	DestinationRuleAnnotations map[string]string `json:"destinationRuleAnnotations,omitempty"`
+	// VirtualServiceAnnotations 透传到virtualservice的annotation
+	VirtualServiceAnnotations map[string]string `json:"virtualServiceAnnotations,omitempty"`
+}
+
+type MeshSpec struct {
+	// Inject 是否注入sidecar，不设置时使用operator的默认配置
+	Inject *bool `json:"inject,omitempty"`
+	// TrafficPolicy 网格的流量策略，SQBDeployment中按环境覆盖SQBApplication的配置
+	TrafficPolicy *v1alpha1.TrafficPolicy `json:"trafficPolicy,omitempty"`
+}
+
+type MonitoringSpec struct {
+	Endpoints []MonitoringEndpoint `json:"endpoints"`
+}
+
+// MonitoringEndpoint ServiceMonitor和VMServiceScrape通用的endpoint配置
+type MonitoringEndpoint struct {
+	Port          string              `json:"port,omitempty"`
+	TargetPort    *intstr.IntOrString `json:"targetPort,omitempty"`
+	Path          string              `json:"path,omitempty"`
+	Scheme        string              `json:"scheme,omitempty"`
+	Params        map[string][]string `json:"params,omitempty"`
+	Interval      string              `json:"interval,omitempty"`
+	ScrapeTimeout string              `json:"scrapeTimeout,omitempty"`
+	HonorLabels   bool                `json:"honorLabels,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].reason"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// SQBApplication is the Schema for the sqbapplications API
+type SQBApplication struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SQBApplicationSpec            `json:"spec,omitempty"`
+	Status v1alpha1.SQBApplicationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// SQBApplicationList contains a list of SQBApplication
+type SQBApplicationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SQBApplication `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SQBApplication{}, &SQBApplicationList{})
+}
@@ -0,0 +1,80 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/wosai/elastic-env-operator/api/v1alpha1"
+)
+
+// SQBDeploymentSpec defines the desired state of SQBDeployment
+type SQBDeploymentSpec struct {
+	Selector            v1alpha1.Selector `json:"selector"`
+	v1alpha1.DeploySpec `json:",inline"`
+
+	// PublicEntry 是否开启外网入口，默认不开启
+	PublicEntry *bool `json:"publicEntry,omitempty"`
+	// Mesh 服务网格配置，默认继承SQBApplication的配置
+	Mesh *MeshSpec `json:"mesh,omitempty"`
+	// Mirror 把来源环境的请求复制一份到该环境
+	Mirror *v1alpha1.MirrorSpec `json:"mirror,omitempty"`
+	// Canary 灰度发布配置，只对gray环境生效
+	Canary *v1alpha1.CanarySpec `json:"canary,omitempty"`
+	// Strategy 发布策略，rollingUpdate(默认)或blueGreen
+	// +kubebuilder:validation:Enum=rollingUpdate;blueGreen
+	Strategy  string                  `json:"strategy,omitempty"`
+	BlueGreen *v1alpha1.BlueGreenSpec `json:"blueGreen,omitempty"`
+	// DeploymentAnnotations 透传到deployment的annotation
+	DeploymentAnnotations map[string]string `json:"deploymentAnnotations,omitempty"`
+	// PodAnnotations 透传到pod的annotation
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="App",type="string",JSONPath=".spec.selector.app"
+// +kubebuilder:printcolumn:name="Plane",type="string",JSONPath=".spec.selector.plane"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].reason"
+// +kubebuilder:printcolumn:name="Image",type="string",JSONPath=".status.effective.image",priority=1
+// +kubebuilder:printcolumn:name="Canary",type="string",JSONPath=".status.canary.phase",priority=1
+// +kubebuilder:printcolumn:name="Weight",type="integer",JSONPath=".status.canary.weight",priority=1
+// +kubebuilder:printcolumn:name="Active",type="string",JSONPath=".status.blueGreen.activeColor",priority=1
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// SQBDeployment is the Schema for the sqbdeployments API
+type SQBDeployment struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SQBDeploymentSpec            `json:"spec,omitempty"`
+	Status v1alpha1.SQBDeploymentStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// SQBDeploymentList contains a list of SQBDeployment
+type SQBDeploymentList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SQBDeployment `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SQBDeployment{}, &SQBDeploymentList{})
+}
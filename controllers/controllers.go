@@ -2,6 +2,8 @@ package controllers
 
 import (
 	"reflect"
+
+	appv1 "k8s.io/api/apps/v1"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
@@ -24,4 +26,31 @@ var (
 			return false
 		},
 	}
+
+	// 只处理deployment滚动更新状态的变化
+	DeploymentRolloutPredicate = predicate.Funcs{
+		CreateFunc: func(event event.CreateEvent) bool {
+			return false
+		},
+		UpdateFunc: func(event event.UpdateEvent) bool {
+			oldDeployment, ok := event.ObjectOld.(*appv1.Deployment)
+			if !ok {
+				return false
+			}
+			newDeployment, ok := event.ObjectNew.(*appv1.Deployment)
+			if !ok {
+				return false
+			}
+			return oldDeployment.Status.ObservedGeneration != newDeployment.Status.ObservedGeneration ||
+				oldDeployment.Status.Replicas != newDeployment.Status.Replicas ||
+				oldDeployment.Status.UpdatedReplicas != newDeployment.Status.UpdatedReplicas ||
+				oldDeployment.Status.AvailableReplicas != newDeployment.Status.AvailableReplicas
+		},
+		DeleteFunc: func(event event.DeleteEvent) bool {
+			return false
+		},
+		GenericFunc: func(event event.GenericEvent) bool {
+			return false
+		},
+	}
 )
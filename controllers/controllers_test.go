@@ -11,6 +11,7 @@ import (
 	appv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -476,7 +477,7 @@ var _ = Describe("Controller", func() {
 			initContainer := deployment.Spec.Template.Spec.InitContainers[0]
 			Expect(initContainer.Image).To(Equal(image))
 			Expect(initContainer.Command).To(Equal([]string{"sleep", "1"}))
-			Expect(container.VolumeMounts[0].Name).To(Equal("volume-0"))
+			Expect(container.VolumeMounts[0].Name).To(HavePrefix("volume-"))
 			Expect(container.VolumeMounts[0].MountPath).To(Equal("/tmp"))
 			required := deployment.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
 			Expect(required.NodeSelectorTerms[0].MatchExpressions[0].Key).To(Equal("node"))
@@ -486,10 +487,24 @@ var _ = Describe("Controller", func() {
 			Expect(preferred.Preference.MatchExpressions[0].Key).To(Equal("node"))
 			Expect(preferred.Preference.MatchExpressions[0].Values[0]).To(Equal("qa"))
 			Expect(preferred.Weight).To(Equal(int32(100)))
-			Expect(deployment.Spec.Template.Spec.Volumes[0].Name).To(Equal("volume-0"))
+			Expect(deployment.Spec.Template.Spec.Volumes[0].Name).To(Equal(container.VolumeMounts[0].Name))
 			Expect(deployment.Spec.Template.Spec.Volumes[0].HostPath.Path).To(Equal("/tmp"))
 		})
 
+		It("sqbdeployment status conditions", func() {
+			Eventually(func() bool {
+				_ = k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: deploymentName}, sqbdeployment)
+				return meta.IsStatusConditionTrue(sqbdeployment.Status.Conditions, qav1alpha1.ConditionReconciled)
+			}).WithTimeout(5 * time.Second).Should(BeTrue())
+			Expect(sqbdeployment.Status.ObservedGeneration).To(Equal(sqbdeployment.Generation))
+			Expect(meta.IsStatusConditionFalse(sqbdeployment.Status.Conditions, qav1alpha1.ConditionDegraded)).To(BeTrue())
+
+			Eventually(func() bool {
+				_ = k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: applicationName}, sqbapplication)
+				return meta.IsStatusConditionTrue(sqbapplication.Status.Conditions, qav1alpha1.ConditionReady)
+			}).WithTimeout(5 * time.Second).Should(BeTrue())
+		})
+
 		It("ingress close", func() {
 			_ = k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: applicationName}, sqbapplication)
 			sqbapplication.Annotations[entity.IngressOpenAnnotationKey] = "false"
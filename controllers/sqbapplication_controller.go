@@ -23,7 +23,11 @@ import (
 	sqbhandler "github.com/wosai/elastic-env-operator/domain/handler"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlhandler "sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 // SQBApplicationReconciler reconciles a SQBApplication object
@@ -43,5 +47,21 @@ func (r *SQBApplicationReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 func (r *SQBApplicationReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&qav1alpha1.SQBApplication{}).
+		// sqbdeployment的流量策略、流量镜像和外网入口影响应用的网格资源，
+		// sqbdeployment变化或删除时重新生成，删除环境后自动去掉对应的镜像
+		Watches(&source.Kind{Type: &qav1alpha1.SQBDeployment{}},
+			ctrlhandler.EnqueueRequestsFromMapFunc(sqbapplicationForSqbdeployment),
+			builder.WithPredicates(GenerationAnnotationPredicate)).
 		Complete(r)
 }
+
+func sqbapplicationForSqbdeployment(obj client.Object) []reconcile.Request {
+	sqbdeployment, ok := obj.(*qav1alpha1.SQBDeployment)
+	if !ok || sqbdeployment.Spec.Selector.App == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{
+		Namespace: sqbdeployment.Namespace,
+		Name:      sqbdeployment.Spec.Selector.App,
+	}}}
+}
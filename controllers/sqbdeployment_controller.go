@@ -19,13 +19,19 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/go-logr/logr"
+	appv1 "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/cache"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlhandler "sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	cronhpav1beta1 "github.com/wosai/elastic-env-operator/api/cronhpa/v1beta1"
 	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
@@ -81,5 +87,37 @@ func (r *sqbDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 func (r *sqbDeploymentReconciler) setupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&qav1alpha1.SQBDeployment{}, builder.WithPredicates(GenerationAnnotationPredicate)).
+		// deployment滚动更新状态变化时刷新sqbdeployment的Ready condition，蓝绿发布时推进发布
+		Watches(&source.Kind{Type: &appv1.Deployment{}}, ctrlhandler.EnqueueRequestsFromMapFunc(sqbdeploymentForDeployment),
+			builder.WithPredicates(DeploymentRolloutPredicate)).
+		// sqbdeployment继承sqbapplication的deploy配置，sqbapplication的spec变化时重新调和所属的sqbdeployment
+		Watches(&source.Kind{Type: &qav1alpha1.SQBApplication{}},
+			ctrlhandler.EnqueueRequestsFromMapFunc(r.sqbdeploymentsForApplication),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
 		Complete(r)
 }
+
+func (r *sqbDeploymentReconciler) sqbdeploymentsForApplication(obj client.Object) []reconcile.Request {
+	sqbdeployments := &qav1alpha1.SQBDeploymentList{}
+	if err := r.List(context.TODO(), sqbdeployments, client.InNamespace(obj.GetNamespace())); err != nil {
+		r.Log.Error(err, "list sqbdeployments failed", "sqbapplication", obj.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0)
+	for _, sqbdeployment := range sqbdeployments.Items {
+		if sqbdeployment.Spec.Selector.App == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&sqbdeployment)})
+		}
+	}
+	return requests
+}
+
+// sqbdeploymentForDeployment deployment与sqbdeployment同名，蓝绿发布中green的deployment为{sqbdeployment名}-green
+func sqbdeploymentForDeployment(obj client.Object) []reconcile.Request {
+	name := obj.GetName()
+	if deployment, ok := obj.(*appv1.Deployment); ok && deployment.Spec.Selector != nil &&
+		deployment.Spec.Selector.MatchLabels[qav1alpha1.ColorLabelKey] == qav1alpha1.ColorGreen {
+		name = strings.TrimSuffix(name, "-"+qav1alpha1.ColorGreen)
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Namespace: obj.GetNamespace(), Name: name}}}
+}
@@ -33,7 +33,12 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
+	"github.com/wosai/elastic-env-operator/api/certmanager"
 	"github.com/wosai/elastic-env-operator/api/cronhpa"
+	"github.com/wosai/elastic-env-operator/api/gatewayapi"
+	"github.com/wosai/elastic-env-operator/api/istio"
+	"github.com/wosai/elastic-env-operator/api/linkerd"
+	"github.com/wosai/elastic-env-operator/api/smi"
 	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
 	"github.com/wosai/elastic-env-operator/domain/entity"
 	"github.com/wosai/elastic-env-operator/domain/handler"
@@ -86,6 +91,16 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 	err = cronhpa.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
+	err = certmanager.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+	err = gatewayapi.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+	err = istio.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+	err = linkerd.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
+	err = smi.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
 
 	// +kubebuilder:scaffold:scheme
 	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
@@ -1,27 +1,39 @@
 package entity
 
+import (
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+)
+
 const (
 	XEnvFlag                     = "x-env-flag"
-	AppKey                       = "app"
-	PlaneKey                     = "version"
+	AppKey                       = qav1alpha1.AppLabelKey
+	PlaneKey                     = qav1alpha1.PlaneLabelKey
+	ColorKey                     = qav1alpha1.ColorLabelKey
 	TeamKey                      = "team"
 	GroupKey                     = "group"
 	FINALIZER                    = "qa.shouqianba.com/finalizer"
 	ExplicitDeleteAnnotationKey  = "qa.shouqianba.com/delete"
-	IstioInjectAnnotationKey     = "qa.shouqianba.com/istio-inject"
-	IngressOpenAnnotationKey     = "qa.shouqianba.com/ingress-open"
-	PublicEntryAnnotationKey     = "qa.shouqianba.com/public-entry"
-	ServiceMonitorAnnotationKey  = "qa.shouqianba.com/service-monitor"
+	IstioInjectAnnotationKey     = qav1alpha1.IstioInjectAnnotationKey
+	IngressOpenAnnotationKey     = qav1alpha1.IngressOpenAnnotationKey
+	PublicEntryAnnotationKey     = qav1alpha1.PublicEntryAnnotationKey
+	ServiceMonitorAnnotationKey  = qav1alpha1.ServiceMonitorAnnotationKey
 	InitContainerAnnotationKey   = "qa.shouqianba.com/init-container-image"
 	SpecialVirtualServiceIngress = "qa.shouqianba.com/special-virtualservice-ingressclass"
-	DeploymentAnnotationKey      = "qa.shouqianba.com/passthrough-deployment"
-	PodAnnotationKey             = "qa.shouqianba.com/passthrough-pod"
-	ServiceAnnotationKey         = "qa.shouqianba.com/passthrough-service"
-	DestinationRuleAnnotationKey = "qa.shouqianba.com/passthrough-destinationrule"
-	VirtualServiceAnnotationKey  = "qa.shouqianba.com/passthrough-virtualservice"
+	DeploymentAnnotationKey      = qav1alpha1.DeploymentAnnotationKey
+	PodAnnotationKey             = qav1alpha1.PodAnnotationKey
+	ServiceAnnotationKey         = qav1alpha1.ServiceAnnotationKey
+	DestinationRuleAnnotationKey = qav1alpha1.DestinationRuleAnnotationKey
+	VirtualServiceAnnotationKey  = qav1alpha1.VirtualServiceAnnotationKey
 	InitializeAnnotationKey      = "qa.shouqianba.com/initialized"
+	SidecarsAnnotationKey        = "qa.shouqianba.com/sidecars"
+	TemplateHashAnnotationKey    = "qa.shouqianba.com/template-hash"
+	BlueGreenPromoteKey          = qav1alpha1.BlueGreenPromoteAnnotationKey
+	BlueGreenAbortKey            = qav1alpha1.BlueGreenAbortAnnotationKey
+	RetentionPolicyAnnotationKey = "qa.shouqianba.com/retention-policy"
 	IngressClassAnnotationKey    = "kubernetes.io/ingress.class"
+	RouteClassAnnotationKey      = "qa.shouqianba.com/route-class"
 	IstioSidecarInjectKey        = "sidecar.istio.io/inject"
+	LinkerdInjectKey             = "linkerd.io/inject"
 	JaegerInjectAnnotationKey    = "sidecar.jaegertracing.io/inject"
 	JaegerInjectedLabelKey       = "sidecar.jaegertracing.io/injected"
 	KubevelaLastAppliedTime      = "app.oam.dev/last-applied-time"
@@ -4,36 +4,100 @@ import (
 	"encoding/json"
 	"fmt"
 	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	gatewayv1beta1 "github.com/wosai/elastic-env-operator/api/gatewayapi/v1beta1"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
 )
 
 var ConfigMapData = &SQBConfigMapEntity{}
 
 type (
 	configMapData struct {
-		ingressOpen                  bool              // 默认是否开启ingress
-		istioInject                  bool              // 默认是否启用istio
-		istioEnable                  bool              // 集群是否安装istio
-		istioIngressGateway          bool              // 集群是否启用istio-ingressgateway，默认与istioEnable一致
-		istioTimeout                 int64             // istio连接超时时间
-		istioGateways                []string          // virtualservice应用的gateway
-		serviceMonitorEnable         bool              // 集群是否安装prometheus
-		victoriaMetricsEnable        bool              // 集群是否安装victoria metrics,serviceMonitorEnable和victoriaMetricsEnable互斥
-		pvcEnable                    bool              // 集群是否使用PVC
-		domainPostfix                map[string]string // 默认的域名后缀{"ingress class":"host"}
-		imagePullSecrets             string            // 默认的image pull secret名称
-		specialVirtualServiceIngress string            // 特性入口的域名对应的ingress class
-		deploymentSpec               string            // 默认的deployment全局配置
-		operatorDelay                int               // 启动完成后的延迟时间，主要为了operator重启后不全量reconcile
-		initContainerImage           string            // init container镜像
-		baseFlag                     string            // 基础环境标识
-		env                          string            // 所属环境，test/prod
+		ingressOpen                  bool                                        // 默认是否开启ingress
+		istioInject                  bool                                        // 默认是否启用istio
+		istioEnable                  bool                                        // 集群是否安装istio，使用其他网格时表示是否安装了网格
+		meshProvider                 string                                      // 服务网格的实现，istio或linkerd
+		istioIngressGateway          bool                                        // 集群是否启用istio-ingressgateway，默认与istioEnable一致
+		istioTimeout                 int64                                       // istio连接超时时间
+		istioGateways                []string                                    // virtualservice应用的gateway
+		serviceMonitorEnable         bool                                        // 集群是否安装prometheus
+		victoriaMetricsEnable        bool                                        // 集群是否安装victoria metrics,serviceMonitorEnable和victoriaMetricsEnable互斥
+		metricsAddress               string                                      // 灰度发布分析指标时查询的prometheus或victoria metrics地址
+		pvcEnable                    bool                                        // 集群是否使用PVC
+		pvcDefaults                  PVCDefaults                                 // PVC的默认配置
+		pvcDefaultsSet               bool                                        // 是否配置了pvcDefaults，没有配置时沿用ack-{group}的storageClass
+		certManagerEnable            bool                                        // 集群是否安装cert-manager
+		certManagerIssuer            qav1alpha1.CertManagerTLS                   // 默认签发证书的issuer
+		domainPostfix                map[string]string                           // 默认的域名后缀{"ingress class":"host"}
+		ingressClassProfiles         map[string]IngressClassProfile              // 按ingress class配置的ingress controller差异
+		ingressMode                  string                                      // 生成Ingress还是Gateway API的HTTPRoute
+		gatewayParentRefs            map[string][]gatewayv1beta1.ParentReference // 按ingress class配置HTTPRoute挂载的Gateway
+		imagePullSecrets             string                                      // 默认的image pull secret名称
+		specialVirtualServiceIngress string                                      // 特性入口的域名对应的ingress class
+		deploymentSpec               string                                      // 默认的deployment全局配置
+		operatorDelay                int                                         // 启动完成后的延迟时间，主要为了operator重启后不全量reconcile
+		initContainerImage           string                                      // init container镜像
+		startupTimeoutSeconds        int32                                       // 由healthCheck生成startupProbe时允许的最长启动时间
+		tolerations                  []v1.Toleration                             // 默认的tolerations
+		nodeSelector                 map[string]string                           // 默认的nodeSelector
+		topologySpreadConstraints    []v1.TopologySpreadConstraint               // 默认的topologySpreadConstraints
+		podAntiAffinity              *qav1alpha1.PodAntiAffinitySpec             // 默认的反亲和配置
+		securityProfile              SecurityProfile                             // 默认的安全配置
+		baseFlag                     string                                      // 基础环境标识
+		env                          string                                      // 所属环境，test/prod
 	}
 )
 
+// SecurityProfile 应用没有配置安全配置时使用的默认值
+type SecurityProfile struct {
+	PodSecurityContext *v1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+	SecurityContext    *v1.SecurityContext    `json:"securityContext,omitempty"`
+}
+
+// PVCDefaults 应用没有配置时PVC使用的默认值
+type PVCDefaults struct {
+	Size             *resource.Quantity              `json:"size,omitempty"`
+	StorageClassName string                          `json:"storageClassName,omitempty"`
+	AccessModes      []v1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+	VolumeMode       *v1.PersistentVolumeMode        `json:"volumeMode,omitempty"`
+	// GroupStorageClassNames 按sqbdeployment的group label选择storageClass，优先于storageClassName
+	GroupStorageClassNames map[string]string `json:"groupStorageClassNames,omitempty"`
+}
+
+// IngressClassProfile ingress class对应的ingress controller类型、默认annotation、屏蔽/metrics的方式和pathType
+type IngressClassProfile struct {
+	// Controller nginx、alb、traefik
+	Controller  string            `json:"controller,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// MetricsBlocking snippet(只支持nginx)、backend(/metrics转发到metricsBackend)、none，nginx默认为snippet，其他默认为none
+	MetricsBlocking string                              `json:"metricsBlocking,omitempty"`
+	MetricsBackend  *networkingv1.IngressServiceBackend `json:"metricsBackend,omitempty"`
+	// PathType 默认ImplementationSpecific
+	PathType networkingv1.PathType `json:"pathType,omitempty"`
+}
+
+const (
+	MeshProviderIstio   = "istio"
+	MeshProviderLinkerd = "linkerd"
+
+	IngressModeIngress = "ingress"
+	IngressModeGateway = "gateway"
+
+	IngressControllerNginx   = "nginx"
+	IngressControllerALB     = "alb"
+	IngressControllerTraefik = "traefik"
+
+	MetricsBlockingSnippet = "snippet"
+	MetricsBlockingBackend = "backend"
+	MetricsBlockingNone    = "none"
+)
+
 // operator相关的业务配置实体
 type SQBConfigMapEntity struct {
 	data        configMapData
@@ -47,6 +111,9 @@ const (
 	ENV_PROD string = "prod"
 )
 
+// DefaultStartupTimeoutSeconds 默认允许的最长启动时间
+const DefaultStartupTimeoutSeconds = 180
+
 func (sc *SQBConfigMapEntity) FromMap(data map[string]string) {
 	sc.mux.Lock()
 	defer sc.mux.Unlock()
@@ -57,12 +124,28 @@ func (sc *SQBConfigMapEntity) FromMap(data map[string]string) {
 	sc.data.istioInject = data["istioInject"] == "true"
 	sc.data.istioEnable = data["istioEnable"] == "true"
 	sc.data.istioIngressGateway = data["istioIngressGateway"] != "false"
+	sc.data.meshProvider = MeshProviderIstio
+	if data["meshProvider"] == MeshProviderLinkerd {
+		sc.data.meshProvider = MeshProviderLinkerd
+	}
 	sc.data.serviceMonitorEnable = data["serviceMonitorEnable"] == "true"
 	sc.data.victoriaMetricsEnable = data["victoriaMetricsEnable"] == "true"
 	if sc.data.serviceMonitorEnable && sc.data.victoriaMetricsEnable {
 		sc.data.victoriaMetricsEnable = false
 	}
+	sc.data.metricsAddress = data["metricsAddress"]
 	sc.data.pvcEnable = data["pvcEnable"] == "true"
+	sc.data.pvcDefaults = PVCDefaults{}
+	pvcDefaults, ok := data["pvcDefaults"]
+	sc.data.pvcDefaultsSet = ok
+	if ok {
+		_ = json.Unmarshal([]byte(pvcDefaults), &sc.data.pvcDefaults)
+	}
+	sc.data.certManagerEnable = data["certManagerEnable"] == "true"
+	sc.data.certManagerIssuer = qav1alpha1.CertManagerTLS{}
+	if certManagerIssuer, ok := data["certManagerIssuer"]; ok {
+		_ = json.Unmarshal([]byte(certManagerIssuer), &sc.data.certManagerIssuer)
+	}
 
 	if istioTimeout, ok := data["istioTimeout"]; ok {
 		timeout, err := strconv.Atoi(istioTimeout)
@@ -79,6 +162,18 @@ func (sc *SQBConfigMapEntity) FromMap(data map[string]string) {
 		_ = json.Unmarshal([]byte(domainPostfix), &domains)
 		sc.data.domainPostfix = domains
 	}
+	sc.data.ingressClassProfiles = nil
+	if profiles, ok := data["ingressClassProfiles"]; ok {
+		_ = json.Unmarshal([]byte(profiles), &sc.data.ingressClassProfiles)
+	}
+	sc.data.ingressMode = IngressModeIngress
+	if data["ingressMode"] == IngressModeGateway {
+		sc.data.ingressMode = IngressModeGateway
+	}
+	sc.data.gatewayParentRefs = nil
+	if parentRefs, ok := data["gatewayParentRefs"]; ok {
+		_ = json.Unmarshal([]byte(parentRefs), &sc.data.gatewayParentRefs)
+	}
 	sc.data.imagePullSecrets = data["imagePullSecrets"]
 	if istioGateways, ok := data["istioGateways"]; ok {
 		gateways := make([]string, 0)
@@ -100,6 +195,31 @@ func (sc *SQBConfigMapEntity) FromMap(data map[string]string) {
 	}
 	sc.data.operatorDelay = operatorDeplay
 	sc.data.initContainerImage = data["initContainerImage"]
+	startupTimeoutSeconds, err := strconv.Atoi(data["startupTimeoutSeconds"])
+	if err != nil || startupTimeoutSeconds <= 0 {
+		startupTimeoutSeconds = DefaultStartupTimeoutSeconds
+	}
+	sc.data.startupTimeoutSeconds = int32(startupTimeoutSeconds)
+	sc.data.tolerations = nil
+	if tolerations, ok := data["tolerations"]; ok {
+		_ = json.Unmarshal([]byte(tolerations), &sc.data.tolerations)
+	}
+	sc.data.nodeSelector = nil
+	if nodeSelector, ok := data["nodeSelector"]; ok {
+		_ = json.Unmarshal([]byte(nodeSelector), &sc.data.nodeSelector)
+	}
+	sc.data.topologySpreadConstraints = nil
+	if constraints, ok := data["topologySpreadConstraints"]; ok {
+		_ = json.Unmarshal([]byte(constraints), &sc.data.topologySpreadConstraints)
+	}
+	sc.data.podAntiAffinity = nil
+	if podAntiAffinity, ok := data["podAntiAffinity"]; ok {
+		_ = json.Unmarshal([]byte(podAntiAffinity), &sc.data.podAntiAffinity)
+	}
+	sc.data.securityProfile = SecurityProfile{}
+	if securityProfile, ok := data["securityProfile"]; ok {
+		_ = json.Unmarshal([]byte(securityProfile), &sc.data.securityProfile)
+	}
 	sc.data.baseFlag = data["baseFlag"]
 	if sc.data.baseFlag == "" {
 		sc.data.baseFlag = "base"
@@ -142,6 +262,50 @@ func (sc *SQBConfigMapEntity) GetDomainNameByClass(prefix, class string) string
 	return sc.GetDomainNames(prefix)[class]
 }
 
+// IngressClassProfile 没有配置profile的class兼容原来的行为：nginx class使用server-snippet屏蔽/metrics
+func (sc *SQBConfigMapEntity) IngressClassProfile(class string) IngressClassProfile {
+	sc.mux.RLock()
+	defer sc.mux.RUnlock()
+	profile, ok := sc.data.ingressClassProfiles[class]
+	if !ok && class == IngressControllerNginx {
+		profile.Controller = IngressControllerNginx
+	}
+	if profile.MetricsBlocking == "" {
+		profile.MetricsBlocking = MetricsBlockingNone
+		if profile.Controller == IngressControllerNginx {
+			profile.MetricsBlocking = MetricsBlockingSnippet
+		}
+	}
+	if profile.PathType == "" {
+		profile.PathType = networkingv1.PathTypeImplementationSpecific
+	}
+	annotations := make(map[string]string, len(profile.Annotations))
+	for k, v := range profile.Annotations {
+		annotations[k] = v
+	}
+	profile.Annotations = annotations
+	profile.MetricsBackend = profile.MetricsBackend.DeepCopy()
+	return profile
+}
+
+// IsGatewayMode 域名生成Gateway API的HTTPRoute而不是Ingress
+func (sc *SQBConfigMapEntity) IsGatewayMode() bool {
+	sc.mux.RLock()
+	defer sc.mux.RUnlock()
+	return sc.data.ingressMode == IngressModeGateway
+}
+
+// GatewayParentRefs ingress class对应的HTTPRoute需要挂载的Gateway
+func (sc *SQBConfigMapEntity) GatewayParentRefs(class string) []gatewayv1beta1.ParentReference {
+	sc.mux.RLock()
+	defer sc.mux.RUnlock()
+	parentRefs := make([]gatewayv1beta1.ParentReference, 0, len(sc.data.gatewayParentRefs[class]))
+	for _, parentRef := range sc.data.gatewayParentRefs[class] {
+		parentRefs = append(parentRefs, *parentRef.DeepCopy())
+	}
+	return parentRefs
+}
+
 func (sc *SQBConfigMapEntity) GetImagePullSecrets() []v1.LocalObjectReference {
 	sc.mux.RLock()
 	defer sc.mux.RUnlock()
@@ -189,6 +353,18 @@ func (sc *SQBConfigMapEntity) IstioInject() bool {
 	}
 }
 
+func (sc *SQBConfigMapEntity) MeshProvider() string {
+	sc.mux.RLock()
+	defer sc.mux.RUnlock()
+	return sc.data.meshProvider
+}
+
+func (sc *SQBConfigMapEntity) MetricsAddress() string {
+	sc.mux.RLock()
+	defer sc.mux.RUnlock()
+	return sc.data.metricsAddress
+}
+
 func (sc *SQBConfigMapEntity) HasIstioIngressGateway() bool {
 	sc.mux.RLock()
 	defer sc.mux.RUnlock()
@@ -217,6 +393,52 @@ func (sc *SQBConfigMapEntity) IsPVCEnable() bool {
 	return sc.data.pvcEnable
 }
 
+// PVCDefaults 没有配置size和accessModes时使用2Gi和ReadWriteMany。没有配置pvcDefaults时storageClass兼容之前的版本，
+// 有group label时为ack-{group}，否则为ack-qa；配置了pvcDefaults但没有配置storageClass时使用集群默认的storageClass
+func (sc *SQBConfigMapEntity) PVCDefaults(group string) (size resource.Quantity, storageClassName *string,
+	accessModes []v1.PersistentVolumeAccessMode, volumeMode *v1.PersistentVolumeMode) {
+	sc.mux.RLock()
+	defer sc.mux.RUnlock()
+	defaults := sc.data.pvcDefaults
+	size = resource.MustParse("2Gi")
+	if defaults.Size != nil {
+		size = defaults.Size.DeepCopy()
+	}
+	if name := defaults.GroupStorageClassNames[group]; name != "" {
+		storageClassName = &name
+	} else if defaults.StorageClassName != "" {
+		name = defaults.StorageClassName
+		storageClassName = &name
+	} else if !sc.data.pvcDefaultsSet {
+		name = "ack-qa"
+		if group != "" {
+			name = "ack-" + group
+		}
+		storageClassName = &name
+	}
+	accessModes = []v1.PersistentVolumeAccessMode{v1.ReadWriteMany}
+	if len(defaults.AccessModes) != 0 {
+		accessModes = append([]v1.PersistentVolumeAccessMode{}, defaults.AccessModes...)
+	}
+	if defaults.VolumeMode != nil {
+		mode := *defaults.VolumeMode
+		volumeMode = &mode
+	}
+	return
+}
+
+func (sc *SQBConfigMapEntity) IsCertManagerEnable() bool {
+	sc.mux.RLock()
+	defer sc.mux.RUnlock()
+	return sc.data.certManagerEnable
+}
+
+func (sc *SQBConfigMapEntity) CertManagerIssuer() qav1alpha1.CertManagerTLS {
+	sc.mux.RLock()
+	defer sc.mux.RUnlock()
+	return sc.data.certManagerIssuer
+}
+
 func (sc *SQBConfigMapEntity) SpecialVirtualServiceIngress() string {
 	sc.mux.RLock()
 	defer sc.mux.RUnlock()
@@ -253,6 +475,63 @@ func (sc *SQBConfigMapEntity) InitContainerImage() string {
 	return sc.data.initContainerImage
 }
 
+func (sc *SQBConfigMapEntity) StartupTimeoutSeconds() int32 {
+	sc.mux.RLock()
+	defer sc.mux.RUnlock()
+	if sc.data.startupTimeoutSeconds == 0 {
+		return DefaultStartupTimeoutSeconds
+	}
+	return sc.data.startupTimeoutSeconds
+}
+
+func (sc *SQBConfigMapEntity) Tolerations() []v1.Toleration {
+	sc.mux.RLock()
+	defer sc.mux.RUnlock()
+	var tolerations []v1.Toleration
+	for _, toleration := range sc.data.tolerations {
+		tolerations = append(tolerations, *toleration.DeepCopy())
+	}
+	return tolerations
+}
+
+func (sc *SQBConfigMapEntity) NodeSelector() map[string]string {
+	sc.mux.RLock()
+	defer sc.mux.RUnlock()
+	if len(sc.data.nodeSelector) == 0 {
+		return nil
+	}
+	nodeSelector := make(map[string]string, len(sc.data.nodeSelector))
+	for k, v := range sc.data.nodeSelector {
+		nodeSelector[k] = v
+	}
+	return nodeSelector
+}
+
+func (sc *SQBConfigMapEntity) TopologySpreadConstraints() []v1.TopologySpreadConstraint {
+	sc.mux.RLock()
+	defer sc.mux.RUnlock()
+	var constraints []v1.TopologySpreadConstraint
+	for _, constraint := range sc.data.topologySpreadConstraints {
+		constraints = append(constraints, *constraint.DeepCopy())
+	}
+	return constraints
+}
+
+func (sc *SQBConfigMapEntity) PodAntiAffinity() *qav1alpha1.PodAntiAffinitySpec {
+	sc.mux.RLock()
+	defer sc.mux.RUnlock()
+	return sc.data.podAntiAffinity.DeepCopy()
+}
+
+func (sc *SQBConfigMapEntity) SecurityProfile() SecurityProfile {
+	sc.mux.RLock()
+	defer sc.mux.RUnlock()
+	return SecurityProfile{
+		PodSecurityContext: sc.data.securityProfile.PodSecurityContext.DeepCopy(),
+		SecurityContext:    sc.data.securityProfile.SecurityContext.DeepCopy(),
+	}
+}
+
 func (sc *SQBConfigMapEntity) BaseFlag() string {
 	sc.mux.RLock()
 	defer sc.mux.RUnlock()
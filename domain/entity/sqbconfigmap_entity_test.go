@@ -2,6 +2,8 @@ package entity
 
 import (
 	"gotest.tools/assert"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"testing"
 )
 
@@ -61,3 +63,94 @@ func TestConfigMap(t *testing.T) {
 		assert.Equal(t, configmap.IsInitialized(), true)
 	})
 }
+
+func TestStartupTimeoutSeconds(t *testing.T) {
+	configmap := &SQBConfigMapEntity{}
+	configmap.FromMap(map[string]string{"operatorDelay": "0"})
+	assert.Equal(t, configmap.StartupTimeoutSeconds(), int32(DefaultStartupTimeoutSeconds))
+	configmap.FromMap(map[string]string{"operatorDelay": "0", "startupTimeoutSeconds": "300"})
+	assert.Equal(t, configmap.StartupTimeoutSeconds(), int32(300))
+}
+
+func TestSchedulingDefaults(t *testing.T) {
+	configmap := &SQBConfigMapEntity{}
+	configmap.FromMap(map[string]string{
+		"operatorDelay":             "0",
+		"tolerations":               `[{"key":"spot","operator":"Exists","effect":"NoSchedule"}]`,
+		"nodeSelector":              `{"node-pool":"general"}`,
+		"topologySpreadConstraints": `[{"maxSkew":1,"topologyKey":"topology.kubernetes.io/zone","whenUnsatisfiable":"ScheduleAnyway"}]`,
+		"podAntiAffinity":           `{"disabled":true}`,
+	})
+	assert.Equal(t, configmap.Tolerations()[0].Key, "spot")
+	assert.Equal(t, configmap.NodeSelector()["node-pool"], "general")
+	assert.Equal(t, configmap.TopologySpreadConstraints()[0].TopologyKey, "topology.kubernetes.io/zone")
+	assert.Equal(t, configmap.PodAntiAffinity().Disabled, true)
+
+	configmap.FromMap(map[string]string{"operatorDelay": "0"})
+	assert.Equal(t, len(configmap.Tolerations()), 0)
+	assert.Assert(t, configmap.PodAntiAffinity() == nil)
+}
+
+func TestSecurityProfile(t *testing.T) {
+	configmap := &SQBConfigMapEntity{}
+	configmap.FromMap(map[string]string{
+		"operatorDelay":   "0",
+		"securityProfile": `{"podSecurityContext":{"runAsNonRoot":true},"securityContext":{"allowPrivilegeEscalation":false}}`,
+	})
+	profile := configmap.SecurityProfile()
+	assert.Equal(t, *profile.PodSecurityContext.RunAsNonRoot, true)
+	assert.Equal(t, *profile.SecurityContext.AllowPrivilegeEscalation, false)
+
+	configmap.FromMap(map[string]string{"operatorDelay": "0"})
+	assert.Assert(t, configmap.SecurityProfile().SecurityContext == nil)
+}
+
+func TestPVCDefaults(t *testing.T) {
+	configmap := &SQBConfigMapEntity{}
+	configmap.FromMap(map[string]string{"operatorDelay": "0"})
+	size, storageClassName, accessModes, volumeMode := configmap.PVCDefaults("")
+	assert.Equal(t, size.String(), "2Gi")
+	// 没有配置pvcDefaults时兼容之前的storageClass
+	assert.Equal(t, *storageClassName, "ack-qa")
+	assert.DeepEqual(t, accessModes, []v1.PersistentVolumeAccessMode{v1.ReadWriteMany})
+	assert.Assert(t, volumeMode == nil)
+	_, storageClassName, _, _ = configmap.PVCDefaults("crm")
+	assert.Equal(t, *storageClassName, "ack-crm")
+
+	// 配置了pvcDefaults但没有storageClass时使用集群默认的storageClass
+	configmap.FromMap(map[string]string{"operatorDelay": "0", "pvcDefaults": `{"size":"5Gi"}`})
+	_, storageClassName, _, _ = configmap.PVCDefaults("crm")
+	assert.Assert(t, storageClassName == nil)
+
+	configmap.FromMap(map[string]string{
+		"operatorDelay": "0",
+		"pvcDefaults": `{"size":"10Gi","storageClassName":"ack-qa","groupStorageClassNames":{"crm":"ack-crm"},` +
+			`"accessModes":["ReadWriteOnce"],"volumeMode":"Block"}`,
+	})
+	size, storageClassName, accessModes, volumeMode = configmap.PVCDefaults("crm")
+	assert.Equal(t, size.String(), "10Gi")
+	assert.Equal(t, *storageClassName, "ack-crm")
+	assert.DeepEqual(t, accessModes, []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce})
+	assert.Equal(t, *volumeMode, v1.PersistentVolumeBlock)
+	_, storageClassName, _, _ = configmap.PVCDefaults("other")
+	assert.Equal(t, *storageClassName, "ack-qa")
+}
+
+func TestIngressClassProfile(t *testing.T) {
+	configmap := &SQBConfigMapEntity{}
+	configmap.FromMap(map[string]string{
+		"operatorDelay":        "0",
+		"ingressClassProfiles": `{"traefik":{"controller":"traefik","annotations":{"a":"b"}},"nginx-vpc":{"controller":"nginx"}}`,
+	})
+	profile := configmap.IngressClassProfile("nginx")
+	assert.Equal(t, profile.MetricsBlocking, MetricsBlockingSnippet)
+	assert.Equal(t, profile.PathType, networkingv1.PathTypeImplementationSpecific)
+	assert.Equal(t, configmap.IngressClassProfile("nginx-internal").MetricsBlocking, MetricsBlockingNone)
+	assert.Equal(t, configmap.IngressClassProfile("nginx-vpc").MetricsBlocking, MetricsBlockingSnippet)
+
+	// 返回的annotation不影响配置
+	profile = configmap.IngressClassProfile("traefik")
+	assert.Equal(t, profile.MetricsBlocking, MetricsBlockingNone)
+	profile.Annotations["a"] = "c"
+	assert.Equal(t, configmap.IngressClassProfile("traefik").Annotations["a"], "b")
+}
@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 切换流量后旧版本默认等待30s再缩容，留给客户端断开长连接
+const defaultScaleDownDelay = 30 * time.Second
+
+// blueGreen 蓝绿发布：spec变化后新版本部署到另一个颜色的deployment，全部可用后切换service的selector，
+// 旧版本在scaleDownDelay之后缩容到0，下一次发布时复用
+func (h *deploymentHandler) blueGreen() error {
+	old := h.sqbdeployment.Status.BlueGreen
+	status := old.DeepCopy()
+	if status == nil {
+		status = &qav1alpha1.BlueGreenStatus{Phase: qav1alpha1.BlueGreenPhaseActive, ActiveColor: qav1alpha1.ColorBlue}
+	}
+	active, err := h.getDeployment(getColorDeploymentName(h.sqbdeployment.Name, status.ActiveColor))
+	if err != nil {
+		return err
+	}
+	desired := active.DeepCopy()
+	if err = h.build(desired, status.ActiveColor); err != nil {
+		return err
+	}
+	hash := desired.Annotations[entity.TemplateHashAnnotationKey]
+	// 第一次部署或者pod模板没有变化时直接更新，如副本数的变化
+	if active.CreationTimestamp.IsZero() || active.Annotations[entity.TemplateHashAnnotationKey] == hash {
+		if err = CreateOrUpdate(h.ctx, desired); err != nil {
+			return err
+		}
+		markDeploymentReady(&h.sqbdeployment.Status.Conditions, h.sqbdeployment.Generation, desired)
+		status.Phase = qav1alpha1.BlueGreenPhaseActive
+		if err = h.removeAnnotation(entity.BlueGreenAbortKey); err != nil {
+			return err
+		}
+		if err = h.scaleDownInactive(status); err != nil {
+			return err
+		}
+		return h.updateBlueGreenStatus(old, status)
+	}
+
+	previewName := getColorDeploymentName(h.sqbdeployment.Name, getInactiveColor(status.ActiveColor))
+	if h.sqbdeployment.Annotations[entity.BlueGreenAbortKey] == "true" {
+		if err = Delete(h.ctx, &appv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+			Namespace: h.sqbdeployment.Namespace,
+			Name:      previewName,
+		}}); err != nil {
+			return err
+		}
+		status.AbortedHash = hash
+		if err = h.removeAnnotation(entity.BlueGreenAbortKey); err != nil {
+			return err
+		}
+	}
+	// 放弃的版本不再发布，流量保持在当前版本
+	if hash == status.AbortedHash {
+		status.Phase = qav1alpha1.BlueGreenPhaseAborted
+		setCondition(&h.sqbdeployment.Status.Conditions, qav1alpha1.ConditionReady, metav1.ConditionFalse,
+			qav1alpha1.ReasonBlueGreenAborted, "preview deployment is aborted, update spec to retry", h.sqbdeployment.Generation)
+		return h.updateBlueGreenStatus(old, status)
+	}
+
+	preview, err := h.getDeployment(previewName)
+	if err != nil {
+		return err
+	}
+	if err = h.build(preview, getInactiveColor(status.ActiveColor)); err != nil {
+		return err
+	}
+	if err = CreateOrUpdate(h.ctx, preview); err != nil {
+		return err
+	}
+	status.Phase = qav1alpha1.BlueGreenPhasePreviewing
+	ready, message := deploymentRolledOut(preview)
+	autoPromote := h.sqbdeployment.Spec.BlueGreen == nil || h.sqbdeployment.Spec.BlueGreen.AutoPromote == nil ||
+		*h.sqbdeployment.Spec.BlueGreen.AutoPromote
+	switch {
+	case !ready:
+		setCondition(&h.sqbdeployment.Status.Conditions, qav1alpha1.ConditionReady, metav1.ConditionFalse,
+			qav1alpha1.ReasonDeploymentProgressing, "preview deployment: "+message, h.sqbdeployment.Generation)
+	case !autoPromote && h.sqbdeployment.Annotations[entity.BlueGreenPromoteKey] != "true":
+		setCondition(&h.sqbdeployment.Status.Conditions, qav1alpha1.ConditionReady, metav1.ConditionFalse,
+			qav1alpha1.ReasonBlueGreenPaused, fmt.Sprintf("preview deployment is available, set annotation %s to promote",
+				entity.BlueGreenPromoteKey), h.sqbdeployment.Generation)
+	default:
+		// 新版本全部可用后切换流量
+		now := metav1.Now()
+		status.Phase = qav1alpha1.BlueGreenPhaseActive
+		status.ActiveColor = getInactiveColor(status.ActiveColor)
+		status.SwitchTime = &now
+		status.AbortedHash = ""
+		if err = h.removeAnnotation(entity.BlueGreenPromoteKey); err != nil {
+			return err
+		}
+		markDeploymentReady(&h.sqbdeployment.Status.Conditions, h.sqbdeployment.Generation, preview)
+	}
+	return h.updateBlueGreenStatus(old, status)
+}
+
+// scaleDownInactive 切换流量scaleDownDelay之后把旧版本缩容到0，没有切换过时没有接收流量的版本直接缩容
+func (h *deploymentHandler) scaleDownInactive(status *qav1alpha1.BlueGreenStatus) error {
+	if status.SwitchTime != nil && blueGreenScaleDownRemaining(h.sqbdeployment, status, time.Now()) > 0 {
+		return nil
+	}
+	inactive := &appv1.Deployment{}
+	err := k8sclient.Get(h.ctx, client.ObjectKey{
+		Namespace: h.sqbdeployment.Namespace,
+		Name:      getColorDeploymentName(h.sqbdeployment.Name, getInactiveColor(status.ActiveColor)),
+	}, inactive)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if inactive.Spec.Replicas != nil && *inactive.Spec.Replicas == 0 {
+		return nil
+	}
+	replicas := int32(0)
+	inactive.Spec.Replicas = &replicas
+	return CreateOrUpdate(h.ctx, inactive)
+}
+
+// leaveBlueGreen 改回滚动更新后service不再按颜色选择pod，同名的deployment就绪后删除green的deployment
+func (h *deploymentHandler) leaveBlueGreen(deployment *appv1.Deployment) error {
+	if h.sqbdeployment.Status.BlueGreen != nil {
+		h.sqbdeployment.Status.BlueGreen = nil
+		if err := updateSqbdeploymentStatus(h.ctx, h.sqbdeployment); err != nil {
+			return err
+		}
+		if err := refreshApplicationRouting(h.ctx, h.sqbdeployment); err != nil {
+			return err
+		}
+	}
+	if ready, _ := deploymentRolledOut(deployment); !ready {
+		return nil
+	}
+	green := &appv1.Deployment{}
+	err := k8sclient.Get(h.ctx, client.ObjectKey{
+		Namespace: h.sqbdeployment.Namespace,
+		Name:      getColorDeploymentName(h.sqbdeployment.Name, qav1alpha1.ColorGreen),
+	}, green)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return Delete(h.ctx, green)
+}
+
+// updateBlueGreenStatus 切换流量后先保存状态，再重新生成应用的service和网格路由
+func (h *deploymentHandler) updateBlueGreenStatus(old, status *qav1alpha1.BlueGreenStatus) error {
+	h.sqbdeployment.Status.BlueGreen = status
+	if old != nil && old.ActiveColor == status.ActiveColor && old.SwitchTime.Equal(status.SwitchTime) {
+		return nil
+	}
+	if err := updateSqbdeploymentStatus(h.ctx, h.sqbdeployment); err != nil {
+		return err
+	}
+	return refreshApplicationRouting(h.ctx, h.sqbdeployment)
+}
+
+// removeAnnotation 蓝绿发布的annotation处理后删除，spec中是生效的deploy配置，不能直接更新sqbdeployment
+func (h *deploymentHandler) removeAnnotation(key string) error {
+	if _, ok := h.sqbdeployment.Annotations[key]; !ok {
+		return nil
+	}
+	in := &qav1alpha1.SQBDeployment{}
+	if err := k8sclient.Get(h.ctx, client.ObjectKeyFromObject(h.sqbdeployment), in); err != nil {
+		return err
+	}
+	delete(in.Annotations, key)
+	if err := CreateOrUpdate(h.ctx, in); err != nil {
+		return err
+	}
+	delete(h.sqbdeployment.Annotations, key)
+	h.sqbdeployment.ResourceVersion = in.ResourceVersion
+	return nil
+}
+
+// refreshApplicationRouting sqbdeployment的status变化不会触发sqbapplication的调和，切换颜色后直接更新选择pod的资源
+func refreshApplicationRouting(ctx context.Context, sqbdeployment *qav1alpha1.SQBDeployment) error {
+	sqbapplication := &qav1alpha1.SQBApplication{}
+	if err := k8sclient.Get(ctx, client.ObjectKey{Namespace: sqbdeployment.Namespace, Name: sqbdeployment.Spec.Selector.App},
+		sqbapplication); err != nil {
+		return err
+	}
+	handlers := append([]SQBHandler{
+		NewServiceHandler(sqbapplication, ctx),
+		NewCanaryIngressHandler(sqbapplication, ctx),
+	}, getMeshProvider().Handlers(sqbapplication, ctx)...)
+	for _, handler := range handlers {
+		if err := handler.Handle(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blueGreenScaleDownRemaining 旧版本缩容前剩余的等待时间
+func blueGreenScaleDownRemaining(sqbdeployment *qav1alpha1.SQBDeployment, status *qav1alpha1.BlueGreenStatus, now time.Time) time.Duration {
+	delay := defaultScaleDownDelay
+	if spec := sqbdeployment.Spec.BlueGreen; spec != nil && spec.ScaleDownDelay != nil {
+		delay = spec.ScaleDownDelay.Duration
+	}
+	return status.SwitchTime.Add(delay).Sub(now)
+}
+
+// blueGreenRequeueAfter 切换流量后到期时再次调和，缩容旧版本
+func blueGreenRequeueAfter(sqbdeployment *qav1alpha1.SQBDeployment) time.Duration {
+	status := sqbdeployment.Status.BlueGreen
+	if sqbdeployment.Spec.Strategy != qav1alpha1.StrategyBlueGreen || status == nil || status.SwitchTime == nil {
+		return 0
+	}
+	if remaining := blueGreenScaleDownRemaining(sqbdeployment, status, time.Now()); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// getActiveColor 切换过流量之后service按颜色选择pod，没有切换过时只有一个版本，返回空
+func getActiveColor(sqbdeployment *qav1alpha1.SQBDeployment) string {
+	status := sqbdeployment.Status.BlueGreen
+	if sqbdeployment.Spec.Strategy != qav1alpha1.StrategyBlueGreen || status == nil || status.SwitchTime == nil {
+		return ""
+	}
+	return status.ActiveColor
+}
+
+// getActiveColors 每个环境蓝绿发布中接收流量的颜色
+func getActiveColors(sqbdeployments []qav1alpha1.SQBDeployment) map[string]string {
+	colors := make(map[string]string)
+	for i := range sqbdeployments {
+		if color := getActiveColor(&sqbdeployments[i]); color != "" {
+			colors[sqbdeployments[i].Labels[entity.PlaneKey]] = color
+		}
+	}
+	return colors
+}
+
+func getInactiveColor(color string) string {
+	if color == qav1alpha1.ColorGreen {
+		return qav1alpha1.ColorBlue
+	}
+	return qav1alpha1.ColorGreen
+}
+
+// getColorDeploymentName blue的deployment与sqbdeployment同名，green为{sqbdeployment名}-green
+func getColorDeploymentName(name, color string) string {
+	if color == qav1alpha1.ColorGreen {
+		return name + "-" + qav1alpha1.ColorGreen
+	}
+	return name
+}
+
+// getTemplateHash pod模板的hash，不包含颜色，用于判断两个颜色的deployment是否为同一个版本
+func getTemplateHash(template *corev1.PodTemplateSpec) string {
+	t := template.DeepCopy()
+	delete(t.Labels, entity.ColorKey)
+	data, _ := json.Marshal(t)
+	return fmt.Sprintf("%x", md5.Sum(data))
+}
@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestBlueGreen(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = qav1alpha1.AddToScheme(scheme)
+	SetK8sScheme(scheme)
+	// fake client不会设置creationTimestamp，提前创建需要更新的对象
+	now := metav1.Now()
+	app := &qav1alpha1.SQBApplication{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo"}}
+	app.Spec.Ports = []corev1.ServicePort{{Name: "http-80", Port: 80, TargetPort: intstr.FromInt(8080)}}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo", CreationTimestamp: now}}
+	sqbdeployment := &qav1alpha1.SQBDeployment{ObjectMeta: metav1.ObjectMeta{
+		Namespace:         "default",
+		Name:              "demo-base",
+		Labels:            map[string]string{entity.AppKey: "demo", entity.PlaneKey: "base"},
+		CreationTimestamp: now,
+	}}
+	sqbdeployment.Spec.Selector = qav1alpha1.Selector{App: "demo", Plane: "base"}
+	sqbdeployment.Spec.Image = "demo:v1"
+	sqbdeployment.Spec.Replicas = proto.Int32(2)
+	sqbdeployment.Spec.Strategy = qav1alpha1.StrategyBlueGreen
+	SetK8sClient(statusSubresourceClient{fake.NewClientBuilder().WithScheme(scheme).WithObjects(app, service, sqbdeployment).Build()})
+	entity.ConfigMapData.FromMap(map[string]string{"operatorDelay": "0"})
+	t.Cleanup(func() {
+		SetK8sClient(nil)
+	})
+	ctx := context.Background()
+	handle := func() {
+		assert.Nil(t, NewDeploymentHandler(sqbdeployment, ctx).Handle())
+	}
+	getDeployment := func(name string) *appv1.Deployment {
+		deployment := &appv1.Deployment{}
+		assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, deployment))
+		return deployment
+	}
+	// 模拟deployment全部可用
+	available := func(name string) {
+		deployment := getDeployment(name)
+		deployment.CreationTimestamp = now
+		deployment.Status = appv1.DeploymentStatus{Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2}
+		assert.Nil(t, k8sclient.Update(ctx, deployment))
+	}
+	setAnnotation := func(key string) {
+		spec := sqbdeployment.Spec
+		assert.Nil(t, k8sclient.Get(ctx, client.ObjectKeyFromObject(sqbdeployment), sqbdeployment))
+		sqbdeployment.Spec = spec
+		sqbdeployment.Annotations = map[string]string{key: "true"}
+		assert.Nil(t, k8sclient.Update(ctx, sqbdeployment))
+	}
+	serviceSelector := func() map[string]string {
+		assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "demo"}, service))
+		return service.Spec.Selector
+	}
+
+	// 第一次部署直接创建blue的deployment
+	handle()
+	blue := getDeployment("demo-base")
+	assert.Equal(t, qav1alpha1.ColorBlue, blue.Spec.Template.Labels[entity.ColorKey])
+	assert.Equal(t, qav1alpha1.BlueGreenPhaseActive, sqbdeployment.Status.BlueGreen.Phase)
+	available("demo-base")
+
+	// 新版本部署到green的deployment，可用之前流量保持在blue
+	sqbdeployment.Spec.Image = "demo:v2"
+	handle()
+	green := getDeployment("demo-base-green")
+	assert.Equal(t, "demo:v2", green.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, qav1alpha1.ColorGreen, green.Spec.Selector.MatchLabels[entity.ColorKey])
+	assert.Equal(t, int32(2), *green.Spec.Replicas)
+	assert.Equal(t, "demo:v1", getDeployment("demo-base").Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, qav1alpha1.BlueGreenPhasePreviewing, sqbdeployment.Status.BlueGreen.Phase)
+	assert.True(t, meta.IsStatusConditionFalse(sqbdeployment.Status.Conditions, qav1alpha1.ConditionReady))
+
+	// green可用后切换service的selector
+	available("demo-base-green")
+	handle()
+	assert.Equal(t, qav1alpha1.ColorGreen, sqbdeployment.Status.BlueGreen.ActiveColor)
+	assert.True(t, meta.IsStatusConditionTrue(sqbdeployment.Status.Conditions, qav1alpha1.ConditionReady))
+	assert.Equal(t, qav1alpha1.ColorGreen, serviceSelector()[entity.ColorKey])
+	assert.True(t, blueGreenRequeueAfter(sqbdeployment) > 0)
+
+	// scaleDownDelay之后旧版本缩容到0
+	sqbdeployment.Spec.BlueGreen = &qav1alpha1.BlueGreenSpec{ScaleDownDelay: &metav1.Duration{}}
+	handle()
+	assert.Equal(t, int32(0), *getDeployment("demo-base").Spec.Replicas)
+	assert.Equal(t, int32(2), *getDeployment("demo-base-green").Spec.Replicas)
+
+	// 关闭自动切换后等待promote注解，切换后删除注解
+	sqbdeployment.Spec.BlueGreen.AutoPromote = proto.Bool(false)
+	sqbdeployment.Spec.Image = "demo:v3"
+	handle()
+	assert.Equal(t, "demo:v3", getDeployment("demo-base").Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, int32(2), *getDeployment("demo-base").Spec.Replicas)
+	ready := meta.FindStatusCondition(sqbdeployment.Status.Conditions, qav1alpha1.ConditionReady)
+	assert.Equal(t, qav1alpha1.ReasonBlueGreenPaused, ready.Reason)
+	assert.Equal(t, qav1alpha1.ColorGreen, serviceSelector()[entity.ColorKey])
+	setAnnotation(entity.BlueGreenPromoteKey)
+	handle()
+	assert.Equal(t, qav1alpha1.ColorBlue, sqbdeployment.Status.BlueGreen.ActiveColor)
+	assert.Equal(t, qav1alpha1.ColorBlue, serviceSelector()[entity.ColorKey])
+	stored := &qav1alpha1.SQBDeployment{}
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKeyFromObject(sqbdeployment), stored))
+	assert.NotContains(t, stored.Annotations, entity.BlueGreenPromoteKey)
+
+	// abort注解删除新版本，spec没有变化时不再发布
+	sqbdeployment.Spec.Image = "demo:v4"
+	handle()
+	assert.Equal(t, "demo:v4", getDeployment("demo-base-green").Spec.Template.Spec.Containers[0].Image)
+	setAnnotation(entity.BlueGreenAbortKey)
+	handle()
+	handle()
+	assert.Equal(t, qav1alpha1.BlueGreenPhaseAborted, sqbdeployment.Status.BlueGreen.Phase)
+	// deployment的finalizer由deployment controller去掉
+	assert.NotNil(t, getDeployment("demo-base-green").DeletionTimestamp)
+	assert.Equal(t, qav1alpha1.ColorBlue, serviceSelector()[entity.ColorKey])
+
+	// 改回滚动更新后service不再按颜色选择pod
+	sqbdeployment.Spec.Strategy = ""
+	handle()
+	assert.Nil(t, sqbdeployment.Status.BlueGreen)
+	assert.NotContains(t, serviceSelector(), entity.ColorKey)
+	blue = getDeployment("demo-base")
+	assert.Equal(t, "demo:v4", blue.Spec.Template.Spec.Containers[0].Image)
+	assert.NotContains(t, blue.Spec.Template.Labels, entity.ColorKey)
+}
+
+// statusSubresourceClient 与apiserver一样，更新status时只修改status，并返回存储的对象
+type statusSubresourceClient struct {
+	client.Client
+}
+
+func (c statusSubresourceClient) Status() client.SubResourceWriter {
+	return statusSubresourceWriter{SubResourceWriter: c.Client.Status(), client: c.Client}
+}
+
+type statusSubresourceWriter struct {
+	client.SubResourceWriter
+	client client.Client
+}
+
+func (w statusSubresourceWriter) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	sqbdeployment, ok := obj.(*qav1alpha1.SQBDeployment)
+	if !ok {
+		return w.SubResourceWriter.Update(ctx, obj, opts...)
+	}
+	stored := &qav1alpha1.SQBDeployment{}
+	if err := w.client.Get(ctx, client.ObjectKeyFromObject(sqbdeployment), stored); err != nil {
+		return err
+	}
+	stored.Status = sqbdeployment.Status
+	stored.ResourceVersion = sqbdeployment.ResourceVersion
+	if err := w.client.Update(ctx, stored); err != nil {
+		return err
+	}
+	stored.DeepCopyInto(sqbdeployment)
+	return nil
+}
+
+func TestUpdateSqbdeploymentStatus(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = qav1alpha1.AddToScheme(scheme)
+	SetK8sScheme(scheme)
+	// 存储的sqbdeployment没有配置镜像，继承sqbapplication的镜像
+	stored := &qav1alpha1.SQBDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo-gray"}}
+	SetK8sClient(statusSubresourceClient{fake.NewClientBuilder().WithScheme(scheme).WithObjects(stored).Build()})
+	t.Cleanup(func() {
+		SetK8sClient(nil)
+	})
+	ctx := context.Background()
+	sqbdeployment := &qav1alpha1.SQBDeployment{}
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKeyFromObject(stored), sqbdeployment))
+	sqbdeployment.Spec.Image = "demo:v2"
+	sqbdeployment.Status.BlueGreen = &qav1alpha1.BlueGreenStatus{ActiveColor: qav1alpha1.ColorGreen}
+
+	// 中途保存status后仍然是合并后的spec
+	assert.Nil(t, updateSqbdeploymentStatus(ctx, sqbdeployment))
+	assert.Equal(t, "demo:v2", sqbdeployment.Spec.Image)
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKeyFromObject(stored), stored))
+	assert.Equal(t, qav1alpha1.ColorGreen, stored.Status.BlueGreen.ActiveColor)
+	assert.Equal(t, "", stored.Spec.Image)
+	assert.Equal(t, stored.ResourceVersion, sqbdeployment.ResourceVersion)
+
+	// 直接更新时spec被服务端返回的原始值覆盖
+	assert.Nil(t, UpdateStatus(ctx, sqbdeployment))
+	assert.Equal(t, "", sqbdeployment.Spec.Image)
+}
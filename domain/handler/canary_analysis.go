@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+)
+
+const (
+	defaultErrorRateQuery = `sum(rate(istio_requests_total{reporter="destination",destination_workload_namespace="{{.Namespace}}",` +
+		`destination_workload="{{.Name}}",response_code=~"5.."}[1m])) / sum(rate(istio_requests_total{reporter="destination",` +
+		`destination_workload_namespace="{{.Namespace}}",destination_workload="{{.Name}}"}[1m]))`
+	defaultLatencyQuery = `histogram_quantile(0.99, sum(rate(istio_request_duration_milliseconds_bucket{reporter="destination",` +
+		`destination_workload_namespace="{{.Namespace}}",destination_workload="{{.Name}}"}[1m])) by (le))`
+)
+
+var metricsClient = &http.Client{Timeout: 10 * time.Second}
+
+// canaryResult 一次指标分析的结果
+type canaryResult int
+
+const (
+	canaryPassed canaryResult = iota
+	canaryFailed
+	// canaryInconclusive 查询没有数据，如查询语句错误或gray环境没有流量
+	canaryInconclusive
+)
+
+// analyzeCanary 分析gray环境的错误率和P99延迟，没有配置analysis时视为通过，没有数据时无法判断，
+// 查询失败时返回错误等待下次调和
+func analyzeCanary(ctx context.Context, sqbdeployment *qav1alpha1.SQBDeployment) (canaryResult, string, error) {
+	analysis := sqbdeployment.Spec.Canary.Analysis
+	if analysis == nil || (analysis.MaxErrorRate == "" && analysis.MaxLatency == nil) {
+		return canaryPassed, "", nil
+	}
+	address := analysis.Address
+	if address == "" {
+		address = entity.ConfigMapData.MetricsAddress()
+	}
+	if address == "" {
+		return canaryFailed, "", fmt.Errorf("invalid canary analysis: metrics address is not configured")
+	}
+	var messages []string
+	if analysis.MaxErrorRate != "" {
+		maxErrorRate, err := strconv.ParseFloat(analysis.MaxErrorRate, 64)
+		if err != nil {
+			return canaryFailed, "", fmt.Errorf("invalid canary analysis: maxErrorRate %s", analysis.MaxErrorRate)
+		}
+		query := analysis.ErrorRateQuery
+		if query == "" {
+			query = defaultErrorRateQuery
+		}
+		errorRate, ok, err := queryCanaryMetric(ctx, address, query, sqbdeployment)
+		if err != nil {
+			return canaryFailed, "", err
+		}
+		if !ok {
+			return canaryInconclusive, "no data for error rate query", nil
+		}
+		message := fmt.Sprintf("error rate %s, max %s", strconv.FormatFloat(errorRate, 'f', -1, 64), analysis.MaxErrorRate)
+		if errorRate > maxErrorRate {
+			return canaryFailed, message, nil
+		}
+		messages = append(messages, message)
+	}
+	if analysis.MaxLatency != nil {
+		query := analysis.LatencyQuery
+		if query == "" {
+			query = defaultLatencyQuery
+		}
+		latency, ok, err := queryCanaryMetric(ctx, address, query, sqbdeployment)
+		if err != nil {
+			return canaryFailed, "", err
+		}
+		if !ok {
+			return canaryInconclusive, "no data for latency query", nil
+		}
+		message := fmt.Sprintf("p99 latency %s, max %s",
+			time.Duration(latency*float64(time.Millisecond)).String(), analysis.MaxLatency.Duration.String())
+		if latency > float64(analysis.MaxLatency.Milliseconds()) {
+			return canaryFailed, message, nil
+		}
+		messages = append(messages, message)
+	}
+	return canaryPassed, strings.Join(messages, "; "), nil
+}
+
+// queryCanaryMetric 通过prometheus的/api/v1/query接口查询瞬时值，结果为空或NaN时ok为false
+func queryCanaryMetric(ctx context.Context, address, query string, sqbdeployment *qav1alpha1.SQBDeployment) (float64, bool, error) {
+	tpl, err := template.New("query").Parse(query)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid canary analysis query %s: %w", query, err)
+	}
+	buf := &bytes.Buffer{}
+	if err = tpl.Execute(buf, map[string]string{
+		"Namespace": sqbdeployment.Namespace,
+		"Name":      sqbdeployment.Name,
+		"App":       sqbdeployment.Spec.Selector.App,
+	}); err != nil {
+		return 0, false, fmt.Errorf("invalid canary analysis query %s: %w", query, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimSuffix(address, "/")+"/api/v1/query?query="+url.QueryEscape(buf.String()), nil)
+	if err != nil {
+		return 0, false, err
+	}
+	resp, err := metricsClient.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+	result := struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			Result []struct {
+				Value []interface{} `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, false, fmt.Errorf("query canary metric failed, status code %d: %w", resp.StatusCode, err)
+	}
+	if result.Status != "success" {
+		return 0, false, fmt.Errorf("query canary metric failed: %s", result.Error)
+	}
+	if len(result.Data.Result) == 0 || len(result.Data.Result[0].Value) != 2 {
+		return 0, false, nil
+	}
+	s, _ := result.Data.Result[0].Value[1].(string)
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("query canary metric failed: invalid value %v", result.Data.Result[0].Value[1])
+	}
+	if math.IsNaN(value) {
+		return 0, false, nil
+	}
+	return value, true, nil
+}
@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultMaxInconclusive = 3
+	// 步骤没有配置pause时，无法判断后重新分析的间隔
+	defaultInconclusiveInterval = time.Minute
+)
+
+// canaryHandler gray环境的镜像变化后，按步骤把基础环境的流量切到gray环境，每一步结束时分析指标，
+// 全部通过后把镜像发布到基础环境的sqbdeployment，不通过时把流量全部切回基础环境
+type canaryHandler struct {
+	sqbdeployment *qav1alpha1.SQBDeployment
+	ctx           context.Context
+}
+
+func NewCanaryHandler(sqbdeployment *qav1alpha1.SQBDeployment, ctx context.Context) *canaryHandler {
+	return &canaryHandler{sqbdeployment: sqbdeployment, ctx: ctx}
+}
+
+func (h *canaryHandler) CreateOrUpdate() error {
+	sqbapplication := &qav1alpha1.SQBApplication{}
+	if err := k8sclient.Get(h.ctx, client.ObjectKey{Namespace: h.sqbdeployment.Namespace, Name: h.sqbdeployment.Spec.Selector.App},
+		sqbapplication); err != nil {
+		return err
+	}
+	if !IsMeshInject(sqbapplication) {
+		return fmt.Errorf("invalid canary: sqbapplication %s is not injected into the mesh", sqbapplication.Name)
+	}
+	steps := h.sqbdeployment.Spec.Canary.Steps
+	if len(steps) == 0 {
+		return fmt.Errorf("invalid canary: steps must not be empty")
+	}
+	old := h.sqbdeployment.Status.Canary
+	status := old.DeepCopy()
+	// 镜像变化后重新开始
+	if status == nil || status.Image != h.sqbdeployment.Spec.Image {
+		status = &qav1alpha1.CanaryStatus{Phase: qav1alpha1.CanaryPhasePending, Image: h.sqbdeployment.Spec.Image}
+	}
+	now := metav1.Now()
+	switch status.Phase {
+	case qav1alpha1.CanaryPhasePending:
+		// deployment就绪后开始第一步
+		if meta.IsStatusConditionTrue(h.sqbdeployment.Status.Conditions, qav1alpha1.ConditionReady) {
+			status.Phase = qav1alpha1.CanaryPhaseProgressing
+			status.Step = 0
+			status.Weight = steps[0].Weight
+			status.StepStartTime = &now
+		}
+	case qav1alpha1.CanaryPhaseProgressing:
+		if canaryStepRemaining(h.sqbdeployment.Spec.Canary, status, now.Time) > 0 {
+			break
+		}
+		result, message, err := analyzeCanary(h.ctx, h.sqbdeployment)
+		if err != nil {
+			return err
+		}
+		status.Message = message
+		if result == canaryInconclusive {
+			status.Inconclusive++
+			status.Message = fmt.Sprintf("%s, inconclusive %d/%d", message, status.Inconclusive,
+				getMaxInconclusive(h.sqbdeployment.Spec.Canary))
+		} else {
+			status.Inconclusive = 0
+		}
+		switch {
+		case result == canaryFailed || status.Inconclusive > getMaxInconclusive(h.sqbdeployment.Spec.Canary):
+			status.Phase = qav1alpha1.CanaryPhaseRolledBack
+			status.Weight = 0
+		case result == canaryInconclusive:
+			// 保持当前步骤，按步骤的间隔重新分析
+			status.StepStartTime = &now
+		case int(status.Step)+1 >= len(steps):
+			if err = h.promote(sqbapplication, status); err != nil {
+				return err
+			}
+		default:
+			status.Step++
+			status.Weight = steps[status.Step].Weight
+			status.StepStartTime = &now
+		}
+	case qav1alpha1.CanaryPhasePaused:
+		if err := h.promote(sqbapplication, status); err != nil {
+			return err
+		}
+	}
+	if reflect.DeepEqual(old, status) {
+		return nil
+	}
+	return h.updateStatus(sqbapplication, status)
+}
+
+// updateStatus 先保存进度，再按新的权重重新生成网格的路由
+func (h *canaryHandler) updateStatus(sqbapplication *qav1alpha1.SQBApplication, status *qav1alpha1.CanaryStatus) error {
+	h.sqbdeployment.Status.Canary = status
+	if err := updateSqbdeploymentStatus(h.ctx, h.sqbdeployment); err != nil {
+		return err
+	}
+	for _, handler := range getMeshProvider().Handlers(sqbapplication, h.ctx) {
+		if err := handler.Handle(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promote 把灰度发布的镜像设置到基础环境的sqbdeployment。基础环境没有配置镜像、继承sqbapplication的镜像时，
+// 设置镜像后sqbapplication的镜像变化不再对基础环境生效，只暂停等待在sqbapplication上发布镜像
+func (h *canaryHandler) promote(sqbapplication *qav1alpha1.SQBApplication, status *qav1alpha1.CanaryStatus) error {
+	// sqbdeployment的名字不固定，按label查找基础环境
+	sqbdeploymentList := &qav1alpha1.SQBDeploymentList{}
+	err := k8sclient.List(h.ctx, sqbdeploymentList, client.InNamespace(h.sqbdeployment.Namespace),
+		client.MatchingLabels{entity.AppKey: h.sqbdeployment.Spec.Selector.App, entity.PlaneKey: entity.ConfigMapData.BaseFlag()})
+	if err != nil {
+		return err
+	}
+	if len(sqbdeploymentList.Items) == 0 {
+		return fmt.Errorf("base sqbdeployment of %s not found", h.sqbdeployment.Spec.Selector.App)
+	}
+	base := &sqbdeploymentList.Items[0]
+	if base.Spec.Image == "" && base.EffectiveDeploySpec(sqbapplication).Image != status.Image {
+		status.Phase = qav1alpha1.CanaryPhasePaused
+		status.Message = fmt.Sprintf("base sqbdeployment %s inherits image from sqbapplication %s, set image %s on the sqbapplication to promote",
+			base.Name, sqbapplication.Name, status.Image)
+		return nil
+	}
+	if base.Spec.Image != "" && base.Spec.Image != status.Image {
+		base.Spec.Image = status.Image
+		if err = CreateOrUpdate(h.ctx, base); err != nil {
+			return err
+		}
+	}
+	status.Phase = qav1alpha1.CanaryPhasePromoted
+	status.Weight = 0
+	return nil
+}
+
+func (h *canaryHandler) Delete() error {
+	return nil
+}
+
+func (h *canaryHandler) Name() string {
+	return "Canary"
+}
+
+// Handle 只处理gray环境的sqbdeployment，删除后由sqbapplication重新生成路由
+func (h *canaryHandler) Handle() error {
+	if deleted, _ := IsDeleted(h.sqbdeployment); deleted {
+		return h.Delete()
+	}
+	if h.sqbdeployment.Spec.Selector.Plane != qav1alpha1.CanaryPlane {
+		return nil
+	}
+	// 去掉canary配置后清除进度，sqbapplication随spec的变化重新生成路由
+	if h.sqbdeployment.Spec.Canary == nil {
+		h.sqbdeployment.Status.Canary = nil
+		return nil
+	}
+	return h.CreateOrUpdate()
+}
+
+// canaryStepRemaining 当前步骤剩余的暂停时间，无法判断后重新分析时步骤没有配置pause则等待1分钟
+func canaryStepRemaining(canary *qav1alpha1.CanarySpec, status *qav1alpha1.CanaryStatus, now time.Time) time.Duration {
+	if status.StepStartTime == nil || int(status.Step) >= len(canary.Steps) {
+		return 0
+	}
+	var pause time.Duration
+	if canary.Steps[status.Step].Pause != nil {
+		pause = canary.Steps[status.Step].Pause.Duration
+	}
+	if pause == 0 && status.Inconclusive > 0 {
+		pause = defaultInconclusiveInterval
+	}
+	return status.StepStartTime.Add(pause).Sub(now)
+}
+
+// getMaxInconclusive 连续无法判断的次数上限，默认3
+func getMaxInconclusive(canary *qav1alpha1.CanarySpec) int32 {
+	if canary.Analysis == nil || canary.Analysis.MaxInconclusive == nil {
+		return defaultMaxInconclusive
+	}
+	return *canary.Analysis.MaxInconclusive
+}
+
+// canaryRequeueAfter 灰度发布进行中时，当前步骤结束后再次调和
+func canaryRequeueAfter(sqbdeployment *qav1alpha1.SQBDeployment) time.Duration {
+	status := sqbdeployment.Status.Canary
+	if sqbdeployment.Spec.Canary == nil || status == nil || status.Phase != qav1alpha1.CanaryPhaseProgressing {
+		return 0
+	}
+	if remaining := canaryStepRemaining(sqbdeployment.Spec.Canary, status, time.Now()); remaining > time.Second {
+		return remaining
+	}
+	return time.Second
+}
+
+// getCanaryWeight 应用正在灰度发布时gray环境的流量百分比
+func getCanaryWeight(sqbdeployments []qav1alpha1.SQBDeployment) int32 {
+	for _, sqbdeployment := range sqbdeployments {
+		status := sqbdeployment.Status.Canary
+		if sqbdeployment.Labels[entity.PlaneKey] == qav1alpha1.CanaryPlane && sqbdeployment.Spec.Canary != nil &&
+			status != nil && (status.Phase == qav1alpha1.CanaryPhaseProgressing || status.Phase == qav1alpha1.CanaryPhasePaused) {
+			return status.Weight
+		}
+	}
+	return 0
+}
@@ -0,0 +1,273 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	istionetworkingv1beta1 "github.com/wosai/elastic-env-operator/api/istio/networking/v1beta1"
+	splitv1alpha2 "github.com/wosai/elastic-env-operator/api/smi/split/v1alpha2"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestCanary(t *testing.T) {
+	app := newIstioTestApplication(t)
+	ctx := context.Background()
+	errorRate := "0.001"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/query", r.URL.Path)
+		assert.Contains(t, r.URL.Query().Get("query"), `destination_workload="demo-gray"`)
+		_, _ = fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1,"%s"]}]}}`, errorRate)
+	}))
+	defer server.Close()
+
+	// fake client不会设置creationTimestamp，提前创建需要更新的对象
+	now := metav1.Now()
+	assert.Nil(t, k8sclient.Create(ctx, app))
+	for _, obj := range []client.Object{
+		&istionetworkingv1beta1.VirtualService{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo", CreationTimestamp: now}},
+		&istionetworkingv1beta1.DestinationRule{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo", CreationTimestamp: now}},
+	} {
+		assert.Nil(t, k8sclient.Create(ctx, obj))
+	}
+	base := &qav1alpha1.SQBDeployment{}
+	baseKey := client.ObjectKey{Namespace: "default", Name: "demo-base"}
+	assert.Nil(t, k8sclient.Get(ctx, baseKey, base))
+	base.CreationTimestamp = now
+	base.Spec.Image = "demo:v1"
+	assert.Nil(t, k8sclient.Update(ctx, base))
+
+	gray := &qav1alpha1.SQBDeployment{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default",
+		Name:      "demo-gray",
+		Labels:    map[string]string{entity.AppKey: "demo", entity.PlaneKey: qav1alpha1.CanaryPlane},
+	}}
+	gray.Spec.Selector = qav1alpha1.Selector{App: "demo", Plane: qav1alpha1.CanaryPlane}
+	gray.Spec.Image = "demo:v2"
+	gray.Spec.Canary = &qav1alpha1.CanarySpec{
+		Steps: []qav1alpha1.CanaryStep{{Weight: 20}, {Weight: 50}},
+		Analysis: &qav1alpha1.CanaryAnalysis{
+			Address:      server.URL,
+			MaxErrorRate: "0.01",
+		},
+	}
+	assert.Nil(t, k8sclient.Create(ctx, gray))
+	handle := func() {
+		assert.Nil(t, NewCanaryHandler(gray, ctx).Handle())
+	}
+	baseRoute := func() []istionetworkingv1beta1.HTTPRouteDestination {
+		virtualService := &istionetworkingv1beta1.VirtualService{}
+		assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "demo"}, virtualService))
+		routes := virtualService.Spec.Http
+		return routes[len(routes)-1].Route
+	}
+
+	// deployment就绪前等待
+	handle()
+	assert.Equal(t, qav1alpha1.CanaryPhasePending, gray.Status.Canary.Phase)
+	assert.Equal(t, time.Duration(0), canaryRequeueAfter(gray))
+
+	meta.SetStatusCondition(&gray.Status.Conditions, metav1.Condition{
+		Type: qav1alpha1.ConditionReady, Status: metav1.ConditionTrue, Reason: "Ready"})
+	handle()
+	assert.Equal(t, qav1alpha1.CanaryPhaseProgressing, gray.Status.Canary.Phase)
+	assert.Equal(t, int32(20), gray.Status.Canary.Weight)
+	assert.Equal(t, time.Second, canaryRequeueAfter(gray))
+	route := baseRoute()
+	assert.Equal(t, 2, len(route))
+	assert.Equal(t, "demo-base", route[0].Destination.Subset)
+	assert.Equal(t, int32(80), route[0].Weight)
+	assert.Equal(t, "demo-gray", route[1].Destination.Subset)
+	assert.Equal(t, int32(20), route[1].Weight)
+
+	// 指标分析通过后进入下一步
+	handle()
+	assert.Equal(t, int32(1), gray.Status.Canary.Step)
+	assert.Equal(t, int32(50), baseRoute()[1].Weight)
+
+	// 最后一步通过后发布到基础环境，流量切回基础环境
+	handle()
+	assert.Equal(t, qav1alpha1.CanaryPhasePromoted, gray.Status.Canary.Phase)
+	assert.Equal(t, 1, len(baseRoute()))
+	assert.Nil(t, k8sclient.Get(ctx, baseKey, base))
+	assert.Equal(t, "demo:v2", base.Spec.Image)
+
+	// 新的镜像错误率超过上限时回滚
+	gray.Spec.Image = "demo:v3"
+	errorRate = "0.2"
+	handle()
+	assert.Equal(t, qav1alpha1.CanaryPhaseProgressing, gray.Status.Canary.Phase)
+	assert.Equal(t, "demo:v3", gray.Status.Canary.Image)
+	handle()
+	assert.Equal(t, qav1alpha1.CanaryPhaseRolledBack, gray.Status.Canary.Phase)
+	assert.Equal(t, "error rate 0.2, max 0.01", gray.Status.Canary.Message)
+	assert.Equal(t, 1, len(baseRoute()))
+	assert.Nil(t, k8sclient.Get(ctx, baseKey, base))
+	assert.Equal(t, "demo:v2", base.Spec.Image)
+}
+
+func TestCanaryNoData(t *testing.T) {
+	app := newIstioTestApplication(t)
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+	}))
+	defer server.Close()
+	assert.Nil(t, k8sclient.Create(ctx, app))
+	now := metav1.Now()
+	for _, obj := range []client.Object{
+		&istionetworkingv1beta1.VirtualService{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo", CreationTimestamp: now}},
+		&istionetworkingv1beta1.DestinationRule{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo", CreationTimestamp: now}},
+	} {
+		assert.Nil(t, k8sclient.Create(ctx, obj))
+	}
+	gray := &qav1alpha1.SQBDeployment{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default",
+		Name:      "demo-gray",
+		Labels:    map[string]string{entity.AppKey: "demo", entity.PlaneKey: qav1alpha1.CanaryPlane},
+	}}
+	gray.Spec.Selector = qav1alpha1.Selector{App: "demo", Plane: qav1alpha1.CanaryPlane}
+	gray.Spec.Image = "demo:v2"
+	gray.Spec.Canary = &qav1alpha1.CanarySpec{
+		Steps: []qav1alpha1.CanaryStep{{Weight: 20}, {Weight: 50}},
+		Analysis: &qav1alpha1.CanaryAnalysis{Address: server.URL, MaxErrorRate: "0.01",
+			MaxInconclusive: proto.Int32(1)},
+	}
+	gray.Status.Canary = &qav1alpha1.CanaryStatus{Phase: qav1alpha1.CanaryPhaseProgressing, Image: "demo:v2", Weight: 20}
+	assert.Nil(t, k8sclient.Create(ctx, gray))
+	handle := func() {
+		assert.Nil(t, NewCanaryHandler(gray, ctx).Handle())
+	}
+
+	// 没有数据时无法判断，不作为调和失败，保持当前步骤，1分钟后重新分析
+	handle()
+	assert.Equal(t, qav1alpha1.CanaryPhaseProgressing, gray.Status.Canary.Phase)
+	assert.Equal(t, int32(0), gray.Status.Canary.Step)
+	assert.Equal(t, int32(1), gray.Status.Canary.Inconclusive)
+	assert.Equal(t, "no data for error rate query, inconclusive 1/1", gray.Status.Canary.Message)
+	assert.True(t, canaryRequeueAfter(gray) > 59*time.Second)
+
+	// 连续超过上限后回滚
+	past := metav1.NewTime(time.Now().Add(-time.Minute))
+	gray.Status.Canary.StepStartTime = &past
+	handle()
+	assert.Equal(t, qav1alpha1.CanaryPhaseRolledBack, gray.Status.Canary.Phase)
+	assert.Equal(t, int32(0), gray.Status.Canary.Weight)
+}
+
+func TestCanaryPromote(t *testing.T) {
+	app := newIstioTestApplication(t)
+	ctx := context.Background()
+	// 基础环境的sqbdeployment可以是任意名字
+	assert.Nil(t, k8sclient.Delete(ctx, &qav1alpha1.SQBDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo-base"}}))
+	base := &qav1alpha1.SQBDeployment{ObjectMeta: metav1.ObjectMeta{
+		Namespace:         "default",
+		Name:              "demo",
+		Labels:            map[string]string{entity.AppKey: "demo", entity.PlaneKey: "base"},
+		CreationTimestamp: metav1.Now(),
+	}}
+	base.Spec.Image = "demo:v1"
+	assert.Nil(t, k8sclient.Create(ctx, base))
+	gray := &qav1alpha1.SQBDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo-gray"}}
+	gray.Spec.Selector = qav1alpha1.Selector{App: "demo", Plane: qav1alpha1.CanaryPlane}
+	status := &qav1alpha1.CanaryStatus{Phase: qav1alpha1.CanaryPhaseProgressing, Image: "demo:v2", Weight: 50}
+	assert.Nil(t, NewCanaryHandler(gray, ctx).promote(app, status))
+	assert.Equal(t, qav1alpha1.CanaryPhasePromoted, status.Phase)
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKeyFromObject(base), base))
+	assert.Equal(t, "demo:v2", base.Spec.Image)
+}
+
+func TestCanaryPaused(t *testing.T) {
+	app := newIstioTestApplication(t)
+	app.Spec.Image = "demo:v1"
+	ctx := context.Background()
+	assert.Nil(t, k8sclient.Create(ctx, app))
+	// fake client不会设置creationTimestamp，提前创建需要更新的对象
+	now := metav1.Now()
+	for _, obj := range []client.Object{
+		&istionetworkingv1beta1.VirtualService{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo", CreationTimestamp: now}},
+		&istionetworkingv1beta1.DestinationRule{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo", CreationTimestamp: now}},
+	} {
+		assert.Nil(t, k8sclient.Create(ctx, obj))
+	}
+	gray := &qav1alpha1.SQBDeployment{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default",
+		Name:      "demo-gray",
+		Labels:    map[string]string{entity.AppKey: "demo", entity.PlaneKey: qav1alpha1.CanaryPlane},
+	}}
+	gray.Spec.Selector = qav1alpha1.Selector{App: "demo", Plane: qav1alpha1.CanaryPlane}
+	gray.Spec.Image = "demo:v2"
+	gray.Spec.Canary = &qav1alpha1.CanarySpec{Steps: []qav1alpha1.CanaryStep{{Weight: 50}}}
+	gray.Status.Canary = &qav1alpha1.CanaryStatus{Phase: qav1alpha1.CanaryPhaseProgressing, Image: "demo:v2", Weight: 50}
+	assert.Nil(t, k8sclient.Create(ctx, gray))
+	handle := func() {
+		assert.Nil(t, NewCanaryHandler(gray, ctx).Handle())
+	}
+
+	// 基础环境继承sqbapplication的镜像，不修改基础环境，保持权重等待在sqbapplication上发布
+	handle()
+	assert.Equal(t, qav1alpha1.CanaryPhasePaused, gray.Status.Canary.Phase)
+	assert.Contains(t, gray.Status.Canary.Message, "set image demo:v2 on the sqbapplication")
+	assert.Equal(t, int32(50), getCanaryWeight([]qav1alpha1.SQBDeployment{*gray}))
+	base := &qav1alpha1.SQBDeployment{}
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "demo-base"}, base))
+	assert.Equal(t, "", base.Spec.Image)
+
+	// sqbapplication发布镜像后完成
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKeyFromObject(app), app))
+	app.Spec.Image = "demo:v2"
+	assert.Nil(t, k8sclient.Update(ctx, app))
+	handle()
+	assert.Equal(t, qav1alpha1.CanaryPhasePromoted, gray.Status.Canary.Phase)
+	assert.Equal(t, int32(0), gray.Status.Canary.Weight)
+}
+
+func TestCanaryRequiresMesh(t *testing.T) {
+	app := newIstioTestApplication(t)
+	app.Annotations = map[string]string{entity.IstioInjectAnnotationKey: "false"}
+	ctx := context.Background()
+	assert.Nil(t, k8sclient.Create(ctx, app))
+	gray := &qav1alpha1.SQBDeployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo-gray"}}
+	gray.Spec.Selector = qav1alpha1.Selector{App: "demo", Plane: qav1alpha1.CanaryPlane}
+	gray.Spec.Canary = &qav1alpha1.CanarySpec{Steps: []qav1alpha1.CanaryStep{{Weight: 20}}}
+	err := NewCanaryHandler(gray, ctx).Handle()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid canary")
+}
+
+func TestCanaryTrafficSplit(t *testing.T) {
+	app := newIstioTestApplication(t)
+	entity.ConfigMapData.FromMap(map[string]string{
+		"operatorDelay": "0",
+		"istioEnable":   "true",
+		"istioInject":   "true",
+		"meshProvider":  "linkerd",
+	})
+	ctx := context.Background()
+	gray := &qav1alpha1.SQBDeployment{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default",
+		Name:      "demo-gray",
+		Labels:    map[string]string{entity.AppKey: "demo", entity.PlaneKey: qav1alpha1.CanaryPlane},
+	}}
+	gray.Spec.Canary = &qav1alpha1.CanarySpec{Steps: []qav1alpha1.CanaryStep{{Weight: 30}}}
+	gray.Status.Canary = &qav1alpha1.CanaryStatus{Phase: qav1alpha1.CanaryPhaseProgressing, Weight: 30}
+	assert.Nil(t, k8sclient.Create(ctx, gray))
+
+	assert.Nil(t, NewTrafficSplitHandler(app, ctx).Handle())
+	trafficSplit := &splitv1alpha2.TrafficSplit{}
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "demo"}, trafficSplit))
+	assert.Equal(t, []splitv1alpha2.TrafficSplitBackend{
+		{Service: "demo-base", Weight: 70},
+		{Service: "demo-feature"},
+		{Service: "demo-gray", Weight: 30},
+	}, trafficSplit.Spec.Backends)
+}
@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"context"
+
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	"github.com/wosai/elastic-env-operator/domain/util"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	canaryAnnotationKey              = "nginx.ingress.kubernetes.io/canary"
+	canaryByHeaderAnnotationKey      = "nginx.ingress.kubernetes.io/canary-by-header"
+	canaryByHeaderValueAnnotationKey = "nginx.ingress.kubernetes.io/canary-by-header-value"
+)
+
+// canaryIngressHandler 没有开启istio的应用，为每个特性环境创建选择该环境pod的service，
+// 并为nginx class的域名创建按x-env-flag请求头路由到该环境的ingress-nginx canary ingress。
+// 开启linkerd注入的应用还需要基础环境的service作为TrafficSplit的backend
+type canaryIngressHandler struct {
+	sqbapplication *qav1alpha1.SQBApplication
+	ctx            context.Context
+}
+
+func NewCanaryIngressHandler(sqbapplication *qav1alpha1.SQBApplication, ctx context.Context) *canaryIngressHandler {
+	return &canaryIngressHandler{sqbapplication: sqbapplication, ctx: ctx}
+}
+
+func (h *canaryIngressHandler) CreateOrUpdate() error {
+	sqbdeployments, err := getSqbdeployments(h.ctx, h.sqbapplication)
+	if err != nil {
+		return err
+	}
+	planes := make([]string, 0)
+	for _, plane := range getPlanes(sqbdeployments) {
+		if plane != entity.ConfigMapData.BaseFlag() {
+			planes = append(planes, plane)
+		}
+	}
+	// gateway模式下外网特殊入口的HTTPRoute也转发到环境的service
+	servicePlanes := planes
+	if IsMeshInject(h.sqbapplication) || entity.ConfigMapData.IsGatewayMode() {
+		servicePlanes = getPlanes(sqbdeployments)
+	}
+	colors := getActiveColors(sqbdeployments)
+	for _, plane := range servicePlanes {
+		if err = h.createOrUpdateService(plane, colors[plane]); err != nil {
+			return err
+		}
+	}
+	ingressNames := make([]string, 0)
+	if IsIngressOpen(h.sqbapplication) && !entity.ConfigMapData.IsGatewayMode() {
+		for _, domain := range h.sqbapplication.Spec.Domains {
+			// canary annotation只有ingress-nginx支持
+			if entity.ConfigMapData.IngressClassProfile(domain.Class).Controller != entity.IngressControllerNginx {
+				continue
+			}
+			for _, plane := range planes {
+				name, err := h.createOrUpdateIngress(domain, plane)
+				if err != nil {
+					return err
+				}
+				ingressNames = append(ingressNames, name)
+			}
+		}
+	}
+	return h.clean(servicePlanes, ingressNames)
+}
+
+// createOrUpdateService 环境的service名字与istio的subset相同，为{应用名}-{plane}，蓝绿发布的环境只选择color的pod
+func (h *canaryIngressHandler) createOrUpdateService(plane, color string) error {
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Namespace: h.sqbapplication.Namespace,
+		Name:      util.GetSubsetName(h.sqbapplication.Name, plane),
+	}}
+	err := k8sclient.Get(h.ctx, client.ObjectKey{Namespace: service.Namespace, Name: service.Name}, service)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	service.Spec.Ports = preserveNodePorts(service.Spec.Ports, h.sqbapplication.Spec.Ports)
+	service.Spec.Selector = map[string]string{
+		entity.AppKey:   h.sqbapplication.Name,
+		entity.PlaneKey: plane,
+	}
+	if color != "" {
+		service.Spec.Selector[entity.ColorKey] = color
+	}
+	service.Labels = util.MergeStringMap(service.Labels, h.sqbapplication.Labels)
+	service.Labels[entity.AppKey] = h.sqbapplication.Name
+	service.Labels[entity.PlaneKey] = plane
+	return CreateOrUpdate(h.ctx, service)
+}
+
+// createOrUpdateIngress 与域名的ingress相同的host，请求头x-env-flag为plane时转发到环境的service
+func (h *canaryIngressHandler) createOrUpdateIngress(domain qav1alpha1.Domain, plane string) (string, error) {
+	ingress := &v1.Ingress{ObjectMeta: metav1.ObjectMeta{
+		Namespace: h.sqbapplication.Namespace,
+		Name:      getCanaryIngressName(h.sqbapplication.Name, domain.Class, domain.Host, plane),
+	}}
+	err := k8sclient.Get(h.ctx, client.ObjectKey{Namespace: ingress.Namespace, Name: ingress.Name}, ingress)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	}
+	ingress.Spec.Rules = []v1.IngressRule{{
+		Host: domain.Host,
+		IngressRuleValue: v1.IngressRuleValue{
+			HTTP: &v1.HTTPIngressRuleValue{
+				Paths: []v1.HTTPIngressPath{
+					defaultIngressPath(h.sqbapplication, util.GetSubsetName(h.sqbapplication.Name, plane)),
+				},
+			},
+		},
+	}}
+	ingress.Labels = util.MergeStringMap(ingress.Labels, map[string]string{
+		entity.AppKey:   h.sqbapplication.Name,
+		entity.GroupKey: h.sqbapplication.Labels[entity.GroupKey],
+		entity.PlaneKey: plane,
+	})
+	applyIngressClassProfile(ingress, domain.Class, domain.Annotation)
+	ingress.Annotations[canaryAnnotationKey] = "true"
+	ingress.Annotations[canaryByHeaderAnnotationKey] = entity.XEnvFlag
+	ingress.Annotations[canaryByHeaderValueAnnotationKey] = plane
+	return ingress.Name, CreateOrUpdate(h.ctx, ingress)
+}
+
+// clean 删除已经没有部署的环境的service和canary ingress
+func (h *canaryIngressHandler) clean(planes, ingressNames []string) error {
+	listOptions := &client.ListOptions{
+		Namespace:     h.sqbapplication.Namespace,
+		LabelSelector: labels.SelectorFromSet(map[string]string{entity.AppKey: h.sqbapplication.Name}),
+	}
+	ingressList := &v1.IngressList{}
+	if err := k8sclient.List(h.ctx, ingressList, listOptions); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	for _, ingress := range ingressList.Items {
+		if h.isAutoCanaryIngress(ingress) && !util.ContainString(ingressNames, ingress.Name) {
+			if err := deleteIngress(h.ctx, &ingress); err != nil {
+				return err
+			}
+		}
+	}
+	serviceList := &corev1.ServiceList{}
+	if err := k8sclient.List(h.ctx, serviceList, listOptions); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	for _, service := range serviceList.Items {
+		plane := service.Labels[entity.PlaneKey]
+		if plane == "" || service.Name != util.GetSubsetName(h.sqbapplication.Name, plane) || util.ContainString(planes, plane) {
+			continue
+		}
+		if err := Delete(h.ctx, &service); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *canaryIngressHandler) Delete() error {
+	return h.clean(nil, nil)
+}
+
+func (h *canaryIngressHandler) Name() string {
+	return "CanaryIngress"
+}
+
+// Handle 开启istio的应用由VirtualService按x-env-flag路由，linkerd不支持按请求头路由，仍使用canary ingress。
+// gateway模式下集群没有istio-ingressgateway时，HTTPRoute直接转发到环境的service
+func (h *canaryIngressHandler) Handle() error {
+	headerRouting := IsMeshInject(h.sqbapplication) && getMeshProvider().HeaderRouting() &&
+		(HasMeshIngressGateway() || !entity.ConfigMapData.IsGatewayMode())
+	if deleted, _ := IsDeleted(h.sqbapplication); deleted || headerRouting {
+		return h.Delete()
+	}
+	return h.CreateOrUpdate()
+}
+
+// isAutoCanaryIngress 判断一个canary ingress是否是自动生成的
+func (h *canaryIngressHandler) isAutoCanaryIngress(ingress v1.Ingress) bool {
+	plane := ingress.Labels[entity.PlaneKey]
+	if plane == "" || ingress.Spec.IngressClassName == nil || len(ingress.Spec.Rules) < 1 {
+		return false
+	}
+	return getCanaryIngressName(h.sqbapplication.Name, *ingress.Spec.IngressClassName,
+		ingress.Spec.Rules[0].Host, plane) == ingress.Name
+}
+
+func getCanaryIngressName(appName, nginxClass, host, plane string) string {
+	return GetIngressName(appName, nginxClass, host) + "-" + plane
+}
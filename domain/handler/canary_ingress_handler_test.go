@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCanaryIngress(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = qav1alpha1.AddToScheme(scheme)
+	SetK8sScheme(scheme)
+	feature := &qav1alpha1.SQBDeployment{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default",
+		Name:      "demo-feature",
+		Labels:    map[string]string{entity.AppKey: "demo", entity.PlaneKey: "feature"},
+	}}
+	SetK8sClient(fake.NewClientBuilder().WithScheme(scheme).WithObjects(feature).Build())
+	defer SetK8sClient(nil)
+	entity.ConfigMapData.FromMap(map[string]string{"operatorDelay": "0", "ingressOpen": "true"})
+	defer entity.ConfigMapData.FromMap(map[string]string{"operatorDelay": "0"})
+	ctx := context.Background()
+	app := &qav1alpha1.SQBApplication{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo"}}
+	app.Spec.Domains = []qav1alpha1.Domain{{Class: "nginx", Host: "demo.iwosai.com"}, {Class: "alb", Host: "demo.iwosai.com"}}
+	app.Spec.Ports = []corev1.ServicePort{{Name: "http-80", Port: 80, TargetPort: intstr.FromInt(8080)}}
+
+	assert.Nil(t, NewCanaryIngressHandler(app, ctx).Handle())
+	service := &corev1.Service{}
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "demo-feature"}, service))
+	assert.Equal(t, map[string]string{entity.AppKey: "demo", entity.PlaneKey: "feature"}, service.Spec.Selector)
+	ingress := &v1.Ingress{}
+	key := client.ObjectKey{Namespace: "default", Name: getCanaryIngressName("demo", "nginx", "demo.iwosai.com", "feature")}
+	assert.Nil(t, k8sclient.Get(ctx, key, ingress))
+	assert.Equal(t, "true", ingress.Annotations[canaryAnnotationKey])
+	assert.Equal(t, entity.XEnvFlag, ingress.Annotations[canaryByHeaderAnnotationKey])
+	assert.Equal(t, "feature", ingress.Annotations[canaryByHeaderValueAnnotationKey])
+	backend := ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service
+	assert.Equal(t, "demo-feature", backend.Name)
+	assert.Equal(t, int32(8080), backend.Port.Number)
+	// 不是ingress-nginx的class不生成canary ingress
+	ingressList := &v1.IngressList{}
+	assert.Nil(t, k8sclient.List(ctx, ingressList))
+	assert.Equal(t, 1, len(ingressList.Items))
+
+	// 开启istio后由VirtualService路由，删除环境的service和canary ingress
+	entity.ConfigMapData.FromMap(map[string]string{"operatorDelay": "0", "istioEnable": "true", "istioInject": "true"})
+	assert.Nil(t, NewCanaryIngressHandler(app, ctx).Handle())
+	assert.True(t, apierrors.IsNotFound(k8sclient.Get(ctx, key, ingress)))
+	err := k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "demo-feature"}, service)
+	assert.True(t, apierrors.IsNotFound(err))
+
+	// gateway模式下集群没有istio-ingressgateway时，HTTPRoute转发到所有环境的service
+	entity.ConfigMapData.FromMap(map[string]string{"operatorDelay": "0", "istioEnable": "true", "istioInject": "true",
+		"istioIngressGateway": "false", "ingressMode": "gateway"})
+	assert.Nil(t, NewCanaryIngressHandler(app, ctx).Handle())
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "demo-feature"}, service))
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "demo-base"}, service))
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"errors"
+
+	appv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+)
+
+// reconcileError 记录调和失败的步骤，作为condition的reason
+type reconcileError struct {
+	reason string
+	err    error
+}
+
+func (e *reconcileError) Error() string {
+	return e.err.Error()
+}
+
+func (e *reconcileError) Unwrap() error {
+	return e.err
+}
+
+// handleAll 依次执行handler，失败时记录是哪个handler失败
+func handleAll(handlers []SQBHandler) error {
+	for _, handler := range handlers {
+		if err := handler.Handle(); err != nil {
+			return &reconcileError{reason: handler.Name() + "Failed", err: err}
+		}
+	}
+	return nil
+}
+
+func reasonOf(err error) string {
+	var rerr *reconcileError
+	if errors.As(err, &rerr) {
+		return rerr.reason
+	}
+	return qav1alpha1.ReasonReconcileFailed
+}
+
+func setCondition(conditions *[]metav1.Condition, conditionType string, status metav1.ConditionStatus,
+	reason, message string, generation int64) {
+	meta.SetStatusCondition(conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: generation,
+	})
+}
+
+// markReconcileSuccess 调和成功，Ready由各资源自己决定
+func markReconcileSuccess(conditions *[]metav1.Condition, generation int64) {
+	setCondition(conditions, qav1alpha1.ConditionReconciled, metav1.ConditionTrue,
+		qav1alpha1.ReasonReconcileSuccess, "", generation)
+	setCondition(conditions, qav1alpha1.ConditionDegraded, metav1.ConditionFalse,
+		qav1alpha1.ReasonReconcileSuccess, "", generation)
+}
+
+// markReconcileFail 调和失败，reason为失败的步骤，如IngressFailed、PVCFailed、DeploymentFailed
+func markReconcileFail(conditions *[]metav1.Condition, generation int64, err error) {
+	reason := reasonOf(err)
+	setCondition(conditions, qav1alpha1.ConditionReconciled, metav1.ConditionFalse, reason, err.Error(), generation)
+	setCondition(conditions, qav1alpha1.ConditionDegraded, metav1.ConditionTrue, reason, err.Error(), generation)
+	setCondition(conditions, qav1alpha1.ConditionReady, metav1.ConditionFalse, reason, err.Error(), generation)
+}
+
+func markDeleting(conditions *[]metav1.Condition, generation int64) {
+	setCondition(conditions, qav1alpha1.ConditionDeleting, metav1.ConditionTrue,
+		qav1alpha1.ReasonExplicitDelete, "delete annotation is set", generation)
+	setCondition(conditions, qav1alpha1.ConditionReady, metav1.ConditionFalse,
+		qav1alpha1.ReasonExplicitDelete, "delete annotation is set", generation)
+}
+
+func markReady(conditions *[]metav1.Condition, generation int64) {
+	setCondition(conditions, qav1alpha1.ConditionReady, metav1.ConditionTrue,
+		qav1alpha1.ReasonReconcileSuccess, "", generation)
+}
+
+// markDeploymentReady 根据deployment的滚动更新状态设置Ready，判断逻辑与kubectl rollout status一致
+func markDeploymentReady(conditions *[]metav1.Condition, generation int64, deployment *appv1.Deployment) {
+	ready, message := deploymentRolledOut(deployment)
+	if ready {
+		setCondition(conditions, qav1alpha1.ConditionReady, metav1.ConditionTrue,
+			qav1alpha1.ReasonDeploymentAvailable, message, generation)
+	} else {
+		setCondition(conditions, qav1alpha1.ConditionReady, metav1.ConditionFalse,
+			qav1alpha1.ReasonDeploymentProgressing, message, generation)
+	}
+}
+
+func deploymentRolledOut(deployment *appv1.Deployment) (bool, string) {
+	if deployment.Generation > deployment.Status.ObservedGeneration {
+		return false, "waiting for deployment spec update to be observed"
+	}
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	status := deployment.Status
+	if status.UpdatedReplicas < replicas {
+		return false, "waiting for new replicas to be updated"
+	}
+	if status.Replicas > status.UpdatedReplicas {
+		return false, "waiting for old replicas to be terminated"
+	}
+	if status.AvailableReplicas < status.UpdatedReplicas {
+		return false, "waiting for updated replicas to be available"
+	}
+	return true, "deployment successfully rolled out"
+}
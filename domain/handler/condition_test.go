@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	appv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+)
+
+type failHandler struct{}
+
+func (h *failHandler) Handle() error {
+	return errors.New("pvc is invalid")
+}
+
+func (h *failHandler) Name() string {
+	return "PVC"
+}
+
+func TestReconcileFailCondition(t *testing.T) {
+	conditions := make([]metav1.Condition, 0)
+	err := handleAll([]SQBHandler{&failHandler{}})
+	assert.EqualError(t, err, "pvc is invalid")
+
+	markReconcileFail(&conditions, 2, err)
+	degraded := meta.FindStatusCondition(conditions, qav1alpha1.ConditionDegraded)
+	assert.Equal(t, metav1.ConditionTrue, degraded.Status)
+	assert.Equal(t, "PVCFailed", degraded.Reason)
+	assert.Equal(t, "pvc is invalid", degraded.Message)
+	assert.Equal(t, int64(2), degraded.ObservedGeneration)
+	assert.True(t, meta.IsStatusConditionFalse(conditions, qav1alpha1.ConditionReady))
+
+	markReconcileSuccess(&conditions, 3)
+	assert.True(t, meta.IsStatusConditionTrue(conditions, qav1alpha1.ConditionReconciled))
+	assert.True(t, meta.IsStatusConditionFalse(conditions, qav1alpha1.ConditionDegraded))
+	assert.Equal(t, qav1alpha1.ReasonReconcileFailed, reasonOf(errors.New("other")))
+}
+
+func TestDeploymentReadyCondition(t *testing.T) {
+	conditions := make([]metav1.Condition, 0)
+	deployment := &appv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Generation: 2},
+		Spec:       appv1.DeploymentSpec{Replicas: proto.Int32(2)},
+		Status: appv1.DeploymentStatus{
+			ObservedGeneration: 2,
+			Replicas:           3,
+			UpdatedReplicas:    2,
+			AvailableReplicas:  2,
+		},
+	}
+	markDeploymentReady(&conditions, 1, deployment)
+	ready := meta.FindStatusCondition(conditions, qav1alpha1.ConditionReady)
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, qav1alpha1.ReasonDeploymentProgressing, ready.Reason)
+
+	deployment.Status.Replicas = 2
+	markDeploymentReady(&conditions, 1, deployment)
+	ready = meta.FindStatusCondition(conditions, qav1alpha1.ConditionReady)
+	assert.Equal(t, metav1.ConditionTrue, ready.Status)
+	assert.Equal(t, qav1alpha1.ReasonDeploymentAvailable, ready.Reason)
+}
@@ -2,9 +2,9 @@ package handler
 
 import (
 	"context"
+	"crypto/md5"
 	"encoding/json"
 	"fmt"
-	"math"
 	"strings"
 	"time"
 
@@ -41,11 +41,36 @@ func NewDeploymentHandlerWithReq(req ctrl.Request, ctx context.Context) SQBRecon
 }
 
 func (h *deploymentHandler) CreateOrUpdate() error {
-	deployment := &appv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: h.sqbdeployment.Namespace, Name: h.sqbdeployment.Name}}
+	if h.sqbdeployment.Spec.Strategy == qav1alpha1.StrategyBlueGreen {
+		return h.blueGreen()
+	}
+	deployment, err := h.getDeployment(h.sqbdeployment.Name)
+	if err != nil {
+		return err
+	}
+	if err = h.build(deployment, ""); err != nil {
+		return err
+	}
+	if err = CreateOrUpdate(h.ctx, deployment); err != nil {
+		return err
+	}
+	markDeploymentReady(&h.sqbdeployment.Status.Conditions, h.sqbdeployment.Generation, deployment)
+	return h.leaveBlueGreen(deployment)
+}
+
+func (h *deploymentHandler) getDeployment(name string) (*appv1.Deployment, error) {
+	deployment := &appv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: h.sqbdeployment.Namespace, Name: name}}
 	err := k8sclient.Get(h.ctx, client.ObjectKey{Namespace: deployment.Namespace, Name: deployment.Name}, deployment)
 	if err != nil && !apierrors.IsNotFound(err) {
-		return err
+		return nil, err
 	}
+	return deployment, nil
+}
+
+// build 按生效的deploy配置设置deployment，color为蓝绿发布中deployment的颜色，滚动更新时为空
+func (h *deploymentHandler) build(deployment *appv1.Deployment, color string) error {
+	// 上一次调和时operator管理的sidecar，更新annotation之前记录下来
+	previousSidecars := strings.Split(deployment.Annotations[entity.SidecarsAnnotationKey], ",")
 
 	sqbapplication := &qav1alpha1.SQBApplication{}
 	if err := k8sclient.Get(h.ctx, client.ObjectKey{Namespace: h.sqbdeployment.Namespace, Name: h.sqbdeployment.Spec.Selector.App},
@@ -72,15 +97,14 @@ func (h *deploymentHandler) CreateOrUpdate() error {
 		}
 	}
 	container := corev1.Container{
-		Name:           h.sqbdeployment.Name,
-		Image:          deploy.Image,
-		Env:            deploy.Env,
-		VolumeMounts:   containerMounts,
-		LivenessProbe:  deploy.HealthCheck,
-		ReadinessProbe: deploy.HealthCheck,
-		Command:        deploy.Command,
-		Args:           deploy.Args,
-		Ports:          containerPorts,
+		Name:         h.sqbdeployment.Name,
+		Image:        deploy.Image,
+		Env:          deploy.Env,
+		EnvFrom:      deploy.EnvFrom,
+		VolumeMounts: containerMounts,
+		Command:      deploy.Command,
+		Args:         deploy.Args,
+		Ports:        containerPorts,
 	}
 	if deploy.Resources != nil {
 		container.Resources = corev1.ResourceRequirements{
@@ -88,6 +112,8 @@ func (h *deploymentHandler) CreateOrUpdate() error {
 			Limits:   deploy.Resources.Limits,
 		}
 	}
+	podSecurityContext, securityContext := resolveSecurityContext(deploy)
+	container.SecurityContext = securityContext
 	if deploy.Lifecycle != nil {
 		var lifecycle corev1.Lifecycle
 		if poststart := deploy.Lifecycle.PostStart; poststart != nil {
@@ -121,6 +147,10 @@ func (h *deploymentHandler) CreateOrUpdate() error {
 				entity.AppKey: h.sqbdeployment.Spec.Selector.App,
 			},
 		}
+		// green的deployment与同名的deployment同时存在，按颜色选择自己的pod
+		if color == qav1alpha1.ColorGreen {
+			deployment.Spec.Selector.MatchLabels[entity.ColorKey] = color
+		}
 	}
 	// FIX: only merge simple labels
 	deployment.Spec.Template.ObjectMeta.Labels = util.MergeLabelsWithFilter(deployment.Labels,
@@ -133,11 +163,20 @@ func (h *deploymentHandler) CreateOrUpdate() error {
 			}
 			return true
 		})
+	if color != "" {
+		deployment.Spec.Template.ObjectMeta.Labels[entity.ColorKey] = color
+	}
 	deployment.Spec.Template.Spec.Volumes = volumes
+	deployment.Spec.Template.Spec.SecurityContext = podSecurityContext
 	deployment.Spec.Template.Spec.HostAliases = deploy.HostAlias
-	containers := []corev1.Container{container}
+	sidecars, err := h.buildSidecars(deploy.Sidecars, volumeMounts, securityContext)
+	if err != nil {
+		return err
+	}
+	containers := append([]corev1.Container{container}, sidecars...)
 
-	deployment.Spec.Template.Spec.Containers = containers
+	deployment.Spec.Template.Spec.Containers = mergeContainers(deployment.Spec.Template.Spec.Containers,
+		containers, previousSidecars)
 	deployment.Spec.Template.Spec.ImagePullSecrets = entity.ConfigMapData.GetImagePullSecrets()
 
 	if anno, ok := h.sqbdeployment.Annotations[entity.PodAnnotationKey]; ok {
@@ -148,7 +187,7 @@ func (h *deploymentHandler) CreateOrUpdate() error {
 	}
 
 	deployment.Spec.Template.Annotations = util.MergeStringMap(deployment.Spec.Template.Annotations,
-		map[string]string{entity.IstioSidecarInjectKey: h.sqbdeployment.Annotations[entity.IstioInjectAnnotationKey]})
+		getMeshProvider().InjectAnnotations(h.sqbdeployment.Annotations[entity.IstioInjectAnnotationKey] == "true"))
 
 	if anno, ok := h.sqbdeployment.Annotations[entity.DeploymentAnnotationKey]; ok {
 		deployment.Annotations = make(map[string]string)
@@ -156,38 +195,38 @@ func (h *deploymentHandler) CreateOrUpdate() error {
 	} else {
 		deployment.Annotations = nil
 	}
+	if len(sidecars) != 0 {
+		names := make([]string, len(sidecars))
+		for i, sidecar := range sidecars {
+			names[i] = sidecar.Name
+		}
+		deployment.Annotations = util.MergeStringMap(deployment.Annotations,
+			map[string]string{entity.SidecarsAnnotationKey: strings.Join(names, ",")})
+	}
 	// 去掉jaeger注解和label
 	delete(deployment.Annotations, "sidecar.jaegertracing.io/inject")
 	delete(deployment.Labels, "sidecar.jaegertracing.io/injected")
 	delete(deployment.Spec.Template.Labels, "sidecar.jaegertracing.io/injected")
-	// init lifecycle
-	image := entity.ConfigMapData.InitContainerImage()
-	if (deploy.Lifecycle != nil && deploy.Lifecycle.Init != nil) || image != "" {
-		if image == "" {
-			image = "busybox:1.32"
-		}
-		initContainer := corev1.Container{
-			Name:            "init0",
-			Image:           image,
-			Env:             deploy.Env,
-			VolumeMounts:    volumeMounts,
-			ImagePullPolicy: corev1.PullIfNotPresent,
-		}
-		if deploy.Lifecycle != nil && deploy.Lifecycle.Init != nil {
-			initContainer.Command = deploy.Lifecycle.Init.Exec.Command
-		}
-		deployment.Spec.Template.Spec.InitContainers = []corev1.Container{initContainer}
+	initContainers, err := h.buildInitContainers(deploy, volumeMounts)
+	if err != nil {
+		return err
 	}
+	deployment.Spec.Template.Spec.InitContainers = initContainers
 	h.addNodeAffinity(deployment)
-	h.addStartupProbe(deployment)
+	h.addProbes(deployment)
 	h.addPodAntiAffinity(deployment)
+	h.addScheduling(deployment)
 	h.addVaultConfig(deployment)
 	h.configRollingUpdate(deployment)
 	controllerutil.AddFinalizer(deployment, entity.FINALIZER)
 	if err = h.additionalSpec(deployment); err != nil {
 		return err
 	}
-	return CreateOrUpdate(h.ctx, deployment)
+	if color != "" {
+		deployment.Annotations = util.MergeStringMap(deployment.Annotations,
+			map[string]string{entity.TemplateHashAnnotationKey: getTemplateHash(&deployment.Spec.Template)})
+	}
+	return nil
 }
 
 func (h *deploymentHandler) additionalSpec(deployment *appv1.Deployment) error {
@@ -210,90 +249,270 @@ func (h *deploymentHandler) merge(deployment *appv1.Deployment, specString strin
 	return nil
 }
 
+// getVolumeAndVolumeMounts volume的名字由来源的内容生成，configMap、secret、hostPath、pvc相同来源的多个挂载共用一个volume
 func (h *deploymentHandler) getVolumeAndVolumeMounts(volumemap []*qav1alpha1.VolumeSpec) (volumes []corev1.Volume, volumeMounts []corev1.VolumeMount) {
-	for i, volumeSpec := range volumemap {
-		volumeName := fmt.Sprintf("volume-%d", i)
+	exists := make(map[string]struct{})
+	for _, volumeSpec := range volumemap {
+		source := getVolumeSource(volumeSpec)
+		if source == nil {
+			continue
+		}
+		volumeName := getVolumeName(source, volumeSpec.MountPath)
 		volumeMounts = append(volumeMounts, corev1.VolumeMount{
 			Name:      volumeName,
 			MountPath: volumeSpec.MountPath,
+			SubPath:   volumeSpec.SubPath,
+			ReadOnly:  volumeSpec.ReadOnly,
 		})
-		if volumeSpec.EmptyDir {
-			volumes = append(volumes, corev1.Volume{
-				Name: volumeName,
-				VolumeSource: corev1.VolumeSource{
-					EmptyDir: &corev1.EmptyDirVolumeSource{},
-				},
-			})
+		if _, ok := exists[volumeName]; ok {
 			continue
 		}
-		if volumeSpec.HostPath != "" {
-			volumes = append(volumes, corev1.Volume{
-				Name: volumeName,
-				VolumeSource: corev1.VolumeSource{
-					HostPath: &corev1.HostPathVolumeSource{
-						Path: volumeSpec.HostPath,
-					},
-				},
-			})
-			continue
+		exists[volumeName] = struct{}{}
+		volumes = append(volumes, corev1.Volume{
+			Name:         volumeName,
+			VolumeSource: *source,
+		})
+	}
+	return
+}
+
+// getVolumeSource 没有声明来源的volume返回nil
+func getVolumeSource(volumeSpec *qav1alpha1.VolumeSpec) *corev1.VolumeSource {
+	switch {
+	case volumeSpec.EmptyDir:
+		return &corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{
+				Medium:    volumeSpec.EmptyDirMedium,
+				SizeLimit: volumeSpec.EmptyDirSizeLimit,
+			},
 		}
-		if volumeSpec.ConfigMap != "" {
-			volumes = append(volumes, corev1.Volume{
-				Name: volumeName,
-				VolumeSource: corev1.VolumeSource{
-					ConfigMap: &corev1.ConfigMapVolumeSource{
-						LocalObjectReference: corev1.LocalObjectReference{
-							Name: volumeSpec.ConfigMap,
-						},
-					},
-				},
-			})
-			continue
+	case volumeSpec.HostPath != "":
+		return &corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{
+				Path: volumeSpec.HostPath,
+			},
 		}
-		if volumeSpec.Secret != "" {
-			volumes = append(volumes, corev1.Volume{
-				Name: volumeName,
-				VolumeSource: corev1.VolumeSource{
-					Secret: &corev1.SecretVolumeSource{
-						SecretName: volumeSpec.Secret,
-					},
+	case volumeSpec.ConfigMap != "":
+		return &corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: volumeSpec.ConfigMap,
 				},
-			})
-			continue
+				Items: volumeSpec.Items,
+			},
 		}
-		if volumeSpec.PersistentVolumeClaimName != "" {
-			volumes = append(volumes, corev1.Volume{
-				Name: volumeName,
-				VolumeSource: corev1.VolumeSource{
-					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-						ClaimName: volumeSpec.PersistentVolumeClaimName,
-					},
-				},
-			})
-			continue
+	case volumeSpec.Secret != "":
+		return &corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: volumeSpec.Secret,
+				Items:      volumeSpec.Items,
+			},
 		}
-		if volumeSpec.DownwardAPI != nil {
-			items := make([]corev1.DownwardAPIVolumeFile, 0)
-			for _, item := range volumeSpec.DownwardAPI {
-				items = append(items, corev1.DownwardAPIVolumeFile{
-					Path: item.FileName,
-					FieldRef: &corev1.ObjectFieldSelector{
-						FieldPath: item.FieldPath,
-					},
-				})
-			}
-			volumes = append(volumes, corev1.Volume{
-				Name: volumeName,
-				VolumeSource: corev1.VolumeSource{
-					DownwardAPI: &corev1.DownwardAPIVolumeSource{
-						Items: items,
-					},
+	case volumeSpec.PersistentVolumeClaimName != "":
+		return &corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: volumeSpec.PersistentVolumeClaimName,
+			},
+		}
+	case volumeSpec.DownwardAPI != nil:
+		items := make([]corev1.DownwardAPIVolumeFile, 0)
+		for _, item := range volumeSpec.DownwardAPI {
+			items = append(items, corev1.DownwardAPIVolumeFile{
+				Path: item.FileName,
+				FieldRef: &corev1.ObjectFieldSelector{
+					FieldPath: item.FieldPath,
 				},
 			})
-			continue
+		}
+		return &corev1.VolumeSource{
+			DownwardAPI: &corev1.DownwardAPIVolumeSource{
+				Items: items,
+			},
+		}
+	case volumeSpec.Projected != nil:
+		return &corev1.VolumeSource{
+			Projected: volumeSpec.Projected.DeepCopy(),
+		}
+	case volumeSpec.CSI != nil:
+		return &corev1.VolumeSource{
+			CSI: volumeSpec.CSI.DeepCopy(),
 		}
 	}
-	return
+	return nil
+}
+
+// getVolumeName 根据来源的内容生成volume的名字，调整volumes的顺序不会改变名字。
+// emptyDir、downwardAPI、projected、csi每次挂载都是独立的存储，名字还包含mountPath，相同来源不会合并
+func getVolumeName(source *corev1.VolumeSource, mountPath string) string {
+	data, _ := json.Marshal(source)
+	if source.EmptyDir != nil || source.DownwardAPI != nil || source.Projected != nil || source.CSI != nil {
+		data = append(data, mountPath...)
+	}
+	return fmt.Sprintf("volume-%x", md5.Sum(data))[:len("volume-")+12]
+}
+
+// resolveSecurityContext 应用没有配置时使用operator配置中默认的security profile
+func resolveSecurityContext(deploy qav1alpha1.DeploySpec) (*corev1.PodSecurityContext, *corev1.SecurityContext) {
+	profile := entity.ConfigMapData.SecurityProfile()
+	podSecurityContext := profile.PodSecurityContext
+	if deploy.PodSecurityContext != nil {
+		podSecurityContext = deploy.PodSecurityContext.DeepCopy()
+	}
+	securityContext := profile.SecurityContext
+	if deploy.SecurityContext != nil {
+		securityContext = deploy.SecurityContext.DeepCopy()
+	}
+	return podSecurityContext, securityContext
+}
+
+// initContainerImage 初始化容器的默认镜像，优先使用sqbdeployment的注解，其次是operator配置
+func (h *deploymentHandler) initContainerImage() string {
+	if image := h.sqbdeployment.Annotations[entity.InitContainerAnnotationKey]; image != "" {
+		return image
+	}
+	return entity.ConfigMapData.InitContainerImage()
+}
+
+// buildInitContainers lifecycle.init或者配置了默认镜像时生成init0，之后是initContainers中按顺序声明的容器
+func (h *deploymentHandler) buildInitContainers(deploy qav1alpha1.DeploySpec,
+	volumeMounts []corev1.VolumeMount) ([]corev1.Container, error) {
+	var containers []corev1.Container
+	_, securityContext := resolveSecurityContext(deploy)
+	image := h.initContainerImage()
+	defaultImage := image
+	if defaultImage == "" {
+		defaultImage = "busybox:1.32"
+	}
+	if (deploy.Lifecycle != nil && deploy.Lifecycle.Init != nil) || image != "" {
+		initContainer := corev1.Container{
+			Name:            "init0",
+			Image:           defaultImage,
+			Env:             deploy.Env,
+			EnvFrom:         deploy.EnvFrom,
+			VolumeMounts:    volumeMounts,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			SecurityContext: securityContext,
+		}
+		if deploy.Lifecycle != nil && deploy.Lifecycle.Init != nil {
+			initContainer.Command = deploy.Lifecycle.Init.Exec.Command
+		}
+		containers = append(containers, initContainer)
+	}
+	for _, spec := range deploy.InitContainers {
+		mounts, err := resolveVolumeMounts(spec.Name, spec.VolumeMounts, volumeMounts)
+		if err != nil {
+			return nil, err
+		}
+		initContainer := corev1.Container{
+			Name:            spec.Name,
+			Image:           spec.Image,
+			Command:         spec.Command,
+			Args:            spec.Args,
+			Env:             spec.Env,
+			VolumeMounts:    mounts,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			SecurityContext: spec.SecurityContext,
+		}
+		if initContainer.Image == "" {
+			initContainer.Image = defaultImage
+		}
+		if initContainer.SecurityContext == nil {
+			initContainer.SecurityContext = securityContext.DeepCopy()
+		}
+		if spec.Resources != nil {
+			initContainer.Resources = *spec.Resources
+		}
+		containers = append(containers, initContainer)
+	}
+	return containers, nil
+}
+
+// buildSidecars 生成sidecar容器
+// 没有配置securityContext的sidecar使用业务容器的securityContext
+func (h *deploymentHandler) buildSidecars(sidecars []qav1alpha1.SidecarSpec,
+	volumeMounts []corev1.VolumeMount, securityContext *corev1.SecurityContext) ([]corev1.Container, error) {
+	containers := make([]corev1.Container, 0, len(sidecars))
+	for _, sidecar := range sidecars {
+		mounts, err := resolveVolumeMounts(sidecar.Name, sidecar.VolumeMounts, volumeMounts)
+		if err != nil {
+			return nil, err
+		}
+		container := corev1.Container{
+			Name:            sidecar.Name,
+			Image:           sidecar.Image,
+			Command:         sidecar.Command,
+			Args:            sidecar.Args,
+			Env:             sidecar.Env,
+			Ports:           sidecar.Ports,
+			LivenessProbe:   sidecar.LivenessProbe,
+			ReadinessProbe:  sidecar.ReadinessProbe,
+			StartupProbe:    sidecar.StartupProbe,
+			VolumeMounts:    mounts,
+			SecurityContext: sidecar.SecurityContext,
+		}
+		if sidecar.Resources != nil {
+			container.Resources = *sidecar.Resources
+		}
+		if container.SecurityContext == nil {
+			container.SecurityContext = securityContext.DeepCopy()
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
+// resolveVolumeMounts 容器通过mountPath引用volumes中声明的volume
+func resolveVolumeMounts(container string, mounts []qav1alpha1.ContainerVolumeMount,
+	volumeMounts []corev1.VolumeMount) ([]corev1.VolumeMount, error) {
+	volumeNames := make(map[string]string)
+	for _, mount := range volumeMounts {
+		volumeNames[mount.MountPath] = mount.Name
+	}
+	var result []corev1.VolumeMount
+	for _, mount := range mounts {
+		name, ok := volumeNames[mount.Volume]
+		if !ok {
+			return nil, fmt.Errorf("volume %s of container %s is not declared in volumes", mount.Volume, container)
+		}
+		mountPath := mount.MountPath
+		if mountPath == "" {
+			mountPath = mount.Volume
+		}
+		result = append(result, corev1.VolumeMount{
+			Name:      name,
+			MountPath: mountPath,
+			SubPath:   mount.SubPath,
+			ReadOnly:  mount.ReadOnly,
+		})
+	}
+	return result, nil
+}
+
+// mergeContainers 业务容器和sidecar由operator管理，其他webhook注入到deployment中的容器保留
+func mergeContainers(current, managed []corev1.Container, previous []string) []corev1.Container {
+	owned := make(map[string]struct{})
+	for _, name := range previous {
+		owned[name] = struct{}{}
+	}
+	for _, container := range managed {
+		owned[container.Name] = struct{}{}
+	}
+	containers := managed
+	for _, container := range current {
+		if _, ok := owned[container.Name]; !ok {
+			containers = append(containers, container)
+		}
+	}
+	return containers
+}
+
+func (h *deploymentHandler) mainContainer(deployment *appv1.Deployment) *corev1.Container {
+	for i := range deployment.Spec.Template.Spec.Containers {
+		if deployment.Spec.Template.Spec.Containers[i].Name == h.sqbdeployment.Name {
+			return &deployment.Spec.Template.Spec.Containers[i]
+		}
+	}
+	return nil
 }
 
 func (h *deploymentHandler) configRollingUpdate(deployment *appv1.Deployment) {
@@ -372,64 +591,133 @@ func (h *deploymentHandler) addNodeAffinity(deployment *appv1.Deployment) {
 	}
 }
 
-func (h *deploymentHandler) addStartupProbe(deployment *appv1.Deployment) {
+// addProbes 优先使用单独配置的livenessProbe、readinessProbe、startupProbe，没有配置的由healthCheck生成
+func (h *deploymentHandler) addProbes(deployment *appv1.Deployment) {
 	deploy := h.sqbdeployment.Spec.DeploySpec
-	if deploy.HealthCheck != nil {
-		initialDelaySeconds := int32(math.Max(float64(deploy.HealthCheck.InitialDelaySeconds), 180))
-		startupProbe := &corev1.Probe{
+	container := h.mainContainer(deployment)
+	container.LivenessProbe = deploy.LivenessProbe
+	container.ReadinessProbe = deploy.ReadinessProbe
+	container.StartupProbe = deploy.StartupProbe
+	if deploy.HealthCheck == nil {
+		return
+	}
+	// healthCheck的initialDelaySeconds用于startupProbe，启动完成之后liveness和readiness只需要很短的延迟
+	healthCheck := deploy.HealthCheck.DeepCopy()
+	healthCheck.InitialDelaySeconds = 10
+	if container.LivenessProbe == nil {
+		container.LivenessProbe = healthCheck
+	}
+	if container.ReadinessProbe == nil {
+		container.ReadinessProbe = healthCheck
+	}
+	if container.StartupProbe == nil {
+		startupTimeout := entity.ConfigMapData.StartupTimeoutSeconds()
+		if deploy.StartupTimeoutSeconds != nil {
+			startupTimeout = *deploy.StartupTimeoutSeconds
+		}
+		if deploy.HealthCheck.InitialDelaySeconds > startupTimeout {
+			startupTimeout = deploy.HealthCheck.InitialDelaySeconds
+		}
+		periodSeconds := int32(10)
+		container.StartupProbe = &corev1.Probe{
 			InitialDelaySeconds: deploy.HealthCheck.InitialDelaySeconds,
-			PeriodSeconds:       10,
+			PeriodSeconds:       periodSeconds,
 			SuccessThreshold:    1,
-			FailureThreshold:    initialDelaySeconds / 10,
+			FailureThreshold:    (startupTimeout + periodSeconds - 1) / periodSeconds,
 			TimeoutSeconds:      deploy.HealthCheck.TimeoutSeconds,
 			ProbeHandler:        deploy.HealthCheck.ProbeHandler,
 		}
-		deploy.HealthCheck.InitialDelaySeconds = 10
-		deployment.Spec.Template.Spec.Containers[0].LivenessProbe = deploy.HealthCheck
-		deployment.Spec.Template.Spec.Containers[0].ReadinessProbe = deploy.HealthCheck
-		deployment.Spec.Template.Spec.Containers[0].StartupProbe = startupProbe
-	} else {
-		deployment.Spec.Template.Spec.Containers[0].LivenessProbe = nil
-		deployment.Spec.Template.Spec.Containers[0].ReadinessProbe = nil
-		deployment.Spec.Template.Spec.Containers[0].StartupProbe = nil
 	}
 }
 
+// addScheduling tolerations、nodeSelector、topologySpreadConstraints，没有配置时使用operator配置中的默认值
+func (h *deploymentHandler) addScheduling(deployment *appv1.Deployment) {
+	deploy := h.sqbdeployment.Spec.DeploySpec
+	podSpec := &deployment.Spec.Template.Spec
+	podSpec.Tolerations = deploy.Tolerations
+	if len(podSpec.Tolerations) == 0 {
+		podSpec.Tolerations = entity.ConfigMapData.Tolerations()
+	}
+	podSpec.NodeSelector = deploy.NodeSelector
+	if len(podSpec.NodeSelector) == 0 {
+		podSpec.NodeSelector = entity.ConfigMapData.NodeSelector()
+	}
+	constraints := deploy.TopologySpreadConstraints
+	if len(constraints) == 0 {
+		constraints = entity.ConfigMapData.TopologySpreadConstraints()
+	}
+	podSpec.TopologySpreadConstraints = nil
+	for _, constraint := range constraints {
+		// 没有指定labelSelector时，在同一个环境的pod之间打散
+		if constraint.LabelSelector == nil {
+			constraint.LabelSelector = &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					entity.AppKey:   h.sqbdeployment.Spec.Selector.App,
+					entity.PlaneKey: h.sqbdeployment.Spec.Selector.Plane,
+				},
+			}
+		}
+		podSpec.TopologySpreadConstraints = append(podSpec.TopologySpreadConstraints, constraint)
+	}
+}
+
+// addPodAntiAffinity 默认按app在hostname上preferred反亲和，可以关闭或者替换
 func (h *deploymentHandler) addPodAntiAffinity(deployment *appv1.Deployment) {
-	podAntiAffinity := &corev1.PodAntiAffinity{
-		PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
-			{
-				Weight: 100,
-				PodAffinityTerm: corev1.PodAffinityTerm{
-					LabelSelector: &metav1.LabelSelector{
-						MatchExpressions: []metav1.LabelSelectorRequirement{
-							{
-								Key:      entity.AppKey,
-								Operator: metav1.LabelSelectorOpIn,
-								Values:   []string{deployment.Spec.Template.Labels[entity.AppKey]},
+	spec := h.sqbdeployment.Spec.PodAntiAffinity
+	if spec == nil {
+		spec = entity.ConfigMapData.PodAntiAffinity()
+	}
+	var podAntiAffinity *corev1.PodAntiAffinity
+	switch {
+	case spec != nil && spec.Disabled:
+	case spec != nil && spec.Custom != nil:
+		podAntiAffinity = spec.Custom.DeepCopy()
+	default:
+		podAntiAffinity = &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+				{
+					Weight: 100,
+					PodAffinityTerm: corev1.PodAffinityTerm{
+						LabelSelector: &metav1.LabelSelector{
+							MatchExpressions: []metav1.LabelSelectorRequirement{
+								{
+									Key:      entity.AppKey,
+									Operator: metav1.LabelSelectorOpIn,
+									Values:   []string{deployment.Spec.Template.Labels[entity.AppKey]},
+								},
 							},
 						},
+						TopologyKey: "kubernetes.io/hostname",
 					},
-					TopologyKey: "kubernetes.io/hostname",
 				},
 			},
-		},
+		}
 	}
 
-	if deployment.Spec.Template.Spec.Affinity != nil {
-		if deployment.Spec.Template.Spec.Affinity.PodAntiAffinity == nil {
-			deployment.Spec.Template.Spec.Affinity.PodAntiAffinity = podAntiAffinity
-		}
-	} else {
-		deployment.Spec.Template.Spec.Affinity = &corev1.Affinity{
-			PodAntiAffinity: podAntiAffinity,
+	affinity := deployment.Spec.Template.Spec.Affinity
+	if affinity == nil {
+		if podAntiAffinity == nil {
+			return
 		}
+		affinity = &corev1.Affinity{}
+		deployment.Spec.Template.Spec.Affinity = affinity
 	}
+	affinity.PodAntiAffinity = podAntiAffinity
 }
 
+// Delete 同时删除蓝绿发布中green的deployment
 func (h *deploymentHandler) Delete() error {
-	deployment := &appv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: h.sqbdeployment.Namespace, Name: h.sqbdeployment.Name}}
-	return Delete(h.ctx, deployment)
+	for _, name := range []string{h.sqbdeployment.Name, getColorDeploymentName(h.sqbdeployment.Name, qav1alpha1.ColorGreen)} {
+		deployment := &appv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: h.sqbdeployment.Namespace, Name: name}}
+		if err := Delete(h.ctx, deployment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *deploymentHandler) Name() string {
+	return "Deployment"
 }
 
 func (h *deploymentHandler) Handle() error {
@@ -485,7 +773,6 @@ func (h *deploymentHandler) Operate(obj runtimeObj) error {
 			if sqbapplication.DeletionTimestamp.IsZero() {
 				sqbapplication.Status.Planes = planes
 				sqbapplication.Status.Mirrors = mirrors
-				sqbapplication.Status.ErrorInfo = ""
 				if err = UpdateStatus(h.ctx, sqbapplication); err != nil {
 					return err
 				}
@@ -517,7 +804,6 @@ func (h *deploymentHandler) Operate(obj runtimeObj) error {
 		} else {
 			if sqbplane.DeletionTimestamp.IsZero() {
 				sqbplane.Status.Mirrors = mirrors
-				sqbplane.Status.ErrorInfo = ""
 				if err = UpdateStatus(h.ctx, sqbplane); err != nil {
 					return err
 				}
@@ -1,10 +1,14 @@
 package handler
 
 import (
+	"github.com/gogo/protobuf/proto"
 	"github.com/stretchr/testify/assert"
 	appv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	"testing"
+
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
 )
 
 var deployment_handler = &deploymentHandler{}
@@ -38,3 +42,189 @@ func TestMerge(t *testing.T) {
 	assert.Equal(t, src.Spec.Template.Spec.DNSConfig.Nameservers, []string{"1.1.1.1", "2.2.2.2"})
 	assert.Equal(t, src.Spec.Template.Spec.DNSConfig.Searches, []string{"a", "b"})
 }
+
+func TestBuildSidecars(t *testing.T) {
+	sidecars := []qav1alpha1.SidecarSpec{
+		{
+			Name:  "filebeat",
+			Image: "filebeat:7",
+			VolumeMounts: []qav1alpha1.ContainerVolumeMount{
+				{Volume: "/app/logs", MountPath: "/logs", ReadOnly: true},
+			},
+		},
+	}
+	volumeMounts := []v1.VolumeMount{{Name: "volume-0", MountPath: "/app/logs"}}
+	containers, err := deployment_handler.buildSidecars(sidecars, volumeMounts, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []v1.VolumeMount{{Name: "volume-0", MountPath: "/logs", ReadOnly: true}}, containers[0].VolumeMounts)
+
+	sidecars[0].VolumeMounts[0].Volume = "/data"
+	_, err = deployment_handler.buildSidecars(sidecars, volumeMounts, nil)
+	assert.EqualError(t, err, "volume /data of container filebeat is not declared in volumes")
+}
+
+func TestMergeContainers(t *testing.T) {
+	current := []v1.Container{{Name: "app"}, {Name: "filebeat"}, {Name: "injected"}}
+	managed := []v1.Container{{Name: "app", Image: "app:2"}, {Name: "envoy"}}
+	// filebeat是上一次调和的sidecar，已经被删掉；injected由其他webhook注入，需要保留
+	containers := mergeContainers(current, managed, []string{"filebeat"})
+	assert.Equal(t, []v1.Container{{Name: "app", Image: "app:2"}, {Name: "envoy"}, {Name: "injected"}}, containers)
+}
+
+func TestBuildInitContainers(t *testing.T) {
+	h := &deploymentHandler{sqbdeployment: &qav1alpha1.SQBDeployment{}}
+	deploy := qav1alpha1.DeploySpec{
+		InitContainers: []qav1alpha1.InitContainerSpec{
+			{Name: "migrate", Image: "flyway", VolumeMounts: []qav1alpha1.ContainerVolumeMount{{Volume: "/data"}}},
+			{Name: "wait"},
+		},
+	}
+	volumeMounts := []v1.VolumeMount{{Name: "volume-0", MountPath: "/data"}}
+	containers, err := h.buildInitContainers(deploy, volumeMounts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(containers))
+	assert.Equal(t, "/data", containers[0].VolumeMounts[0].MountPath)
+	assert.Equal(t, "busybox:1.32", containers[1].Image)
+
+	// 注解指定的镜像生成init0，并作为其他初始化容器的默认镜像
+	h.sqbdeployment.Annotations = map[string]string{entity.InitContainerAnnotationKey: "alpine:3"}
+	containers, err = h.buildInitContainers(deploy, volumeMounts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"init0", "migrate", "wait"},
+		[]string{containers[0].Name, containers[1].Name, containers[2].Name})
+	assert.Equal(t, "alpine:3", containers[0].Image)
+	assert.Equal(t, volumeMounts, containers[0].VolumeMounts)
+	assert.Equal(t, "alpine:3", containers[2].Image)
+}
+
+func TestAddProbes(t *testing.T) {
+	healthCheck := &v1.Probe{
+		InitialDelaySeconds: 60,
+		ProbeHandler:        v1.ProbeHandler{TCPSocket: &v1.TCPSocketAction{}},
+	}
+	sqbdeployment := &qav1alpha1.SQBDeployment{}
+	sqbdeployment.Name = "demo"
+	sqbdeployment.Spec.HealthCheck = healthCheck
+	h := &deploymentHandler{sqbdeployment: sqbdeployment}
+	deployment := &appv1.Deployment{}
+	deployment.Spec.Template.Spec.Containers = []v1.Container{{Name: "istio-proxy"}, {Name: "demo"}}
+
+	h.addProbes(deployment)
+	container := deployment.Spec.Template.Spec.Containers[1]
+	assert.Equal(t, int32(10), container.LivenessProbe.InitialDelaySeconds)
+	assert.Equal(t, int32(10), container.ReadinessProbe.InitialDelaySeconds)
+	assert.Equal(t, int32(60), container.StartupProbe.InitialDelaySeconds)
+	assert.Equal(t, int32(entity.DefaultStartupTimeoutSeconds/10), container.StartupProbe.FailureThreshold)
+	// healthCheck不会被修改
+	assert.Equal(t, int32(60), healthCheck.InitialDelaySeconds)
+	assert.Nil(t, deployment.Spec.Template.Spec.Containers[0].LivenessProbe)
+
+	// 单独配置的probe优先，启动时间可以按应用配置
+	readinessProbe := &v1.Probe{PeriodSeconds: 3}
+	sqbdeployment.Spec.ReadinessProbe = readinessProbe
+	sqbdeployment.Spec.StartupTimeoutSeconds = proto.Int32(600)
+	h.addProbes(deployment)
+	container = deployment.Spec.Template.Spec.Containers[1]
+	assert.Equal(t, readinessProbe, container.ReadinessProbe)
+	assert.Equal(t, int32(60), container.StartupProbe.FailureThreshold)
+
+	sqbdeployment.Spec.HealthCheck = nil
+	h.addProbes(deployment)
+	container = deployment.Spec.Template.Spec.Containers[1]
+	assert.Nil(t, container.LivenessProbe)
+	assert.Nil(t, container.StartupProbe)
+	assert.Equal(t, readinessProbe, container.ReadinessProbe)
+}
+
+func TestAddScheduling(t *testing.T) {
+	sqbdeployment := &qav1alpha1.SQBDeployment{}
+	sqbdeployment.Spec.Selector = qav1alpha1.Selector{App: "demo", Plane: "base"}
+	sqbdeployment.Spec.Tolerations = []v1.Toleration{{Key: "spot", Operator: v1.TolerationOpExists}}
+	sqbdeployment.Spec.TopologySpreadConstraints = []v1.TopologySpreadConstraint{
+		{MaxSkew: 1, TopologyKey: "topology.kubernetes.io/zone", WhenUnsatisfiable: v1.ScheduleAnyway},
+	}
+	h := &deploymentHandler{sqbdeployment: sqbdeployment}
+	deployment := &appv1.Deployment{}
+	h.addScheduling(deployment)
+	podSpec := deployment.Spec.Template.Spec
+	assert.Equal(t, sqbdeployment.Spec.Tolerations, podSpec.Tolerations)
+	assert.Equal(t, map[string]string{"app": "demo", "version": "base"},
+		podSpec.TopologySpreadConstraints[0].LabelSelector.MatchLabels)
+	// 不修改sqbdeployment
+	assert.Nil(t, sqbdeployment.Spec.TopologySpreadConstraints[0].LabelSelector)
+}
+
+func TestAddPodAntiAffinity(t *testing.T) {
+	sqbdeployment := &qav1alpha1.SQBDeployment{}
+	h := &deploymentHandler{sqbdeployment: sqbdeployment}
+	deployment := &appv1.Deployment{}
+	deployment.Spec.Template.Labels = map[string]string{"app": "demo"}
+	h.addPodAntiAffinity(deployment)
+	terms := deployment.Spec.Template.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	assert.Equal(t, "kubernetes.io/hostname", terms[0].PodAffinityTerm.TopologyKey)
+
+	custom := &v1.PodAntiAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{{TopologyKey: "topology.kubernetes.io/zone"}},
+	}
+	sqbdeployment.Spec.PodAntiAffinity = &qav1alpha1.PodAntiAffinitySpec{Custom: custom}
+	h.addPodAntiAffinity(deployment)
+	assert.Equal(t, custom, deployment.Spec.Template.Spec.Affinity.PodAntiAffinity)
+
+	sqbdeployment.Spec.PodAntiAffinity = &qav1alpha1.PodAntiAffinitySpec{Disabled: true}
+	h.addPodAntiAffinity(deployment)
+	assert.Nil(t, deployment.Spec.Template.Spec.Affinity.PodAntiAffinity)
+}
+
+func TestSecurityContext(t *testing.T) {
+	entity.ConfigMapData.FromMap(map[string]string{
+		"operatorDelay":   "0",
+		"securityProfile": `{"podSecurityContext":{"runAsNonRoot":true,"fsGroup":1000},"securityContext":{"readOnlyRootFilesystem":true}}`,
+	})
+	defer entity.ConfigMapData.FromMap(map[string]string{"operatorDelay": "0"})
+
+	sqbdeployment := &qav1alpha1.SQBDeployment{}
+	h := &deploymentHandler{sqbdeployment: sqbdeployment}
+	podSecurityContext, securityContext := resolveSecurityContext(sqbdeployment.Spec.DeploySpec)
+	assert.Equal(t, int64(1000), *podSecurityContext.FSGroup)
+	assert.True(t, *securityContext.ReadOnlyRootFilesystem)
+
+	// 应用的配置优先，同时应用到初始化容器
+	sqbdeployment.Spec.SecurityContext = &v1.SecurityContext{RunAsUser: proto.Int64(1001)}
+	sqbdeployment.Spec.InitContainers = []qav1alpha1.InitContainerSpec{{Name: "migrate"}}
+	podSecurityContext, securityContext = resolveSecurityContext(sqbdeployment.Spec.DeploySpec)
+	assert.True(t, *podSecurityContext.RunAsNonRoot)
+	assert.Nil(t, securityContext.ReadOnlyRootFilesystem)
+	initContainers, err := h.buildInitContainers(sqbdeployment.Spec.DeploySpec, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, securityContext, initContainers[0].SecurityContext)
+}
+
+func TestGetVolumeAndVolumeMounts(t *testing.T) {
+	specs := []*qav1alpha1.VolumeSpec{
+		{MountPath: "/etc/app/a.yaml", SubPath: "a.yaml", ConfigMap: "config", ReadOnly: true},
+		{MountPath: "/etc/app/b.yaml", SubPath: "b.yaml", ConfigMap: "config", ReadOnly: true},
+		{MountPath: "/cache", EmptyDir: true, EmptyDirMedium: v1.StorageMediumMemory},
+	}
+	volumes, volumeMounts := deployment_handler.getVolumeAndVolumeMounts(specs)
+	// 相同的来源共用一个volume
+	assert.Equal(t, 2, len(volumes))
+	assert.Equal(t, volumeMounts[0].Name, volumeMounts[1].Name)
+	assert.Equal(t, "b.yaml", volumeMounts[1].SubPath)
+	assert.True(t, volumeMounts[1].ReadOnly)
+	assert.Equal(t, v1.StorageMediumMemory, volumes[1].EmptyDir.Medium)
+
+	// 调整顺序不影响volume的名字
+	reordered, reorderedMounts := deployment_handler.getVolumeAndVolumeMounts(
+		[]*qav1alpha1.VolumeSpec{specs[2], specs[0], specs[1]})
+	assert.Equal(t, volumes[0].Name, reordered[1].Name)
+	assert.Equal(t, volumeMounts[2], reorderedMounts[0])
+
+	// 相同配置的emptyDir是不同的存储，不能合并
+	volumes, volumeMounts = deployment_handler.getVolumeAndVolumeMounts([]*qav1alpha1.VolumeSpec{
+		{MountPath: "/tmp", EmptyDir: true},
+		{MountPath: "/cache", EmptyDir: true},
+	})
+	assert.Equal(t, 2, len(volumes))
+	assert.NotEqual(t, volumeMounts[0].Name, volumeMounts[1].Name)
+	assert.Equal(t, volumes[1].Name, volumeMounts[1].Name)
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	istionetworkingv1beta1 "github.com/wosai/elastic-env-operator/api/istio/networking/v1beta1"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	"github.com/wosai/elastic-env-operator/domain/util"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type destinationRuleHandler struct {
+	sqbapplication *qav1alpha1.SQBApplication
+	ctx            context.Context
+}
+
+func NewDestinationRuleHandler(sqbapplication *qav1alpha1.SQBApplication, ctx context.Context) *destinationRuleHandler {
+	return &destinationRuleHandler{sqbapplication: sqbapplication, ctx: ctx}
+}
+
+// CreateOrUpdate 每个环境(plane)对应一个subset，subset的名字为{应用名}-{plane}。
+// 应用的连接池和异常点检测配置作为整体的trafficPolicy，sqbdeployment覆盖时设置到对应的subset
+func (h *destinationRuleHandler) CreateOrUpdate() error {
+	sqbdeployments, err := getSqbdeployments(h.ctx, h.sqbapplication)
+	if err != nil {
+		return err
+	}
+	planes := getPlanes(sqbdeployments)
+	destinationRule := &istionetworkingv1beta1.DestinationRule{ObjectMeta: metav1.ObjectMeta{
+		Namespace: h.sqbapplication.Namespace,
+		Name:      h.sqbapplication.Name,
+	}}
+	err = k8sclient.Get(h.ctx, client.ObjectKey{Namespace: destinationRule.Namespace, Name: destinationRule.Name}, destinationRule)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	overrides := make(map[string]*qav1alpha1.TrafficPolicy)
+	for _, sqbdeployment := range sqbdeployments {
+		if policy := sqbdeployment.Spec.TrafficPolicy; policy != nil && (policy.ConnectionPool != nil || policy.OutlierDetection != nil) {
+			overrides[sqbdeployment.Labels[entity.PlaneKey]] = policy
+		}
+	}
+	colors := getActiveColors(sqbdeployments)
+	subsets := make([]istionetworkingv1beta1.Subset, len(planes))
+	for i, plane := range planes {
+		subsets[i] = istionetworkingv1beta1.Subset{
+			Name:   util.GetSubsetName(h.sqbapplication.Name, plane),
+			Labels: map[string]string{entity.PlaneKey: plane},
+		}
+		// 蓝绿发布的环境只选择接收流量的颜色
+		if color, ok := colors[plane]; ok {
+			subsets[i].Labels[entity.ColorKey] = color
+		}
+		if override, ok := overrides[plane]; ok {
+			subsets[i].TrafficPolicy = istioTrafficPolicy(h.sqbapplication.Spec.TrafficPolicy.Override(override))
+		}
+	}
+	destinationRule.Spec = istionetworkingv1beta1.DestinationRuleSpec{
+		Host:          h.sqbapplication.Name,
+		TrafficPolicy: istioTrafficPolicy(h.sqbapplication.Spec.TrafficPolicy),
+		Subsets:       subsets,
+	}
+	if anno, ok := h.sqbapplication.Annotations[entity.DestinationRuleAnnotationKey]; ok {
+		destinationRule.Annotations = make(map[string]string)
+		_ = json.Unmarshal([]byte(anno), &destinationRule.Annotations)
+	} else {
+		destinationRule.Annotations = nil
+	}
+	destinationRule.Labels = util.MergeStringMap(destinationRule.Labels, h.sqbapplication.Labels)
+	return CreateOrUpdate(h.ctx, destinationRule)
+}
+
+func (h *destinationRuleHandler) Delete() error {
+	destinationRule := &istionetworkingv1beta1.DestinationRule{}
+	err := k8sclient.Get(h.ctx, client.ObjectKey{Namespace: h.sqbapplication.Namespace, Name: h.sqbapplication.Name}, destinationRule)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return Delete(h.ctx, destinationRule)
+}
+
+func (h *destinationRuleHandler) Name() string {
+	return "DestinationRule"
+}
+
+// Handle 集群没有安装istio时不处理，应用关闭istio注入后删除
+func (h *destinationRuleHandler) Handle() error {
+	if !entity.ConfigMapData.IstioEnable() {
+		return nil
+	}
+	if deleted, _ := IsDeleted(h.sqbapplication); deleted || !IsMeshInject(h.sqbapplication) {
+		return h.Delete()
+	}
+	return h.CreateOrUpdate()
+}
+
+// getSqbdeployments 查询应用没有被删除的sqbdeployment
+func getSqbdeployments(ctx context.Context, sqbapplication *qav1alpha1.SQBApplication) ([]qav1alpha1.SQBDeployment, error) {
+	sqbdeploymentList := &qav1alpha1.SQBDeploymentList{}
+	err := k8sclient.List(ctx, sqbdeploymentList, &client.ListOptions{
+		Namespace:     sqbapplication.Namespace,
+		LabelSelector: labels.SelectorFromSet(map[string]string{entity.AppKey: sqbapplication.Name}),
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	sqbdeployments := make([]qav1alpha1.SQBDeployment, 0, len(sqbdeploymentList.Items))
+	for _, sqbdeployment := range sqbdeploymentList.Items {
+		if deleted, _ := IsDeleted(&sqbdeployment); deleted || !sqbdeployment.DeletionTimestamp.IsZero() {
+			continue
+		}
+		sqbdeployments = append(sqbdeployments, sqbdeployment)
+	}
+	return sqbdeployments, nil
+}
+
+// getPlanes 应用部署了的环境，总是包含基础环境，按名字排序
+func getPlanes(sqbdeployments []qav1alpha1.SQBDeployment) []string {
+	planes := []string{entity.ConfigMapData.BaseFlag()}
+	for _, sqbdeployment := range sqbdeployments {
+		if plane := sqbdeployment.Labels[entity.PlaneKey]; plane != "" && !util.ContainString(planes, plane) {
+			planes = append(planes, plane)
+		}
+	}
+	sort.Strings(planes)
+	return planes
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	istionetworkingv1beta1 "github.com/wosai/elastic-env-operator/api/istio/networking/v1beta1"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestDestinationRule(t *testing.T) {
+	app := newIstioTestApplication(t)
+	app.Annotations = map[string]string{entity.DestinationRuleAnnotationKey: `{"a":"b"}`}
+	ctx := context.Background()
+	assert.Nil(t, NewDestinationRuleHandler(app, ctx).Handle())
+	destinationRule := &istionetworkingv1beta1.DestinationRule{}
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "demo"}, destinationRule))
+	assert.Equal(t, "demo", destinationRule.Spec.Host)
+	assert.Equal(t, []istionetworkingv1beta1.Subset{
+		{Name: "demo-base", Labels: map[string]string{entity.PlaneKey: "base"}},
+		{Name: "demo-feature", Labels: map[string]string{entity.PlaneKey: "feature"}},
+	}, destinationRule.Spec.Subsets)
+	assert.Equal(t, map[string]string{"a": "b"}, destinationRule.Annotations)
+}
+
+func TestDestinationRuleBlueGreen(t *testing.T) {
+	app := newIstioTestApplication(t)
+	ctx := context.Background()
+	base := &qav1alpha1.SQBDeployment{}
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "demo-base"}, base))
+	base.Spec.Strategy = qav1alpha1.StrategyBlueGreen
+	now := metav1.Now()
+	base.Status.BlueGreen = &qav1alpha1.BlueGreenStatus{ActiveColor: qav1alpha1.ColorGreen, SwitchTime: &now}
+	assert.Nil(t, k8sclient.Update(ctx, base))
+
+	assert.Nil(t, NewDestinationRuleHandler(app, ctx).Handle())
+	destinationRule := &istionetworkingv1beta1.DestinationRule{}
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "demo"}, destinationRule))
+	// 切换过流量的环境只选择接收流量的颜色
+	assert.Equal(t, map[string]string{entity.PlaneKey: "base", entity.ColorKey: qav1alpha1.ColorGreen},
+		destinationRule.Spec.Subsets[0].Labels)
+	assert.Equal(t, map[string]string{entity.PlaneKey: "feature"}, destinationRule.Spec.Subsets[1].Labels)
+}
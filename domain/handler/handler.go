@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/go-logr/logr"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
 	"github.com/wosai/elastic-env-operator/domain/entity"
 	"github.com/wosai/elastic-env-operator/domain/util"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -35,8 +36,15 @@ type (
 		ReconcileFail(runtimeObj, error)
 	}
 
+	// SQBRequeuer 处理成功后需要在一段时间后再次调和的reconciler，如灰度发布的暂停
+	SQBRequeuer interface {
+		RequeueAfter() time.Duration
+	}
+
 	SQBHandler interface {
 		Handle() error
+		// Name 处理的资源名称，失败时作为condition reason的前缀
+		Name() string
 	}
 )
 
@@ -74,7 +82,7 @@ func HandleReconcile(r SQBReconciler) (ctrl.Result, error) {
 	generation := obj.GetGeneration()
 	if yes, err := r.IsInitialized(obj); !yes {
 		if err != nil {
-			r.ReconcileFail(obj, err)
+			r.ReconcileFail(obj, &reconcileError{reason: qav1alpha1.ReasonInitializeFailed, err: err})
 			return ctrl.Result{}, util.IgnoreInvalidError(err)
 		}
 		if generation != obj.GetGeneration() {
@@ -86,6 +94,9 @@ func HandleReconcile(r SQBReconciler) (ctrl.Result, error) {
 		r.ReconcileFail(obj, err)
 		return ctrl.Result{}, util.IgnoreInvalidError(err)
 	}
+	if requeuer, ok := r.(SQBRequeuer); ok {
+		return ctrl.Result{RequeueAfter: requeuer.RequeueAfter()}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
@@ -0,0 +1,285 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	gatewayv1beta1 "github.com/wosai/elastic-env-operator/api/gatewayapi/v1beta1"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	"github.com/wosai/elastic-env-operator/domain/util"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// httpRouteHandler operator配置为gateway模式时，域名生成Gateway API的HTTPRoute代替Ingress
+type httpRouteHandler struct {
+	sqbapplication *qav1alpha1.SQBApplication
+	sqbdeployment  *qav1alpha1.SQBDeployment
+	ctx            context.Context
+}
+
+func NewSqbapplicationHTTPRouteHandler(sqbapplication *qav1alpha1.SQBApplication, ctx context.Context) *httpRouteHandler {
+	return &httpRouteHandler{sqbapplication: sqbapplication, ctx: ctx}
+}
+
+func NewSqbdeploymentHTTPRouteHandler(sqbdeployment *qav1alpha1.SQBDeployment, ctx context.Context) *httpRouteHandler {
+	return &httpRouteHandler{sqbdeployment: sqbdeployment, ctx: ctx}
+}
+
+// 与ingress相同，服务名+class+host唯一对应一个HTTPRoute，挂载到operator配置中class对应的Gateway
+func (h *httpRouteHandler) CreateOrUpdateForSqbapplication() error {
+	routeNames := make([]string, len(h.sqbapplication.Spec.Domains))
+	for i, domain := range h.sqbapplication.Spec.Domains {
+		parentRefs := entity.ConfigMapData.GatewayParentRefs(domain.Class)
+		if len(parentRefs) == 0 {
+			return fmt.Errorf("invalid domain class %s: no gateway is configured for HTTPRoute", domain.Class)
+		}
+		route := &gatewayv1beta1.HTTPRoute{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: h.sqbapplication.Namespace,
+				Name:      GetIngressName(h.sqbapplication.Name, domain.Class, domain.Host),
+			},
+		}
+		routeNames[i] = route.Name
+		err := k8sclient.Get(h.ctx, client.ObjectKey{Namespace: route.Namespace, Name: route.Name}, route)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		route.Labels = util.MergeStringMap(route.Labels, map[string]string{
+			entity.AppKey:   h.sqbapplication.Name,
+			entity.GroupKey: h.sqbapplication.Labels[entity.GroupKey],
+		})
+		route.Annotations = util.MergeStringMap(route.Annotations, domain.Annotation)
+		route.Annotations[entity.RouteClassAnnotationKey] = domain.Class
+		rules, err := h.getRules(domain.Class)
+		if err != nil {
+			return err
+		}
+		route.Spec = gatewayv1beta1.HTTPRouteSpec{
+			ParentRefs: parentRefs,
+			Hostnames:  []gatewayv1beta1.Hostname{gatewayv1beta1.Hostname(domain.Host)},
+			Rules:      rules,
+		}
+		if err = CreateOrUpdate(h.ctx, route); err != nil {
+			return err
+		}
+	}
+
+	// 如果HTTPRoute的host没有包含在domainHosts中，且HTTPRoute是自动生成的，则删除该HTTPRoute
+	routeList := &gatewayv1beta1.HTTPRouteList{}
+	err := k8sclient.List(h.ctx, routeList, &client.ListOptions{
+		Namespace:     h.sqbapplication.Namespace,
+		LabelSelector: labels.SelectorFromSet(map[string]string{entity.AppKey: h.sqbapplication.Name}),
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	publicEntryNames, err := getPublicEntryNames(h.ctx, h.sqbapplication)
+	if err != nil {
+		return err
+	}
+	routeNames = append(routeNames, publicEntryNames...)
+	for _, route := range routeList.Items {
+		if h.isAutoHTTPRoute(route) && !util.ContainString(routeNames, route.Name) {
+			if err = Delete(h.ctx, &route); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getRules 开启istio时转发到istio-ingressgateway，否则请求头x-env-flag为特性环境时转发到环境的service，
+// 其余按subpaths转发到对应的service，默认路由转发到应用的service
+func (h *httpRouteHandler) getRules(class string) ([]gatewayv1beta1.HTTPRouteRule, error) {
+	rules := metricsBlockingRules(class)
+	if IsMeshInject(h.sqbapplication) && HasMeshIngressGateway() {
+		return append(rules, gatewayv1beta1.HTTPRouteRule{
+			BackendRefs: []gatewayv1beta1.HTTPBackendRef{istioIngressGatewayBackendRef(h.sqbapplication.Namespace)},
+		}), nil
+	}
+	sqbdeployments, err := getSqbdeployments(h.ctx, h.sqbapplication)
+	if err != nil {
+		return nil, err
+	}
+	port := httpRoutePort(h.sqbapplication)
+	for _, plane := range getPlanes(sqbdeployments) {
+		if plane == entity.ConfigMapData.BaseFlag() {
+			continue
+		}
+		match := pathPrefixMatch("/")
+		match.Headers = []gatewayv1beta1.HTTPHeaderMatch{{Name: entity.XEnvFlag, Value: plane}}
+		rules = append(rules, gatewayv1beta1.HTTPRouteRule{
+			Matches:     []gatewayv1beta1.HTTPRouteMatch{match},
+			BackendRefs: []gatewayv1beta1.HTTPBackendRef{serviceBackendRef(util.GetSubsetName(h.sqbapplication.Name, plane), port)},
+		})
+	}
+	for _, subpath := range h.sqbapplication.Spec.Subpaths {
+		rules = append(rules, gatewayv1beta1.HTTPRouteRule{
+			Matches:     []gatewayv1beta1.HTTPRouteMatch{pathPrefixMatch(subpath.Path)},
+			BackendRefs: []gatewayv1beta1.HTTPBackendRef{serviceBackendRef(subpath.ServiceName, int32(subpath.ServicePort))},
+		})
+	}
+	return append(rules, gatewayv1beta1.HTTPRouteRule{
+		Matches:     []gatewayv1beta1.HTTPRouteMatch{pathPrefixMatch("/")},
+		BackendRefs: []gatewayv1beta1.HTTPBackendRef{serviceBackendRef(h.sqbapplication.Name, port)},
+	}), nil
+}
+
+// 外网特殊入口创建新的HTTPRoute，按host匹配后设置x-env-flag请求头，由istio-ingressgateway转发到对应的环境，
+// 应用没有注入istio或者集群没有istio-ingressgateway时直接转发到环境的service
+func (h *httpRouteHandler) CreateOrUpdateForSqbdeployment() error {
+	sqbapplication := &qav1alpha1.SQBApplication{}
+	if err := k8sclient.Get(h.ctx, client.ObjectKey{Namespace: h.sqbdeployment.Namespace, Name: h.sqbdeployment.Spec.Selector.App},
+		sqbapplication); err != nil {
+		return err
+	}
+	ingressClass := SpecialVirtualServiceIngress(h.sqbdeployment)
+	parentRefs := entity.ConfigMapData.GatewayParentRefs(ingressClass)
+	if len(parentRefs) == 0 {
+		return fmt.Errorf("invalid public entry class %s: no gateway is configured for HTTPRoute", ingressClass)
+	}
+	host := entity.ConfigMapData.GetDomainNameByClass(h.sqbdeployment.Name, ingressClass)
+	route := &gatewayv1beta1.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: h.sqbdeployment.Namespace,
+			Name:      GetIngressName(h.sqbdeployment.Labels[entity.AppKey], ingressClass, host),
+		},
+	}
+	if err := k8sclient.Get(h.ctx, client.ObjectKey{Namespace: route.Namespace, Name: route.Name}, route); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	route.Labels = util.MergeStringMap(route.Labels, h.sqbdeployment.Labels)
+	route.Annotations = util.MergeStringMap(route.Annotations, map[string]string{entity.RouteClassAnnotationKey: ingressClass})
+	plane := h.sqbdeployment.Labels[entity.PlaneKey]
+	rule := gatewayv1beta1.HTTPRouteRule{
+		Filters: []gatewayv1beta1.HTTPRouteFilter{{
+			Type: gatewayv1beta1.HTTPRouteFilterRequestHeaderModifier,
+			RequestHeaderModifier: &gatewayv1beta1.HTTPHeaderFilter{
+				Set: []gatewayv1beta1.HTTPHeader{{Name: entity.XEnvFlag, Value: plane}},
+			},
+		}},
+		BackendRefs: []gatewayv1beta1.HTTPBackendRef{istioIngressGatewayBackendRef(h.sqbdeployment.Namespace)},
+	}
+	if !IsMeshInject(sqbapplication) || !HasMeshIngressGateway() {
+		rule.BackendRefs = []gatewayv1beta1.HTTPBackendRef{
+			serviceBackendRef(util.GetSubsetName(sqbapplication.Name, plane), httpRoutePort(sqbapplication)),
+		}
+	}
+	route.Spec = gatewayv1beta1.HTTPRouteSpec{
+		ParentRefs: parentRefs,
+		Hostnames:  []gatewayv1beta1.Hostname{gatewayv1beta1.Hostname(host)},
+		Rules:      append(metricsBlockingRules(ingressClass), rule),
+	}
+	return CreateOrUpdate(h.ctx, route)
+}
+
+func (h *httpRouteHandler) DeleteForSqbapplication() error {
+	routeList := &gatewayv1beta1.HTTPRouteList{}
+	err := k8sclient.List(h.ctx, routeList, &client.ListOptions{
+		Namespace:     h.sqbapplication.Namespace,
+		LabelSelector: labels.SelectorFromSet(map[string]string{entity.AppKey: h.sqbapplication.Name}),
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	for _, route := range routeList.Items {
+		if !h.isAutoHTTPRoute(route) {
+			continue
+		}
+		if err = Delete(h.ctx, &route); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *httpRouteHandler) DeleteForSqbdeployment() error {
+	ingressClass := SpecialVirtualServiceIngress(h.sqbdeployment)
+	host := entity.ConfigMapData.GetDomainNameByClass(h.sqbdeployment.Name, ingressClass)
+	route := &gatewayv1beta1.HTTPRoute{ObjectMeta: metav1.ObjectMeta{
+		Namespace: h.sqbdeployment.Namespace,
+		Name:      GetIngressName(h.sqbdeployment.Labels[entity.AppKey], ingressClass, host),
+	}}
+	err := k8sclient.Get(h.ctx, client.ObjectKey{Namespace: route.Namespace, Name: route.Name}, route)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return Delete(h.ctx, route)
+}
+
+func (h *httpRouteHandler) Name() string {
+	return "HTTPRoute"
+}
+
+// Handle 集群没有配置gateway模式时可能没有安装Gateway API，不处理HTTPRoute
+func (h *httpRouteHandler) Handle() error {
+	if !entity.ConfigMapData.IsGatewayMode() {
+		return nil
+	}
+	if h.sqbapplication != nil {
+		if deleted, _ := IsDeleted(h.sqbapplication); deleted || len(h.sqbapplication.Spec.Domains) == 0 {
+			return h.DeleteForSqbapplication()
+		}
+		if !IsIngressOpen(h.sqbapplication) {
+			return h.DeleteForSqbapplication()
+		}
+		return h.CreateOrUpdateForSqbapplication()
+	}
+	if h.sqbdeployment != nil {
+		if deleted, _ := IsDeleted(h.sqbdeployment); deleted {
+			return h.DeleteForSqbdeployment()
+		}
+		if h.sqbdeployment.Annotations[entity.PublicEntryAnnotationKey] != "true" {
+			return h.DeleteForSqbdeployment()
+		}
+		return h.CreateOrUpdateForSqbdeployment()
+	}
+	return nil
+}
+
+// isAutoHTTPRoute 判断一个HTTPRoute是否是自动生成的，规则与isAutoIngress相同
+func (h *httpRouteHandler) isAutoHTTPRoute(route gatewayv1beta1.HTTPRoute) bool {
+	class := route.Annotations[entity.RouteClassAnnotationKey]
+	if class == "" || len(route.Spec.Hostnames) < 1 {
+		return false
+	}
+	return GetIngressName(h.sqbapplication.Name, class, string(route.Spec.Hostnames[0])) == route.Name
+}
+
+// metricsBlockingRules class的profile需要屏蔽/metrics时，生成没有backend的规则，Gateway对匹配的请求返回503
+func metricsBlockingRules(class string) []gatewayv1beta1.HTTPRouteRule {
+	rules := make([]gatewayv1beta1.HTTPRouteRule, 0)
+	if entity.ConfigMapData.IngressClassProfile(class).MetricsBlocking == entity.MetricsBlockingNone {
+		return rules
+	}
+	return append(rules, gatewayv1beta1.HTTPRouteRule{
+		Matches: []gatewayv1beta1.HTTPRouteMatch{pathPrefixMatch("/metrics")},
+	})
+}
+
+func pathPrefixMatch(path string) gatewayv1beta1.HTTPRouteMatch {
+	pathType := gatewayv1beta1.PathMatchPathPrefix
+	return gatewayv1beta1.HTTPRouteMatch{Path: &gatewayv1beta1.HTTPPathMatch{Type: &pathType, Value: &path}}
+}
+
+func serviceBackendRef(name string, port int32) gatewayv1beta1.HTTPBackendRef {
+	return gatewayv1beta1.HTTPBackendRef{BackendRef: gatewayv1beta1.BackendRef{
+		BackendObjectReference: gatewayv1beta1.BackendObjectReference{Name: name, Port: &port},
+	}}
+}
+
+// httpRoutePort Gateway API转发到service port
+func httpRoutePort(sqbapplication *qav1alpha1.SQBApplication) int32 {
+	if ports := sqbapplication.Spec.Ports; len(ports) != 0 {
+		return ports[0].Port
+	}
+	return 80
+}
+
+func istioIngressGatewayBackendRef(namespace string) gatewayv1beta1.HTTPBackendRef {
+	return serviceBackendRef("istio-ingressgateway"+"-"+namespace, 80)
+}
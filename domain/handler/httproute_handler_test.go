@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wosai/elastic-env-operator/api/gatewayapi"
+	gatewayv1beta1 "github.com/wosai/elastic-env-operator/api/gatewayapi/v1beta1"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestHTTPRoute(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = qav1alpha1.AddToScheme(scheme)
+	_ = gatewayapi.AddToScheme(scheme)
+	SetK8sScheme(scheme)
+	labels := map[string]string{entity.AppKey: "demo"}
+	stale := &gatewayv1beta1.HTTPRoute{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default", Name: GetIngressName("demo", "nginx", "old.iwosai.com"), Labels: labels,
+		Annotations: map[string]string{entity.RouteClassAnnotationKey: "nginx"},
+	}, Spec: gatewayv1beta1.HTTPRouteSpec{Hostnames: []gatewayv1beta1.Hostname{"old.iwosai.com"}}}
+	manual := &gatewayv1beta1.HTTPRoute{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "manual", Labels: labels}}
+	ingress := &v1.Ingress{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default", Name: GetIngressName("demo", "nginx", "demo.iwosai.com"), Labels: labels,
+	}, Spec: v1.IngressSpec{
+		IngressClassName: &[]string{"nginx"}[0],
+		Rules:            []v1.IngressRule{{Host: "demo.iwosai.com"}},
+	}}
+	feature := &qav1alpha1.SQBDeployment{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default", Name: "demo-feature",
+		Labels: map[string]string{entity.AppKey: "demo", entity.PlaneKey: "feature"},
+	}}
+	SetK8sClient(fake.NewClientBuilder().WithScheme(scheme).WithObjects(stale, manual, ingress, feature).Build())
+	defer SetK8sClient(nil)
+	ctx := context.Background()
+	app := &qav1alpha1.SQBApplication{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo", Labels: labels}}
+	app.Spec.Domains = []qav1alpha1.Domain{{Class: "nginx", Host: "demo.iwosai.com"}}
+	app.Spec.Subpaths = []qav1alpha1.Subpath{{Path: "/v4", ServiceName: "sales", ServicePort: 8080}}
+	app.Spec.Ports = []corev1.ServicePort{{Port: 80}}
+
+	// 没有开启gateway模式时不处理HTTPRoute
+	assert.Nil(t, NewSqbapplicationHTTPRouteHandler(app, ctx).Handle())
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKeyFromObject(stale), &gatewayv1beta1.HTTPRoute{}))
+
+	entity.ConfigMapData.FromMap(map[string]string{
+		"operatorDelay":     "0",
+		"ingressOpen":       "true",
+		"ingressMode":       "gateway",
+		"gatewayParentRefs": `{"nginx":[{"namespace":"gateway-system","name":"public"}]}`,
+	})
+	defer entity.ConfigMapData.FromMap(map[string]string{"operatorDelay": "0"})
+	assert.Nil(t, NewSqbapplicationHTTPRouteHandler(app, ctx).Handle())
+	route := &gatewayv1beta1.HTTPRoute{}
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: ingress.Name}, route))
+	assert.Equal(t, "public", route.Spec.ParentRefs[0].Name)
+	assert.Equal(t, []gatewayv1beta1.Hostname{"demo.iwosai.com"}, route.Spec.Hostnames)
+	rules := route.Spec.Rules
+	assert.Equal(t, 4, len(rules))
+	assert.Equal(t, "/metrics", *rules[0].Matches[0].Path.Value)
+	assert.Nil(t, rules[0].BackendRefs)
+	// 请求头x-env-flag为特性环境时转发到环境的service
+	assert.Equal(t, []gatewayv1beta1.HTTPHeaderMatch{{Name: entity.XEnvFlag, Value: "feature"}}, rules[1].Matches[0].Headers)
+	assert.Equal(t, "demo-feature", rules[1].BackendRefs[0].Name)
+	assert.Equal(t, "sales", rules[2].BackendRefs[0].Name)
+	assert.Equal(t, "demo", rules[3].BackendRefs[0].Name)
+	assert.Equal(t, int32(80), *rules[3].BackendRefs[0].Port)
+	// 自动生成的HTTPRoute被清理，手动创建的保留
+	err := k8sclient.Get(ctx, client.ObjectKeyFromObject(stale), &gatewayv1beta1.HTTPRoute{})
+	assert.True(t, apierrors.IsNotFound(err))
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKeyFromObject(manual), &gatewayv1beta1.HTTPRoute{}))
+
+	// gateway模式下删除自动生成的ingress
+	assert.Nil(t, NewSqbapplicationIngressHandler(app, ctx).Handle())
+	err = k8sclient.Get(ctx, client.ObjectKeyFromObject(ingress), &v1.Ingress{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	app.Spec.Domains[0].Class = "nginx-vpc"
+	assert.EqualError(t, NewSqbapplicationHTTPRouteHandler(app, ctx).Handle(),
+		"invalid domain class nginx-vpc: no gateway is configured for HTTPRoute")
+}
+
+func TestHTTPRouteForPublicEntry(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = qav1alpha1.AddToScheme(scheme)
+	_ = gatewayapi.AddToScheme(scheme)
+	SetK8sScheme(scheme)
+	app := &qav1alpha1.SQBApplication{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo"}}
+	app.Spec.Ports = []corev1.ServicePort{{Port: 8080}}
+	SetK8sClient(fake.NewClientBuilder().WithScheme(scheme).WithObjects(app).Build())
+	defer SetK8sClient(nil)
+	configs := map[string]string{
+		"operatorDelay":     "0",
+		"ingressMode":       "gateway",
+		"domainPostfix":     `{"nginx":"*.iwosai.com"}`,
+		"gatewayParentRefs": `{"nginx":[{"name":"public"}]}`,
+	}
+	entity.ConfigMapData.FromMap(configs)
+	defer entity.ConfigMapData.FromMap(map[string]string{"operatorDelay": "0"})
+	ctx := context.Background()
+	sqbdeployment := &qav1alpha1.SQBDeployment{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "default",
+		Name:        "demo-feature",
+		Labels:      map[string]string{entity.AppKey: "demo", entity.PlaneKey: "feature"},
+		Annotations: map[string]string{entity.PublicEntryAnnotationKey: "true"},
+	}}
+	sqbdeployment.Spec.Selector.App = "demo"
+	// 没有开启istio时直接转发到环境的service
+	assert.Nil(t, NewSqbdeploymentHTTPRouteHandler(sqbdeployment, ctx).Handle())
+	host := entity.ConfigMapData.GetDomainNameByClass("demo-feature", "nginx")
+	route := &gatewayv1beta1.HTTPRoute{}
+	key := client.ObjectKey{Namespace: "default", Name: GetIngressName("demo", "nginx", host)}
+	assert.Nil(t, k8sclient.Get(ctx, key, route))
+	rule := route.Spec.Rules[1]
+	assert.Equal(t, []gatewayv1beta1.HTTPHeader{{Name: entity.XEnvFlag, Value: "feature"}}, rule.Filters[0].RequestHeaderModifier.Set)
+	assert.Equal(t, "demo-feature", rule.BackendRefs[0].Name)
+	assert.Equal(t, int32(8080), *rule.BackendRefs[0].Port)
+
+	// 应用注入istio且集群有istio-ingressgateway时由istio-ingressgateway按请求头转发
+	configs["istioEnable"] = "true"
+	configs["istioInject"] = "true"
+	entity.ConfigMapData.FromMap(configs)
+	// fake client不设置creationTimestamp，删除后重新创建
+	assert.Nil(t, k8sclient.Delete(ctx, route))
+	assert.Nil(t, NewSqbdeploymentHTTPRouteHandler(sqbdeployment, ctx).Handle())
+	assert.Nil(t, k8sclient.Get(ctx, key, route))
+	rule = route.Spec.Rules[1]
+	assert.Equal(t, "istio-ingressgateway-default", rule.BackendRefs[0].Name)
+
+	sqbdeployment.Annotations[entity.PublicEntryAnnotationKey] = "false"
+	assert.Nil(t, NewSqbdeploymentHTTPRouteHandler(sqbdeployment, ctx).Handle())
+	assert.True(t, apierrors.IsNotFound(k8sclient.Get(ctx, key, route)))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	certmanagerv1 "github.com/wosai/elastic-env-operator/api/certmanager/v1"
 	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
 	"github.com/wosai/elastic-env-operator/domain/entity"
 	"github.com/wosai/elastic-env-operator/domain/util"
@@ -13,6 +14,12 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	serverSnippetAnnotationKey = "nginx.ingress.kubernetes.io/server-snippet"
+	metricsServerSnippet       = "location ~ ^/metrics {deny all;return 404;}"
 )
 
 type ingressHandler struct {
@@ -33,7 +40,6 @@ func NewSqbdeploymentIngressHandler(sqbdeployment *qav1alpha1.SQBDeployment, ctx
 // 2 服务相同、class相同、host相同，只是path不同，认为应该配置在同一个ingress
 // 3 如果确定不同path需要不同的ingress annotation而要配置在不同的ingress中的，这些情况手动配置
 func (h *ingressHandler) CreateOrUpdateForSqbapplication() error {
-	pathType := v1.PathTypeImplementationSpecific
 	ingressNames := make([]string, len(h.sqbapplication.Spec.Domains))
 	for i, domain := range h.sqbapplication.Spec.Domains {
 		ingress := &v1.Ingress{
@@ -50,7 +56,7 @@ func (h *ingressHandler) CreateOrUpdateForSqbapplication() error {
 
 		paths := make([]v1.HTTPIngressPath, 0)
 		// 开启istio并且有istio-ingressgateway组件
-		if IsIstioInject(h.sqbapplication) && HasIstioIngressGateway() {
+		if IsMeshInject(h.sqbapplication) && HasMeshIngressGateway() {
 			path := v1.HTTPIngressPath{
 				Backend: v1.IngressBackend{
 					Service: &v1.IngressServiceBackend{
@@ -58,7 +64,6 @@ func (h *ingressHandler) CreateOrUpdateForSqbapplication() error {
 						Port: v1.ServiceBackendPort{Number: 80},
 					},
 				},
-				PathType: &pathType,
 			}
 			paths = append(paths, path)
 		} else {
@@ -71,31 +76,11 @@ func (h *ingressHandler) CreateOrUpdateForSqbapplication() error {
 							Port: v1.ServiceBackendPort{Number: int32(subpath.ServicePort)},
 						},
 					},
-					PathType: &pathType,
 				}
 				paths = append(paths, path)
 			}
-			// https://stackoverflow.com/questions/49829452/why-ingress-serviceport-can-be-port-and-targetport-of-service
-			// 使用target port而不是service port
-			var servicePort intstr.IntOrString
-			if ports := h.sqbapplication.Spec.Ports; len(ports) == 0 {
-				servicePort = intstr.FromInt(80)
-			} else {
-				servicePort = ports[0].TargetPort
-			}
 			// 默认路由
-			path := v1.HTTPIngressPath{
-				Backend: v1.IngressBackend{
-					Service: &v1.IngressServiceBackend{
-						Name: h.sqbapplication.Name,
-						Port: v1.ServiceBackendPort{
-							Number: servicePort.IntVal,
-						},
-					},
-				},
-				PathType: &pathType,
-			}
-			paths = append(paths, path)
+			paths = append(paths, defaultIngressPath(h.sqbapplication, h.sqbapplication.Name))
 		}
 		rule := v1.IngressRule{
 			Host: domain.Host,
@@ -110,17 +95,10 @@ func (h *ingressHandler) CreateOrUpdateForSqbapplication() error {
 			entity.AppKey:   h.sqbapplication.Name,
 			entity.GroupKey: h.sqbapplication.Labels[entity.GroupKey],
 		})
-		if len(domain.Annotation) != 0 {
-			ingress.Annotations = util.MergeStringMap(ingress.Annotations, domain.Annotation)
-		}
-		mergedAnnotations := map[string]string{
-			entity.IngressClassAnnotationKey: domain.Class,
-		}
-		if domain.Class == "nginx" {
-			mergedAnnotations["nginx.ingress.kubernetes.io/server-snippet"] = "location ~ ^/metrics {deny all;return 404;}"
+		applyIngressClassProfile(ingress, domain.Class, domain.Annotation)
+		if ingress.Spec.TLS, err = ingressTLS(h.ctx, h.sqbapplication, ingress.Name, domain.Host, domain.TLS); err != nil {
+			return err
 		}
-
-		ingress.Annotations = util.MergeStringMap(ingress.Annotations, mergedAnnotations)
 		if err = CreateOrUpdate(h.ctx, ingress); err != nil {
 			return err
 		}
@@ -136,27 +114,16 @@ func (h *ingressHandler) CreateOrUpdateForSqbapplication() error {
 		return err
 	}
 
-	// 查询对应的sqbdeployment，ingressNames加上sqbdeployment对应的ingress
-	sqbdeploymentList := &qav1alpha1.SQBDeploymentList{}
-	err = k8sclient.List(h.ctx, sqbdeploymentList, &client.ListOptions{
-		Namespace:     h.sqbapplication.Namespace,
-		LabelSelector: labels.SelectorFromSet(map[string]string{entity.AppKey: h.sqbapplication.Name}),
-	})
-	if err != nil && !apierrors.IsNotFound(err) {
+	// ingressNames加上sqbdeployment对应的ingress
+	publicEntryNames, err := getPublicEntryNames(h.ctx, h.sqbapplication)
+	if err != nil {
 		return err
 	}
-	for _, sqbdeployment := range sqbdeploymentList.Items {
-		if sqbdeployment.GetAnnotations()[entity.PublicEntryAnnotationKey] == "true" {
-			ingressClass := SpecialVirtualServiceIngress(&sqbdeployment)
-			host := entity.ConfigMapData.GetDomainNameByClass(sqbdeployment.Name, ingressClass)
-			name := GetIngressName(h.sqbapplication.Name, ingressClass, host)
-			ingressNames = append(ingressNames, name)
-		}
-	}
+	ingressNames = append(ingressNames, publicEntryNames...)
 
 	for _, ingress := range ingressList.Items {
 		if h.isAutoIngress(ingress) && !util.ContainString(ingressNames, ingress.Name) {
-			if err = Delete(h.ctx, &ingress); err != nil {
+			if err = deleteIngress(h.ctx, &ingress); err != nil {
 				return err
 			}
 		}
@@ -164,19 +131,53 @@ func (h *ingressHandler) CreateOrUpdateForSqbapplication() error {
 	return nil
 }
 
+// defaultIngressPath 转发到应用service的默认路由，serviceName为应用或环境的service
+func defaultIngressPath(sqbapplication *qav1alpha1.SQBApplication, serviceName string) v1.HTTPIngressPath {
+	// https://stackoverflow.com/questions/49829452/why-ingress-serviceport-can-be-port-and-targetport-of-service
+	// 使用target port而不是service port
+	var servicePort intstr.IntOrString
+	if ports := sqbapplication.Spec.Ports; len(ports) == 0 {
+		servicePort = intstr.FromInt(80)
+	} else {
+		servicePort = ports[0].TargetPort
+	}
+	return v1.HTTPIngressPath{
+		Backend: v1.IngressBackend{
+			Service: &v1.IngressServiceBackend{
+				Name: serviceName,
+				Port: v1.ServiceBackendPort{
+					Number: servicePort.IntVal,
+				},
+			},
+		},
+	}
+}
+
+// getPublicEntryNames 查询对应的sqbdeployment，返回外网特殊入口的ingress(HTTPRoute)名称
+func getPublicEntryNames(ctx context.Context, sqbapplication *qav1alpha1.SQBApplication) ([]string, error) {
+	sqbdeployments, err := getSqbdeployments(ctx, sqbapplication)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, sqbdeployment := range sqbdeployments {
+		if HasPublicEntry(&sqbdeployment) {
+			ingressClass := SpecialVirtualServiceIngress(&sqbdeployment)
+			host := entity.ConfigMapData.GetDomainNameByClass(sqbdeployment.Name, ingressClass)
+			names = append(names, GetIngressName(sqbapplication.Name, ingressClass, host))
+		}
+	}
+	return names, nil
+}
+
 // 外网特殊入口创建新的ingress
 func (h *ingressHandler) CreateOrUpdateForSqbdeployment() error {
 	ingressClass := SpecialVirtualServiceIngress(h.sqbdeployment)
-	pathType := v1.PathTypeImplementationSpecific
 	host := entity.ConfigMapData.GetDomainNameByClass(h.sqbdeployment.Name, SpecialVirtualServiceIngress(h.sqbdeployment))
 	ingress := &v1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: h.sqbdeployment.Namespace,
 			Name:      GetIngressName(h.sqbdeployment.Labels[entity.AppKey], ingressClass, host),
-			Annotations: map[string]string{
-				"kubernetes.io/ingress.class":                ingressClass,
-				"nginx.ingress.kubernetes.io/server-snippet": "location ~ ^/metrics {deny all;return 404;}",
-			},
 		},
 	}
 	if err := k8sclient.Get(h.ctx, client.ObjectKey{Namespace: ingress.Namespace, Name: ingress.Name}, ingress); err != nil && !apierrors.IsNotFound(err) {
@@ -196,13 +197,22 @@ func (h *ingressHandler) CreateOrUpdateForSqbdeployment() error {
 								Port: v1.ServiceBackendPort{Number: 80},
 							},
 						},
-						PathType: &pathType,
 					},
 				},
 			},
 		},
 	}
 	ingress.Spec.Rules = []v1.IngressRule{rule}
+	applyIngressClassProfile(ingress, ingressClass, nil)
+	// 外网入口的域名使用operator配置中默认的issuer签发证书
+	var tls *qav1alpha1.DomainTLS
+	if entity.ConfigMapData.CertManagerIssuer().Issuer != "" {
+		tls = &qav1alpha1.DomainTLS{CertManager: &qav1alpha1.CertManagerTLS{}}
+	}
+	var err error
+	if ingress.Spec.TLS, err = ingressTLS(h.ctx, h.sqbdeployment, ingress.Name, host, tls); err != nil {
+		return err
+	}
 	return CreateOrUpdate(h.ctx, ingress)
 }
 
@@ -219,7 +229,7 @@ func (h *ingressHandler) DeleteForSqbapplication() error {
 		if !h.isAutoIngress(ingress) {
 			continue
 		}
-		if err = Delete(h.ctx, &ingress); err != nil {
+		if err = deleteIngress(h.ctx, &ingress); err != nil {
 			return err
 		}
 	}
@@ -237,15 +247,21 @@ func (h *ingressHandler) DeleteForSqbdeployment() error {
 	if err != nil {
 		return client.IgnoreNotFound(err)
 	}
-	if err = Delete(h.ctx, ingress); err != nil {
+	if err = deleteIngress(h.ctx, ingress); err != nil {
 		return err
 	}
 	return nil
 }
 
+func (h *ingressHandler) Name() string {
+	return "Ingress"
+}
+
+// Handle gateway模式下域名由HTTPRoute代替，删除自动生成的ingress
 func (h *ingressHandler) Handle() error {
+	gatewayMode := entity.ConfigMapData.IsGatewayMode()
 	if h.sqbapplication != nil {
-		if deleted, _ := IsDeleted(h.sqbapplication); deleted || len(h.sqbapplication.Spec.Domains) == 0 {
+		if deleted, _ := IsDeleted(h.sqbapplication); deleted || gatewayMode || len(h.sqbapplication.Spec.Domains) == 0 {
 			return h.DeleteForSqbapplication()
 		}
 		if !IsIngressOpen(h.sqbapplication) {
@@ -254,7 +270,7 @@ func (h *ingressHandler) Handle() error {
 		return h.CreateOrUpdateForSqbapplication()
 	}
 	if h.sqbdeployment != nil {
-		if deleted, _ := IsDeleted(h.sqbdeployment); deleted {
+		if deleted, _ := IsDeleted(h.sqbdeployment); deleted || gatewayMode {
 			return h.DeleteForSqbdeployment()
 		}
 		if h.sqbdeployment.Annotations[entity.PublicEntryAnnotationKey] != "true" {
@@ -265,6 +281,119 @@ func (h *ingressHandler) Handle() error {
 	return nil
 }
 
+// applyIngressClassProfile 使用spec.ingressClassName指定class，按operator配置中class对应的profile设置默认annotation、
+// pathType和屏蔽/metrics的方式，annotations为域名单独配置的annotation，优先级高于profile
+func applyIngressClassProfile(ingress *v1.Ingress, class string, annotations map[string]string) {
+	profile := entity.ConfigMapData.IngressClassProfile(class)
+	ingress.Spec.IngressClassName = &class
+	// 不能同时设置annotation和spec.ingressClassName，去掉旧版本写入的annotation
+	ingress.Annotations = util.MergeStringMap(ingress.Annotations, profile.Annotations)
+	delete(ingress.Annotations, entity.IngressClassAnnotationKey)
+	ingress.Annotations = util.MergeStringMap(ingress.Annotations, annotations)
+	if profile.MetricsBlocking == entity.MetricsBlockingSnippet && profile.Controller == entity.IngressControllerNginx {
+		ingress.Annotations[serverSnippetAnnotationKey] = metricsServerSnippet
+	} else if ingress.Annotations[serverSnippetAnnotationKey] == metricsServerSnippet {
+		delete(ingress.Annotations, serverSnippetAnnotationKey)
+	}
+	pathType := profile.PathType
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		paths := make([]v1.HTTPIngressPath, 0, len(rule.HTTP.Paths)+1)
+		// /metrics转发到不对外暴露指标的backend，backend必须与ingress在同一个namespace
+		if profile.MetricsBlocking == entity.MetricsBlockingBackend && profile.MetricsBackend != nil {
+			prefix := v1.PathTypePrefix
+			paths = append(paths, v1.HTTPIngressPath{
+				Path:     "/metrics",
+				PathType: &prefix,
+				Backend:  v1.IngressBackend{Service: profile.MetricsBackend.DeepCopy()},
+			})
+		}
+		for _, path := range rule.HTTP.Paths {
+			path.PathType = &pathType
+			// Prefix和Exact的path不能为空
+			if path.Path == "" && pathType != v1.PathTypeImplementationSpecific {
+				path.Path = "/"
+			}
+			paths = append(paths, path)
+		}
+		rule.HTTP.Paths = paths
+	}
+}
+
+// ingressTLS 生成ingress的tls配置，cert-manager签发的证书由operator创建Certificate，owner为sqbapplication或sqbdeployment
+func ingressTLS(ctx context.Context, owner runtimeObj, ingressName, host string,
+	tls *qav1alpha1.DomainTLS) ([]v1.IngressTLS, error) {
+	if tls == nil || tls.CertManager == nil {
+		if err := deleteCertificate(ctx, owner.GetNamespace(), ingressName); err != nil {
+			return nil, err
+		}
+		if tls == nil {
+			return nil, nil
+		}
+		return []v1.IngressTLS{{Hosts: []string{host}, SecretName: tls.SecretName}}, nil
+	}
+	// 集群没有开启cert-manager时忽略
+	if !entity.ConfigMapData.IsCertManagerEnable() {
+		return nil, nil
+	}
+	issuer := entity.ConfigMapData.CertManagerIssuer()
+	if tls.CertManager.Issuer != "" {
+		issuer.Issuer = tls.CertManager.Issuer
+	}
+	if tls.CertManager.IssuerKind != "" {
+		issuer.IssuerKind = tls.CertManager.IssuerKind
+	}
+	if issuer.Issuer == "" {
+		return nil, fmt.Errorf("invalid certManager tls of host %s: issuer is not configured", host)
+	}
+	if issuer.IssuerKind == "" {
+		issuer.IssuerKind = "Issuer"
+	}
+	certificate := &certmanagerv1.Certificate{ObjectMeta: metav1.ObjectMeta{Namespace: owner.GetNamespace(), Name: ingressName}}
+	err := k8sclient.Get(ctx, client.ObjectKey{Namespace: certificate.Namespace, Name: certificate.Name}, certificate)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	certificate.Labels = util.MergeStringMap(certificate.Labels, owner.GetLabels())
+	certificate.Spec = certmanagerv1.CertificateSpec{
+		DNSNames:   []string{host},
+		SecretName: ingressName + "-tls",
+		IssuerRef: certmanagerv1.ObjectReference{
+			Name:  issuer.Issuer,
+			Kind:  issuer.IssuerKind,
+			Group: certmanagerv1.SchemeGroupVersion.Group,
+		},
+	}
+	if err = controllerutil.SetControllerReference(owner, certificate, k8sScheme); err != nil {
+		return nil, err
+	}
+	if err = CreateOrUpdate(ctx, certificate); err != nil {
+		return nil, err
+	}
+	return []v1.IngressTLS{{Hosts: []string{host}, SecretName: certificate.Spec.SecretName}}, nil
+}
+
+// deleteIngress 删除ingress以及对应的Certificate
+func deleteIngress(ctx context.Context, ingress *v1.Ingress) error {
+	if err := deleteCertificate(ctx, ingress.Namespace, ingress.Name); err != nil {
+		return err
+	}
+	return Delete(ctx, ingress)
+}
+
+func deleteCertificate(ctx context.Context, namespace, name string) error {
+	if !entity.ConfigMapData.IsCertManagerEnable() {
+		return nil
+	}
+	certificate := &certmanagerv1.Certificate{}
+	if err := k8sclient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, certificate); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return Delete(ctx, certificate)
+}
+
 // GetIngressName, 生成ingress的名称
 func GetIngressName(appName, nginxClass, host string) string {
 	return fmt.Sprintf("%s.%s.%s", appName, nginxClass, host)
@@ -272,16 +401,20 @@ func GetIngressName(appName, nginxClass, host string) string {
 
 // isAutoIngressName 判断一个ingress是否是自动生成的
 func (h *ingressHandler) isAutoIngress(ingress v1.Ingress) bool {
-	if ingress.Annotations == nil || ingress.Annotations[entity.IngressClassAnnotationKey] == "" || len(ingress.Spec.Rules) < 1 {
+	// 旧版本生成的ingress使用annotation指定class
+	class := ingress.Annotations[entity.IngressClassAnnotationKey]
+	if ingress.Spec.IngressClassName != nil {
+		class = *ingress.Spec.IngressClassName
+	}
+	if class == "" || len(ingress.Spec.Rules) < 1 {
 		return false
 	}
 	// 新规则
-	if GetIngressName(h.sqbapplication.Name, ingress.Annotations[entity.IngressClassAnnotationKey],
-		ingress.Spec.Rules[0].Host) == ingress.Name {
+	if GetIngressName(h.sqbapplication.Name, class, ingress.Spec.Rules[0].Host) == ingress.Name {
 		return true
 	}
 	// 老规则
-	if fmt.Sprintf("%s-%s", h.sqbapplication.Name, ingress.Annotations[entity.IngressClassAnnotationKey]) == ingress.Name {
+	if fmt.Sprintf("%s-%s", h.sqbapplication.Name, class) == ingress.Name {
 		return true
 	}
 	return false
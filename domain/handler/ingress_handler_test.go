@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wosai/elastic-env-operator/api/certmanager"
+	certmanagerv1 "github.com/wosai/elastic-env-operator/api/certmanager/v1"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	v1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestIngressTLS(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = qav1alpha1.AddToScheme(scheme)
+	_ = certmanager.AddToScheme(scheme)
+	SetK8sScheme(scheme)
+	SetK8sClient(fake.NewClientBuilder().WithScheme(scheme).Build())
+	defer SetK8sClient(nil)
+	ctx := context.Background()
+	app := &qav1alpha1.SQBApplication{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default", Name: "demo", UID: "uid",
+		Labels: map[string]string{entity.AppKey: "demo"},
+	}}
+	name := GetIngressName("demo", "nginx", "demo.iwosai.com")
+
+	tls, err := ingressTLS(ctx, app, name, "demo.iwosai.com", &qav1alpha1.DomainTLS{SecretName: "wildcard"})
+	assert.Nil(t, err)
+	assert.Equal(t, "wildcard", tls[0].SecretName)
+
+	// 没有开启cert-manager时忽略
+	certManagerTLS := &qav1alpha1.DomainTLS{CertManager: &qav1alpha1.CertManagerTLS{}}
+	tls, err = ingressTLS(ctx, app, name, "demo.iwosai.com", certManagerTLS)
+	assert.Nil(t, err)
+	assert.Nil(t, tls)
+
+	entity.ConfigMapData.FromMap(map[string]string{
+		"operatorDelay":     "0",
+		"certManagerEnable": "true",
+		"certManagerIssuer": `{"issuer":"letsencrypt","issuerKind":"ClusterIssuer"}`,
+	})
+	defer entity.ConfigMapData.FromMap(map[string]string{"operatorDelay": "0"})
+	tls, err = ingressTLS(ctx, app, name, "demo.iwosai.com", certManagerTLS)
+	assert.Nil(t, err)
+	assert.Equal(t, name+"-tls", tls[0].SecretName)
+	certificate := &certmanagerv1.Certificate{}
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, certificate))
+	assert.Equal(t, []string{"demo.iwosai.com"}, certificate.Spec.DNSNames)
+	assert.Equal(t, "letsencrypt", certificate.Spec.IssuerRef.Name)
+	assert.Equal(t, "ClusterIssuer", certificate.Spec.IssuerRef.Kind)
+	assert.Equal(t, "demo", certificate.OwnerReferences[0].Name)
+
+	// 去掉tls后删除Certificate
+	tls, err = ingressTLS(ctx, app, name, "demo.iwosai.com", nil)
+	assert.Nil(t, err)
+	assert.Nil(t, tls)
+	err = k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, certificate)
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestApplyIngressClassProfile(t *testing.T) {
+	newIngress := func() *v1.Ingress {
+		return &v1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{entity.IngressClassAnnotationKey: "nginx"}},
+			Spec: v1.IngressSpec{Rules: []v1.IngressRule{{IngressRuleValue: v1.IngressRuleValue{
+				HTTP: &v1.HTTPIngressRuleValue{Paths: []v1.HTTPIngressPath{{}}}}}}},
+		}
+	}
+	// 没有配置profile时兼容原来的nginx行为
+	ingress := newIngress()
+	applyIngressClassProfile(ingress, "nginx", nil)
+	assert.Equal(t, "nginx", *ingress.Spec.IngressClassName)
+	assert.Equal(t, map[string]string{serverSnippetAnnotationKey: metricsServerSnippet}, ingress.Annotations)
+	assert.Equal(t, v1.PathTypeImplementationSpecific, *ingress.Spec.Rules[0].HTTP.Paths[0].PathType)
+
+	entity.ConfigMapData.FromMap(map[string]string{
+		"operatorDelay": "0",
+		"ingressClassProfiles": `{"alb":{"controller":"alb","annotations":{"alb.ingress.kubernetes.io/scheme":"internet-facing"},
+"metricsBlocking":"backend","metricsBackend":{"name":"deny","port":{"number":80}},"pathType":"Prefix"}}`,
+	})
+	defer entity.ConfigMapData.FromMap(map[string]string{"operatorDelay": "0"})
+	ingress = newIngress()
+	ingress.Annotations[serverSnippetAnnotationKey] = metricsServerSnippet
+	applyIngressClassProfile(ingress, "alb", map[string]string{"alb.ingress.kubernetes.io/scheme": "internal"})
+	assert.Equal(t, map[string]string{"alb.ingress.kubernetes.io/scheme": "internal"}, ingress.Annotations)
+	paths := ingress.Spec.Rules[0].HTTP.Paths
+	assert.Equal(t, 2, len(paths))
+	assert.Equal(t, "/metrics", paths[0].Path)
+	assert.Equal(t, "deny", paths[0].Backend.Service.Name)
+	assert.Equal(t, "/", paths[1].Path)
+	assert.Equal(t, v1.PathTypePrefix, *paths[1].PathType)
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"strconv"
+
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+)
+
+// meshProvider 服务网格的实现，由configmap的meshProvider选择
+type meshProvider interface {
+	Name() string
+	// InjectAnnotations 加到pod上控制sidecar注入的注解
+	InjectAnnotations(inject bool) map[string]string
+	// HasIngressGateway 是否有网格的ingress gateway，有时ingress转发到gateway
+	HasIngressGateway() bool
+	// HeaderRouting 网格是否按x-env-flag请求头路由到各环境，不支持时由ingress-nginx canary ingress路由
+	HeaderRouting() bool
+	// Handlers 开启注入的应用需要生成的网格资源
+	Handlers(sqbapplication *qav1alpha1.SQBApplication, ctx context.Context) []SQBHandler
+}
+
+type istioProvider struct{}
+
+func (p istioProvider) Name() string {
+	return entity.MeshProviderIstio
+}
+
+func (p istioProvider) InjectAnnotations(inject bool) map[string]string {
+	return map[string]string{entity.IstioSidecarInjectKey: strconv.FormatBool(inject)}
+}
+
+func (p istioProvider) HasIngressGateway() bool {
+	return entity.ConfigMapData.HasIstioIngressGateway()
+}
+
+func (p istioProvider) HeaderRouting() bool {
+	return true
+}
+
+func (p istioProvider) Handlers(sqbapplication *qav1alpha1.SQBApplication, ctx context.Context) []SQBHandler {
+	return []SQBHandler{
+		NewDestinationRuleHandler(sqbapplication, ctx),
+		NewVirtualServiceHandler(sqbapplication, ctx),
+	}
+}
+
+// linkerdProvider linkerd通过ServiceProfile配置超时和重试，通过SMI TrafficSplit把应用的service路由到基础环境
+type linkerdProvider struct{}
+
+func (p linkerdProvider) Name() string {
+	return entity.MeshProviderLinkerd
+}
+
+func (p linkerdProvider) InjectAnnotations(inject bool) map[string]string {
+	if inject {
+		return map[string]string{entity.LinkerdInjectKey: "enabled"}
+	}
+	return map[string]string{entity.LinkerdInjectKey: "disabled"}
+}
+
+func (p linkerdProvider) HasIngressGateway() bool {
+	return false
+}
+
+func (p linkerdProvider) HeaderRouting() bool {
+	return false
+}
+
+func (p linkerdProvider) Handlers(sqbapplication *qav1alpha1.SQBApplication, ctx context.Context) []SQBHandler {
+	return []SQBHandler{
+		NewServiceProfileHandler(sqbapplication, ctx),
+		NewTrafficSplitHandler(sqbapplication, ctx),
+	}
+}
+
+func getMeshProvider() meshProvider {
+	if entity.ConfigMapData.MeshProvider() == entity.MeshProviderLinkerd {
+		return linkerdProvider{}
+	}
+	return istioProvider{}
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	linkerdv1alpha2 "github.com/wosai/elastic-env-operator/api/linkerd/v1alpha2"
+	splitv1alpha2 "github.com/wosai/elastic-env-operator/api/smi/split/v1alpha2"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestMeshProvider(t *testing.T) {
+	entity.ConfigMapData.FromMap(map[string]string{"operatorDelay": "0"})
+	defer entity.ConfigMapData.FromMap(map[string]string{"operatorDelay": "0"})
+	provider := getMeshProvider()
+	assert.Equal(t, entity.MeshProviderIstio, provider.Name())
+	assert.Equal(t, map[string]string{entity.IstioSidecarInjectKey: "true"}, provider.InjectAnnotations(true))
+	assert.True(t, provider.HeaderRouting())
+
+	entity.ConfigMapData.FromMap(map[string]string{"operatorDelay": "0", "meshProvider": "linkerd"})
+	provider = getMeshProvider()
+	assert.Equal(t, entity.MeshProviderLinkerd, provider.Name())
+	assert.Equal(t, map[string]string{entity.LinkerdInjectKey: "disabled"}, provider.InjectAnnotations(false))
+	assert.False(t, provider.HasIngressGateway())
+	assert.False(t, provider.HeaderRouting())
+}
+
+func TestLinkerdProvider(t *testing.T) {
+	app := newIstioTestApplication(t)
+	app.Spec.Ports = []corev1.ServicePort{{Name: "http-80", Port: 80}}
+	entity.ConfigMapData.FromMap(map[string]string{
+		"operatorDelay": "0",
+		"istioEnable":   "true",
+		"istioInject":   "true",
+		"istioTimeout":  "10",
+		"meshProvider":  "linkerd",
+	})
+	ctx := context.Background()
+	handlers := getMeshProvider().Handlers(app, ctx)
+	assert.Equal(t, "ServiceProfile", handlers[0].Name())
+	assert.Equal(t, "TrafficSplit", handlers[1].Name())
+	for _, handler := range handlers {
+		assert.Nil(t, handler.Handle())
+	}
+	serviceProfile := &linkerdv1alpha2.ServiceProfile{}
+	spKey := client.ObjectKey{Namespace: "default", Name: "demo.default.svc.cluster.local"}
+	assert.Nil(t, k8sclient.Get(ctx, spKey, serviceProfile))
+	assert.True(t, serviceProfile.Spec.Routes[0].IsRetryable)
+	assert.Equal(t, "10s", serviceProfile.Spec.Routes[1].Timeout)
+
+	trafficSplit := &splitv1alpha2.TrafficSplit{}
+	tsKey := client.ObjectKey{Namespace: "default", Name: "demo"}
+	assert.Nil(t, k8sclient.Get(ctx, tsKey, trafficSplit))
+	assert.Equal(t, "demo", trafficSplit.Spec.Service)
+	assert.Equal(t, []splitv1alpha2.TrafficSplitBackend{
+		{Service: "demo-base", Weight: 100},
+		{Service: "demo-feature"},
+	}, trafficSplit.Spec.Backends)
+
+	// linkerd不能按请求头路由，仍然创建canary ingress，并创建基础环境的service作为TrafficSplit的backend
+	assert.Nil(t, NewCanaryIngressHandler(app, ctx).Handle())
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "demo-base"}, &corev1.Service{}))
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "demo-feature"}, &corev1.Service{}))
+
+	// 关闭注入后删除
+	app.Annotations = map[string]string{entity.IstioInjectAnnotationKey: "false"}
+	for _, handler := range handlers {
+		assert.Nil(t, handler.Handle())
+	}
+	assert.True(t, apierrors.IsNotFound(k8sclient.Get(ctx, spKey, serviceProfile)))
+	assert.True(t, apierrors.IsNotFound(k8sclient.Get(ctx, tsKey, trafficSplit)))
+}
@@ -10,7 +10,6 @@ import (
 	"github.com/wosai/elastic-env-operator/domain/util"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -26,13 +25,13 @@ func NewPVCHandler(sqbdeployment *qav1alpha1.SQBDeployment, ctx context.Context)
 }
 
 func (h *pvcHandler) CreateOrUpdate() error {
-	exists := make(map[string]struct{}, 0)
+	exists := make(map[string]corev1.PersistentVolumeClaim, 0)
 	pvcList, err := h.getPVCList()
 	if err != nil {
 		return err
 	}
 	for _, pvc := range pvcList.Items {
-		exists[pvc.Name] = struct{}{}
+		exists[pvc.Name] = pvc
 	}
 	for _, volumespec := range h.sqbdeployment.Spec.Volumes {
 		if !volumespec.PersistentVolumeClaim {
@@ -40,11 +39,14 @@ func (h *pvcHandler) CreateOrUpdate() error {
 		}
 		pvcName := volumespec.PersistentVolumeClaimName
 		if pvcName == "" {
-			pvcName = h.getPVCName(volumespec.MountPath)
+			pvcName = getPVCName(h.sqbdeployment, volumespec.MountPath)
 			volumespec.PersistentVolumeClaimName = pvcName
 		}
-		if _, ok := exists[pvcName]; ok {
+		if pvc, ok := exists[pvcName]; ok {
 			delete(exists, pvcName)
+			if err = h.update(&pvc, volumespec); err != nil {
+				return err
+			}
 			continue
 		}
 		pvc := &corev1.PersistentVolumeClaim{
@@ -58,49 +60,133 @@ func (h *pvcHandler) CreateOrUpdate() error {
 			if !apierrors.IsNotFound(err) {
 				return err
 			}
-			pvc.Spec = corev1.PersistentVolumeClaimSpec{
-				AccessModes: []corev1.PersistentVolumeAccessMode{
-					corev1.ReadWriteMany,
-				},
-				Resources: corev1.ResourceRequirements{
-					Requests: corev1.ResourceList{
-						corev1.ResourceStorage: resource.MustParse("2Gi"),
-					},
-				},
-			}
-			group := h.sqbdeployment.Labels[entity.GroupKey]
-			if group == "" {
-				pvc.Spec.StorageClassName = proto.String("ack-qa")
-			} else {
-				pvc.Spec.StorageClassName = proto.String("ack" + "-" + group)
+			pvc.Spec = h.getPVCSpec(volumespec)
+			if err = h.cloneFrom(pvc, volumespec); err != nil {
+				return err
 			}
 			pvc.Labels = util.MergeStringMap(pvc.Labels, h.sqbdeployment.Labels)
+			pvc.Annotations = map[string]string{entity.RetentionPolicyAnnotationKey: string(retentionPolicy(volumespec))}
 			if err = CreateOrUpdate(h.ctx, pvc); err != nil {
 				return err
 			}
+			continue
 		}
-	}
-	for pvcName := range exists {
-		pvc := &corev1.PersistentVolumeClaim{
-			ObjectMeta: metav1.ObjectMeta{
-				Namespace: h.sqbdeployment.Namespace,
-				Name:      pvcName,
-			},
+		if err = h.update(pvc, volumespec); err != nil {
+			return err
 		}
-		if err = Delete(h.ctx, pvc); err != nil {
+	}
+	for _, pvc := range exists {
+		if err = h.delete(&pvc); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// getPVCSpec volume没有配置的字段使用operator配置中的默认值
+func (h *pvcHandler) getPVCSpec(volumespec *qav1alpha1.VolumeSpec) corev1.PersistentVolumeClaimSpec {
+	size, storageClassName, accessModes, volumeMode := entity.ConfigMapData.PVCDefaults(h.sqbdeployment.Labels[entity.GroupKey])
+	if volumespec.Size != nil {
+		size = volumespec.Size.DeepCopy()
+	}
+	if volumespec.StorageClassName != nil {
+		storageClassName = proto.String(*volumespec.StorageClassName)
+	}
+	if len(volumespec.AccessModes) != 0 {
+		accessModes = volumespec.AccessModes
+	}
+	if volumespec.VolumeMode != nil {
+		mode := *volumespec.VolumeMode
+		volumeMode = &mode
+	}
+	return corev1.PersistentVolumeClaimSpec{
+		AccessModes: accessModes,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceStorage: size,
+			},
+		},
+		StorageClassName: storageClassName,
+		VolumeMode:       volumeMode,
+	}
+}
+
+// cloneFrom 特性环境新建PVC时以基础环境同一个mountPath的PVC作为dataSource，基础环境的PVC不存在时创建空的PVC
+func (h *pvcHandler) cloneFrom(pvc *corev1.PersistentVolumeClaim, volumespec *qav1alpha1.VolumeSpec) error {
+	base := entity.ConfigMapData.BaseFlag()
+	if volumespec.CloneFrom != qav1alpha1.CloneFromBase || h.sqbdeployment.Labels[entity.PlaneKey] == base {
+		return nil
+	}
+	sqbdeploymentList := &qav1alpha1.SQBDeploymentList{}
+	err := k8sclient.List(h.ctx, sqbdeploymentList, client.InNamespace(h.sqbdeployment.Namespace),
+		client.MatchingLabels{entity.AppKey: h.sqbdeployment.Labels[entity.AppKey], entity.PlaneKey: base})
+	if err != nil || len(sqbdeploymentList.Items) == 0 {
+		return err
+	}
+	source := &corev1.PersistentVolumeClaim{}
+	err = k8sclient.Get(h.ctx, client.ObjectKey{Namespace: h.sqbdeployment.Namespace,
+		Name: getPVCName(&sqbdeploymentList.Items[0], volumespec.MountPath)}, source)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	pvc.Spec.DataSource = &corev1.TypedLocalObjectReference{
+		Kind: "PersistentVolumeClaim",
+		Name: source.Name,
+	}
+	// 复制出来的PVC不能小于源PVC
+	sourceSize := source.Spec.Resources.Requests[corev1.ResourceStorage]
+	if sourceSize.Cmp(pvc.Spec.Resources.Requests[corev1.ResourceStorage]) > 0 {
+		pvc.Spec.Resources.Requests[corev1.ResourceStorage] = sourceSize
+	}
+	return nil
+}
+
+// update 同步PVC的保留策略，volume配置了size时在线扩容，PVC不支持缩容。
+// 从基础环境复制的PVC创建时会调整为源PVC的大小，size小于当前大小时保持不变
+func (h *pvcHandler) update(pvc *corev1.PersistentVolumeClaim, volumespec *qav1alpha1.VolumeSpec) error {
+	changed := false
+	if policy := string(retentionPolicy(volumespec)); pvc.Annotations[entity.RetentionPolicyAnnotationKey] != policy {
+		pvc.Annotations = util.MergeStringMap(pvc.Annotations, map[string]string{entity.RetentionPolicyAnnotationKey: policy})
+		changed = true
+	}
+	if volumespec.Size != nil {
+		current := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		switch volumespec.Size.Cmp(current) {
+		case -1:
+			if pvc.Spec.DataSource != nil {
+				break
+			}
+			return fmt.Errorf("invalid size %s of volume %s: pvc %s is already %s and can not be shrunk",
+				volumespec.Size.String(), volumespec.MountPath, pvc.Name, current.String())
+		case 1:
+			if pvc.Spec.Resources.Requests == nil {
+				pvc.Spec.Resources.Requests = corev1.ResourceList{}
+			}
+			pvc.Spec.Resources.Requests[corev1.ResourceStorage] = volumespec.Size.DeepCopy()
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return CreateOrUpdate(h.ctx, pvc)
+}
+
+// delete 保留策略为Retain的PVC不删除
+func (h *pvcHandler) delete(pvc *corev1.PersistentVolumeClaim) error {
+	if pvc.Annotations[entity.RetentionPolicyAnnotationKey] == string(qav1alpha1.PVCRetentionPolicyRetain) {
+		return nil
+	}
+	return Delete(h.ctx, pvc)
+}
+
 func (h *pvcHandler) Delete() error {
 	pvcList, err := h.getPVCList()
 	if err != nil {
 		return err
 	}
 	for _, pvc := range pvcList.Items {
-		if Delete(h.ctx, &pvc) != nil {
+		if err = h.delete(&pvc); err != nil {
 			return err
 		}
 	}
@@ -121,9 +207,21 @@ func (h *pvcHandler) getPVCList() (*corev1.PersistentVolumeClaimList, error) {
 	return pvcList, nil
 }
 
-func (h *pvcHandler) getPVCName(mountPath string) string {
-	hash := md5.Sum([]byte(mountPath + h.sqbdeployment.CreationTimestamp.String()))
-	return h.sqbdeployment.Labels[entity.AppKey] + "-" + h.sqbdeployment.Labels[entity.PlaneKey] + "-" + fmt.Sprintf("%x", hash)
+// getPVCName 名字包含sqbdeployment的创建时间，重新创建sqbdeployment后生成新的PVC，保留的PVC不会被复用，需要手动清理
+func getPVCName(sqbdeployment *qav1alpha1.SQBDeployment, mountPath string) string {
+	hash := md5.Sum([]byte(mountPath + sqbdeployment.CreationTimestamp.String()))
+	return sqbdeployment.Labels[entity.AppKey] + "-" + sqbdeployment.Labels[entity.PlaneKey] + "-" + fmt.Sprintf("%x", hash)
+}
+
+func retentionPolicy(volumespec *qav1alpha1.VolumeSpec) qav1alpha1.PVCRetentionPolicy {
+	if volumespec.RetentionPolicy == "" {
+		return qav1alpha1.PVCRetentionPolicyDelete
+	}
+	return volumespec.RetentionPolicy
+}
+
+func (h *pvcHandler) Name() string {
+	return "PVC"
 }
 
 func (h *pvcHandler) Handle() error {
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGetPVCSpec(t *testing.T) {
+	entity.ConfigMapData.FromMap(map[string]string{
+		"operatorDelay": "0",
+		"pvcDefaults":   `{"size":"5Gi","groupStorageClassNames":{"crm":"ack-crm"}}`,
+	})
+	defer entity.ConfigMapData.FromMap(map[string]string{"operatorDelay": "0"})
+
+	h := &pvcHandler{sqbdeployment: &qav1alpha1.SQBDeployment{ObjectMeta: metav1.ObjectMeta{
+		Labels: map[string]string{entity.GroupKey: "crm"},
+	}}}
+	spec := h.getPVCSpec(&qav1alpha1.VolumeSpec{MountPath: "/data", PersistentVolumeClaim: true})
+	assert.Equal(t, "5Gi", spec.Resources.Requests.Storage().String())
+	assert.Equal(t, "ack-crm", *spec.StorageClassName)
+	assert.Equal(t, []v1.PersistentVolumeAccessMode{v1.ReadWriteMany}, spec.AccessModes)
+
+	size := resource.MustParse("20Gi")
+	spec = h.getPVCSpec(&qav1alpha1.VolumeSpec{
+		MountPath:             "/data",
+		PersistentVolumeClaim: true,
+		Size:                  &size,
+		StorageClassName:      proto.String("ssd"),
+		AccessModes:           []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+	})
+	assert.Equal(t, "20Gi", spec.Resources.Requests.Storage().String())
+	assert.Equal(t, "ssd", *spec.StorageClassName)
+	assert.Equal(t, []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce}, spec.AccessModes)
+}
+
+func TestUpdateShrink(t *testing.T) {
+	h := &pvcHandler{sqbdeployment: &qav1alpha1.SQBDeployment{}}
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "demo-base-data",
+			Annotations: map[string]string{entity.RetentionPolicyAnnotationKey: "Delete"},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("10Gi")},
+			},
+		},
+	}
+	size := resource.MustParse("10Gi")
+	assert.Nil(t, h.update(pvc, &qav1alpha1.VolumeSpec{MountPath: "/data", Size: &size}))
+
+	size = resource.MustParse("5Gi")
+	err := h.update(pvc, &qav1alpha1.VolumeSpec{MountPath: "/data", Size: &size})
+	assert.EqualError(t, err, "invalid size 5Gi of volume /data: pvc demo-base-data is already 10Gi and can not be shrunk")
+}
+
+func TestRetentionAndClone(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = qav1alpha1.AddToScheme(scheme)
+	labels := map[string]string{entity.AppKey: "demo", entity.PlaneKey: "base"}
+	base := &qav1alpha1.SQBDeployment{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default", Name: "demo-base", Labels: labels,
+		CreationTimestamp: metav1.NewTime(time.Unix(1600000000, 0)),
+	}}
+	newPVC := func(name, policy string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: labels,
+				Annotations: map[string]string{entity.RetentionPolicyAnnotationKey: policy}},
+			Spec: v1.PersistentVolumeClaimSpec{Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("8Gi")},
+			}},
+		}
+	}
+	SetK8sScheme(scheme)
+	entity.ConfigMapData.FromMap(map[string]string{"operatorDelay": "0"})
+	SetK8sClient(fake.NewClientBuilder().WithScheme(scheme).WithObjects(base,
+		newPVC(getPVCName(base, "/data"), "Retain"), newPVC("demo-base-cache", "Delete")).Build())
+	defer SetK8sClient(nil)
+
+	// 基础环境删除时只删除保留策略为Delete的PVC
+	assert.Nil(t, (&pvcHandler{sqbdeployment: base, ctx: context.Background()}).Delete())
+	pvcList := &v1.PersistentVolumeClaimList{}
+	assert.Nil(t, k8sclient.List(context.Background(), pvcList))
+	assert.Equal(t, 1, len(pvcList.Items))
+	assert.Equal(t, getPVCName(base, "/data"), pvcList.Items[0].Name)
+
+	// 特性环境从基础环境复制数据，大小不小于源PVC
+	feature := &qav1alpha1.SQBDeployment{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default", Name: "demo-feature",
+		Labels: map[string]string{entity.AppKey: "demo", entity.PlaneKey: "feature"},
+	}}
+	h := &pvcHandler{sqbdeployment: feature, ctx: context.Background()}
+	volumespec := &qav1alpha1.VolumeSpec{MountPath: "/data", PersistentVolumeClaim: true, CloneFrom: qav1alpha1.CloneFromBase}
+	pvc := &v1.PersistentVolumeClaim{Spec: h.getPVCSpec(volumespec)}
+	assert.Nil(t, h.cloneFrom(pvc, volumespec))
+	assert.Equal(t, getPVCName(base, "/data"), pvc.Spec.DataSource.Name)
+	assert.Equal(t, "8Gi", pvc.Spec.Resources.Requests.Storage().String())
+
+	volumespec.MountPath = "/other"
+	pvc = &v1.PersistentVolumeClaim{Spec: h.getPVCSpec(volumespec)}
+	assert.Nil(t, h.cloneFrom(pvc, volumespec))
+	assert.Nil(t, pvc.Spec.DataSource)
+
+	// size小于源PVC时，复制出来的PVC再次调和不会被当作缩容
+	size := resource.MustParse("5Gi")
+	feature.Spec.Volumes = []*qav1alpha1.VolumeSpec{
+		{MountPath: "/data", PersistentVolumeClaim: true, CloneFrom: qav1alpha1.CloneFromBase, Size: &size},
+	}
+	assert.Nil(t, h.CreateOrUpdate())
+	assert.Nil(t, h.CreateOrUpdate())
+	assert.Nil(t, k8sclient.Get(context.Background(), client.ObjectKey{Namespace: "default",
+		Name: getPVCName(feature, "/data")}, pvc))
+	assert.Equal(t, "8Gi", pvc.Spec.Resources.Requests.Storage().String())
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"github.com/gogo/protobuf/proto"
 	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
 	"github.com/wosai/elastic-env-operator/domain/entity"
 	"github.com/wosai/elastic-env-operator/domain/util"
@@ -28,7 +29,7 @@ func NewServiceHandler(sqbapplication *qav1alpha1.SQBApplication, ctx context.Co
 }
 
 func NewGrayServiceHandler(sqbdeployment *qav1alpha1.SQBDeployment, ctx context.Context) SQBHandler {
-	return &grayServiceHandler{sqbdeployment: sqbdeployment, ctx: ctx, plane: "gray"}
+	return &grayServiceHandler{sqbdeployment: sqbdeployment, ctx: ctx, plane: qav1alpha1.CanaryPlane}
 }
 
 func (h *serviceHandler) CreateOrUpdate() error {
@@ -37,10 +38,22 @@ func (h *serviceHandler) CreateOrUpdate() error {
 	if err != nil && !apierrors.IsNotFound(err) {
 		return err
 	}
-	service.Spec.Ports = h.sqbapplication.Spec.Ports
+	if service, err = recreateIfHeadlessChanged(h.ctx, service, h.sqbapplication.Spec.Service); err != nil {
+		return err
+	}
+	service.Spec.Ports = preserveNodePorts(service.Spec.Ports, h.sqbapplication.Spec.Ports)
 	// 兼容线上的配置，因为pod的label不能更改，所以service的selector也不能更改
 	service.Spec.Selector = util.MergeStringMap(map[string]string{entity.AppKey: h.sqbapplication.Name},
 		service.Spec.Selector)
+	color, err := h.getBaseActiveColor()
+	if err != nil {
+		return err
+	}
+	if color != "" {
+		service.Spec.Selector[entity.ColorKey] = color
+	} else {
+		delete(service.Spec.Selector, entity.ColorKey)
+	}
 	if anno, ok := h.sqbapplication.Annotations[entity.ServiceAnnotationKey]; ok {
 		service.Annotations = make(map[string]string)
 		_ = json.Unmarshal([]byte(anno), &service.Annotations)
@@ -48,6 +61,7 @@ func (h *serviceHandler) CreateOrUpdate() error {
 		service.Annotations = nil
 	}
 	service.Labels = util.MergeStringMap(service.Labels, h.sqbapplication.Labels)
+	applyServiceConfig(service, h.sqbapplication.Spec.Service)
 	// 如果是线上配置，selector需要加上version：base， label需要加上base
 	//if entity.ConfigMapData.Env() == entity.ENV_PROD {
 	//	service.Spec.Selector[entity.PlaneKey] = entity.ConfigMapData.BaseFlag()
@@ -58,11 +72,109 @@ func (h *serviceHandler) CreateOrUpdate() error {
 	return CreateOrUpdate(h.ctx, service)
 }
 
+// getBaseActiveColor 基础环境蓝绿发布时应用的service只选择接收流量的颜色。
+// 开启网格注入时service需要选择所有环境的pod，由DestinationRule的subset按颜色选择
+func (h *serviceHandler) getBaseActiveColor() (string, error) {
+	if IsMeshInject(h.sqbapplication) {
+		return "", nil
+	}
+	sqbdeployments, err := getSqbdeployments(h.ctx, h.sqbapplication)
+	if err != nil {
+		return "", err
+	}
+	return getActiveColors(sqbdeployments)[entity.ConfigMapData.BaseFlag()], nil
+}
+
+// recreateIfHeadlessChanged clusterIP不能修改，headless和其他类型互相切换时删除service后重新创建
+func recreateIfHeadlessChanged(ctx context.Context, service *corev1.Service,
+	config *qav1alpha1.ServiceConfig) (*corev1.Service, error) {
+	if config == nil || service.CreationTimestamp.IsZero() {
+		return service, nil
+	}
+	headless := config.Type == qav1alpha1.ServiceTypeHeadless
+	if (service.Spec.ClusterIP == corev1.ClusterIPNone) == headless {
+		return service, nil
+	}
+	if err := Delete(ctx, service); err != nil {
+		return nil, err
+	}
+	return &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   service.Namespace,
+		Name:        service.Name,
+		Labels:      service.Labels,
+		Annotations: service.Annotations,
+	}, Spec: corev1.ServiceSpec{Selector: service.Spec.Selector}}, nil
+}
+
+// preserveNodePorts 没有指定nodePort的端口沿用已经分配的nodePort，避免每次更新重新分配
+func preserveNodePorts(current, ports []corev1.ServicePort) []corev1.ServicePort {
+	nodePorts := make(map[string]int32)
+	for _, port := range current {
+		nodePorts[port.Name] = port.NodePort
+	}
+	result := make([]corev1.ServicePort, 0, len(ports))
+	for _, port := range ports {
+		if port.NodePort == 0 {
+			port.NodePort = nodePorts[port.Name]
+		}
+		result = append(result, port)
+	}
+	return result
+}
+
+// applyServiceConfig 根据service配置设置类型、会话保持等，没有配置时保留service上已有的配置
+func applyServiceConfig(service *corev1.Service, config *qav1alpha1.ServiceConfig) {
+	if config == nil {
+		return
+	}
+	switch config.Type {
+	case qav1alpha1.ServiceTypeHeadless:
+		service.Spec.Type = corev1.ServiceTypeClusterIP
+		service.Spec.ClusterIP = corev1.ClusterIPNone
+		service.Spec.ClusterIPs = []string{corev1.ClusterIPNone}
+	case "":
+		service.Spec.Type = corev1.ServiceTypeClusterIP
+	default:
+		service.Spec.Type = corev1.ServiceType(config.Type)
+	}
+	if service.Spec.Type == corev1.ServiceTypeNodePort || service.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		service.Spec.ExternalTrafficPolicy = config.ExternalTrafficPolicy
+	} else {
+		service.Spec.ExternalTrafficPolicy = ""
+		service.Spec.HealthCheckNodePort = 0
+		for i := range service.Spec.Ports {
+			service.Spec.Ports[i].NodePort = 0
+		}
+	}
+	service.Spec.SessionAffinity = config.SessionAffinity
+	service.Spec.SessionAffinityConfig = nil
+	if config.SessionAffinity == corev1.ServiceAffinityClientIP && config.SessionAffinityTimeoutSeconds != nil {
+		service.Spec.SessionAffinityConfig = &corev1.SessionAffinityConfig{
+			ClientIP: &corev1.ClientIPConfig{TimeoutSeconds: proto.Int32(*config.SessionAffinityTimeoutSeconds)},
+		}
+	}
+	if len(config.IPFamilies) != 0 {
+		service.Spec.IPFamilies = config.IPFamilies
+	}
+	if config.IPFamilyPolicy != nil {
+		policy := *config.IPFamilyPolicy
+		service.Spec.IPFamilyPolicy = &policy
+	}
+	service.Labels = util.MergeStringMap(service.Labels, config.Labels)
+	if len(config.Annotations) != 0 {
+		service.Annotations = util.MergeStringMap(service.Annotations, config.Annotations)
+	}
+}
+
 func (h *serviceHandler) Delete() error {
 	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: h.sqbapplication.Namespace, Name: h.sqbapplication.Name}}
 	return Delete(h.ctx, service)
 }
 
+func (h *serviceHandler) Name() string {
+	return "Service"
+}
+
 func (h *serviceHandler) Handle() error {
 	if deleted, _ := IsDeleted(h.sqbapplication); deleted {
 		return h.Delete()
@@ -85,12 +197,16 @@ func (h *grayServiceHandler) CreateOrUpdate() error {
 	if err != nil && !apierrors.IsNotFound(err) {
 		return err
 	}
-	service.Spec.Ports = sqbapplication.Spec.Ports
+	if service, err = recreateIfHeadlessChanged(h.ctx, service, sqbapplication.Spec.Service); err != nil {
+		return err
+	}
+	service.Spec.Ports = preserveNodePorts(service.Spec.Ports, sqbapplication.Spec.Ports)
 	service.Spec.Selector = map[string]string{
 		entity.AppKey:   sqbapplication.Name,
 		entity.PlaneKey: h.plane,
 	}
 	service.Labels = util.MergeStringMap(service.Labels, sqbapplication.Labels)
+	applyServiceConfig(service, sqbapplication.Spec.Service)
 	service.Labels[entity.PlaneKey] = h.plane
 	return CreateOrUpdate(h.ctx, service)
 }
@@ -100,6 +216,10 @@ func (h *grayServiceHandler) Delete() error {
 	return Delete(h.ctx, service)
 }
 
+func (h *grayServiceHandler) Name() string {
+	return "GrayService"
+}
+
 func (h *grayServiceHandler) Handle() error {
 	if deleted, _ := IsDeleted(h.sqbdeployment); deleted {
 		return h.Delete()
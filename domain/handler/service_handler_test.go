@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestApplyServiceConfig(t *testing.T) {
+	service := &v1.Service{Spec: v1.ServiceSpec{
+		Type:                  v1.ServiceTypeNodePort,
+		ExternalTrafficPolicy: v1.ServiceExternalTrafficPolicyTypeLocal,
+		Ports:                 []v1.ServicePort{{Name: "http-80", Port: 80, NodePort: 30080}},
+	}}
+	// 没有配置时保留已有的配置
+	applyServiceConfig(service, nil)
+	assert.Equal(t, v1.ServiceTypeNodePort, service.Spec.Type)
+
+	applyServiceConfig(service, &qav1alpha1.ServiceConfig{
+		SessionAffinity:               v1.ServiceAffinityClientIP,
+		SessionAffinityTimeoutSeconds: proto.Int32(600),
+		Labels:                        map[string]string{"team": "qa"},
+	})
+	assert.Equal(t, v1.ServiceTypeClusterIP, service.Spec.Type)
+	assert.Equal(t, v1.ServiceExternalTrafficPolicyType(""), service.Spec.ExternalTrafficPolicy)
+	assert.Equal(t, int32(0), service.Spec.Ports[0].NodePort)
+	assert.Equal(t, int32(600), *service.Spec.SessionAffinityConfig.ClientIP.TimeoutSeconds)
+	assert.Equal(t, "qa", service.Labels["team"])
+
+	applyServiceConfig(service, &qav1alpha1.ServiceConfig{Type: qav1alpha1.ServiceTypeHeadless})
+	assert.Equal(t, v1.ClusterIPNone, service.Spec.ClusterIP)
+	assert.Nil(t, service.Spec.SessionAffinityConfig)
+}
+
+func TestPreserveNodePorts(t *testing.T) {
+	current := []v1.ServicePort{{Name: "http-80", Port: 80, NodePort: 30080}}
+	ports := []v1.ServicePort{{Name: "http-80", Port: 80}, {Name: "grpc-9090", Port: 9090}}
+	result := preserveNodePorts(current, ports)
+	assert.Equal(t, int32(30080), result[0].NodePort)
+	assert.Equal(t, int32(0), result[1].NodePort)
+	// 不修改sqbapplication中的ports
+	assert.Equal(t, int32(0), ports[0].NodePort)
+}
@@ -49,6 +49,10 @@ func (h *serviceMonitorHandler) Delete() error {
 	return Delete(h.ctx, service)
 }
 
+func (h *serviceMonitorHandler) Name() string {
+	return "ServiceMonitor"
+}
+
 func (h *serviceMonitorHandler) Handle() error {
 	if !entity.ConfigMapData.IsServiceMonitorEnable() {
 		return nil
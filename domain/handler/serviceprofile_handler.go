@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	linkerdv1alpha2 "github.com/wosai/elastic-env-operator/api/linkerd/v1alpha2"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	"github.com/wosai/elastic-env-operator/domain/util"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// serviceProfileHandler linkerd按ServiceProfile配置应用service的超时和重试
+type serviceProfileHandler struct {
+	sqbapplication *qav1alpha1.SQBApplication
+	ctx            context.Context
+}
+
+func NewServiceProfileHandler(sqbapplication *qav1alpha1.SQBApplication, ctx context.Context) *serviceProfileHandler {
+	return &serviceProfileHandler{sqbapplication: sqbapplication, ctx: ctx}
+}
+
+// CreateOrUpdate GET请求可以重试，超时时间使用应用流量策略中的timeout，没有配置时为istioTimeout
+func (h *serviceProfileHandler) CreateOrUpdate() error {
+	serviceProfile := &linkerdv1alpha2.ServiceProfile{ObjectMeta: metav1.ObjectMeta{
+		Namespace: h.sqbapplication.Namespace,
+		Name:      getServiceProfileName(h.sqbapplication),
+	}}
+	err := k8sclient.Get(h.ctx, client.ObjectKey{Namespace: serviceProfile.Namespace, Name: serviceProfile.Name}, serviceProfile)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	timeout := meshTimeout(h.sqbapplication.Spec.TrafficPolicy)
+	serviceProfile.Spec = linkerdv1alpha2.ServiceProfileSpec{
+		Routes: []*linkerdv1alpha2.RouteSpec{
+			{
+				Name:        http.MethodGet,
+				Condition:   &linkerdv1alpha2.RequestMatch{PathRegex: ".*", Method: http.MethodGet},
+				IsRetryable: true,
+				Timeout:     timeout,
+			},
+			{
+				Name:      "default",
+				Condition: &linkerdv1alpha2.RequestMatch{PathRegex: ".*"},
+				Timeout:   timeout,
+			},
+		},
+		RetryBudget: &linkerdv1alpha2.RetryBudget{
+			RetryRatio:          0.2,
+			MinRetriesPerSecond: 10,
+			TTL:                 "10s",
+		},
+	}
+	serviceProfile.Labels = util.MergeStringMap(serviceProfile.Labels, h.sqbapplication.Labels)
+	return CreateOrUpdate(h.ctx, serviceProfile)
+}
+
+func (h *serviceProfileHandler) Delete() error {
+	serviceProfile := &linkerdv1alpha2.ServiceProfile{}
+	err := k8sclient.Get(h.ctx, client.ObjectKey{Namespace: h.sqbapplication.Namespace, Name: getServiceProfileName(h.sqbapplication)}, serviceProfile)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return Delete(h.ctx, serviceProfile)
+}
+
+func (h *serviceProfileHandler) Name() string {
+	return "ServiceProfile"
+}
+
+// Handle 集群没有安装网格时不处理，应用关闭注入后删除
+func (h *serviceProfileHandler) Handle() error {
+	if !entity.ConfigMapData.IstioEnable() {
+		return nil
+	}
+	if deleted, _ := IsDeleted(h.sqbapplication); deleted || !IsMeshInject(h.sqbapplication) {
+		return h.Delete()
+	}
+	return h.CreateOrUpdate()
+}
+
+// getServiceProfileName linkerd要求ServiceProfile的名字为service的FQDN
+func getServiceProfileName(sqbapplication *qav1alpha1.SQBApplication) string {
+	return fmt.Sprintf("%s.%s.svc.cluster.local", sqbapplication.Name, sqbapplication.Namespace)
+}
@@ -2,6 +2,8 @@ package handler
 
 import (
 	"context"
+	"reflect"
+
 	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
 	"github.com/wosai/elastic-env-operator/domain/entity"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -32,6 +34,13 @@ func (h *sqbApplicationHandler) Operate(obj runtimeObj) error {
 	if err != nil {
 		return err
 	}
+	status := in.Status.DeepCopy()
+	if deleted {
+		markDeleting(&in.Status.Conditions, in.Generation)
+		if err = UpdateStatus(h.ctx, in); err != nil {
+			return err
+		}
+	}
 	// 补充默认值
 	for i, domain := range in.Spec.Domains {
 		if domain.Host == "" {
@@ -43,23 +52,27 @@ func (h *sqbApplicationHandler) Operate(obj runtimeObj) error {
 	handlers := []SQBHandler{
 		NewServiceHandler(in, h.ctx),
 		NewSqbapplicationIngressHandler(in, h.ctx),
-		//NewDestinationRuleHandler(in, h.ctx),
-		//NewVirtualServiceHandler(in, h.ctx),
+		NewCanaryIngressHandler(in, h.ctx),
+		NewSqbapplicationHTTPRouteHandler(in, h.ctx),
+	}
+	handlers = append(handlers, getMeshProvider().Handlers(in, h.ctx)...)
+	handlers = append(handlers,
 		//NewServiceMonitorHandler(in, h.ctx),
 		NewSqbDeploymentListHandlerForSqbapplication(in, h.ctx),
 		NewVMServiceScrapeHandler(in, h.ctx),
-	}
+	)
 
-	for _, handler := range handlers {
-		if err := handler.Handle(); err != nil {
-			return err
-		}
+	if err = handleAll(handlers); err != nil {
+		return err
 	}
 
 	if deleted {
 		return Delete(h.ctx, in)
-	} else if in.Status.ErrorInfo != "" {
-		in.Status.ErrorInfo = ""
+	}
+	markReconcileSuccess(&in.Status.Conditions, in.Generation)
+	markReady(&in.Status.Conditions, in.Generation)
+	in.Status.ObservedGeneration = in.Generation
+	if !reflect.DeepEqual(status, &in.Status) {
 		return UpdateStatus(h.ctx, in)
 	}
 	return nil
@@ -67,15 +80,16 @@ func (h *sqbApplicationHandler) Operate(obj runtimeObj) error {
 
 func (h *sqbApplicationHandler) ReconcileFail(obj runtimeObj, err error) {
 	in := obj.(*qav1alpha1.SQBApplication)
-	in.Status.ErrorInfo = err.Error()
+	markReconcileFail(&in.Status.Conditions, in.Generation, err)
+	in.Status.ObservedGeneration = in.Generation
 	_ = UpdateStatus(h.ctx, in)
 }
 
-// 判断应用是否启用istio逻辑：
-// 1.如果集群装了istio且有注解，根据注解
-// 2.如果集群装了istio但没有注解，根据集群默认配置
-// 3.如果集群没有装istio，不启用istio
-func IsIstioInject(sqbapplication *qav1alpha1.SQBApplication) bool {
+// 判断应用是否启用网格注入逻辑(istioEnable和istioInject对meshProvider选择的网格生效)：
+// 1.如果集群装了网格且有注解，根据注解
+// 2.如果集群装了网格但没有注解，根据集群默认配置
+// 3.如果集群没有装网格，不启用注入
+func IsMeshInject(sqbapplication *qav1alpha1.SQBApplication) bool {
 	if entity.ConfigMapData.IstioEnable() {
 		if istioInject, ok := sqbapplication.Annotations[entity.IstioInjectAnnotationKey]; ok {
 			return istioInject == "true"
@@ -85,8 +99,9 @@ func IsIstioInject(sqbapplication *qav1alpha1.SQBApplication) bool {
 	return false
 }
 
-func HasIstioIngressGateway() bool {
-	return entity.ConfigMapData.HasIstioIngressGateway()
+// HasMeshIngressGateway 网格是否有ingress gateway，linkerd没有
+func HasMeshIngressGateway() bool {
+	return getMeshProvider().HasIngressGateway()
 }
 
 // 判断应用是否启用ingress逻辑：
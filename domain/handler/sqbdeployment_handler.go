@@ -2,6 +2,8 @@ package handler
 
 import (
 	"context"
+	"reflect"
+	"time"
 
 	"k8s.io/client-go/tools/cache"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -15,6 +17,8 @@ import (
 type sqbDeploymentHandler struct {
 	req ctrl.Request
 	ctx context.Context
+	// requeueAfter 灰度发布的步骤暂停结束或蓝绿发布的旧版本需要缩容时再次调和
+	requeueAfter time.Duration
 }
 
 func NewSqbDeploymentHandler(req ctrl.Request, ctx context.Context, indexer cache.Indexer) SQBReconciler {
@@ -47,7 +51,9 @@ func (h *sqbDeploymentHandler) IsInitialized(obj runtimeObj) (bool, error) {
 		in.Annotations = make(map[string]string)
 	}
 	in.Annotations[entity.InitializeAnnotationKey] = "true"
-	if IsIstioInject(sqbapplication) {
+	// 没有经过webhook创建的sqbdeployment，在这里补充plane和label
+	in.Default()
+	if IsMeshInject(sqbapplication) {
 		in.Annotations[entity.IstioInjectAnnotationKey] = "true"
 	} else {
 		in.Annotations[entity.IstioInjectAnnotationKey] = "false"
@@ -62,6 +68,25 @@ func (h *sqbDeploymentHandler) Operate(obj runtimeObj) error {
 	if err != nil {
 		return err
 	}
+	status := in.Status.DeepCopy()
+	if deleted {
+		markDeleting(&in.Status.Conditions, in.Generation)
+		if err = UpdateStatus(h.ctx, in); err != nil {
+			return err
+		}
+	}
+
+	if !deleted {
+		// 后续handler都使用继承了sqbapplication配置之后生效的deploy配置
+		sqbapplication := &qav1alpha1.SQBApplication{}
+		if err = k8sclient.Get(h.ctx, client.ObjectKey{Namespace: in.Namespace, Name: in.Spec.Selector.App},
+			sqbapplication); err != nil {
+			return err
+		}
+		in.Spec.DeploySpec = in.EffectiveDeploySpec(sqbapplication)
+		in.Status.ApplicationGeneration = sqbapplication.Generation
+		in.Status.Effective = qav1alpha1.NewEffectiveDeployStatus(in.Spec.DeploySpec)
+	}
 
 	handlers := []SQBHandler{
 		NewPVCHandler(in, h.ctx),
@@ -69,27 +94,51 @@ func (h *sqbDeploymentHandler) Operate(obj runtimeObj) error {
 		//NewGrayServiceHandler(in, h.ctx),
 		//NewGrayVMServiceScrapeHandler(in, h.ctx),
 		NewSqbdeploymentIngressHandler(in, h.ctx),
+		NewSqbdeploymentHTTPRouteHandler(in, h.ctx),
+		NewCanaryHandler(in, h.ctx),
 	}
 
-	for _, handler := range handlers {
-		if err = handler.Handle(); err != nil {
-			return err
-		}
+	if err = handleAll(handlers); err != nil {
+		return err
 	}
 
 	if deleted {
 		return Delete(h.ctx, in)
-	} else if in.Status.ErrorInfo != "" {
-		in.Status.ErrorInfo = ""
+	}
+	h.requeueAfter = canaryRequeueAfter(in)
+	if after := blueGreenRequeueAfter(in); after > 0 && (h.requeueAfter == 0 || after < h.requeueAfter) {
+		h.requeueAfter = after
+	}
+	// Ready由deploymentHandler根据deployment的状态设置
+	markReconcileSuccess(&in.Status.Conditions, in.Generation)
+	in.Status.ObservedGeneration = in.Generation
+	if !reflect.DeepEqual(status, &in.Status) {
 		return UpdateStatus(h.ctx, in)
 	}
 	return nil
 }
 
+// updateSqbdeploymentStatus Operate中途保存status。服务端返回的是存储的原始spec，用副本更新后只复制status和resourceVersion，
+// 保留继承了sqbapplication配置之后生效的deploy配置，后续handler仍然使用合并后的spec
+func updateSqbdeploymentStatus(ctx context.Context, sqbdeployment *qav1alpha1.SQBDeployment) error {
+	in := sqbdeployment.DeepCopy()
+	if err := UpdateStatus(ctx, in); err != nil {
+		return err
+	}
+	sqbdeployment.Status = in.Status
+	sqbdeployment.ResourceVersion = in.ResourceVersion
+	return nil
+}
+
+func (h *sqbDeploymentHandler) RequeueAfter() time.Duration {
+	return h.requeueAfter
+}
+
 // 处理失败后逻辑
 func (h *sqbDeploymentHandler) ReconcileFail(obj runtimeObj, err error) {
 	in := obj.(*qav1alpha1.SQBDeployment)
-	in.Status.ErrorInfo = err.Error()
+	markReconcileFail(&in.Status.Conditions, in.Generation, err)
+	in.Status.ObservedGeneration = in.Generation
 	_ = UpdateStatus(h.ctx, in)
 }
 
@@ -84,6 +84,10 @@ func (h *sqbDeploymentListHandler) deleteByLabel(label map[string]string) error
 	return nil
 }
 
+func (h *sqbDeploymentListHandler) Name() string {
+	return "SQBDeploymentList"
+}
+
 func (h *sqbDeploymentListHandler) Handle() error {
 	if h.sqbapplication != nil {
 		if deleted, _ := IsDeleted(h.sqbapplication); deleted {
@@ -103,7 +107,7 @@ func (h *sqbDeploymentListHandler) handleIstio(sqbdeployment *qav1alpha1.SQBDepl
 	if sqbdeployment.Annotations[entity.InitializeAnnotationKey] != "true" {
 		return
 	}
-	if IsIstioInject(h.sqbapplication) {
+	if IsMeshInject(h.sqbapplication) {
 		if sqbdeployment.Annotations[entity.IstioInjectAnnotationKey] == "true" {
 			return
 		}
@@ -2,6 +2,8 @@ package handler
 
 import (
 	"context"
+	"reflect"
+
 	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
@@ -33,21 +35,29 @@ func (h *sqbPlaneHandler) Operate(obj runtimeObj) error {
 	if err != nil {
 		return err
 	}
+	status := in.Status.DeepCopy()
+	if deleted {
+		markDeleting(&in.Status.Conditions, in.Generation)
+		if err = UpdateStatus(h.ctx, in); err != nil {
+			return err
+		}
+	}
 
 	handlers := []SQBHandler{
 		NewSqbDeploymentListHandlerForSqbplane(in, h.ctx),
 	}
 
-	for _, handler := range handlers {
-		if err = handler.Handle(); err != nil {
-			return err
-		}
+	if err = handleAll(handlers); err != nil {
+		return err
 	}
 
 	if deleted {
 		return Delete(h.ctx, in)
-	} else if in.Status.ErrorInfo != "" {
-		in.Status.ErrorInfo = ""
+	}
+	markReconcileSuccess(&in.Status.Conditions, in.Generation)
+	markReady(&in.Status.Conditions, in.Generation)
+	in.Status.ObservedGeneration = in.Generation
+	if !reflect.DeepEqual(status, &in.Status) {
 		return UpdateStatus(h.ctx, in)
 	}
 	return nil
@@ -56,6 +66,7 @@ func (h *sqbPlaneHandler) Operate(obj runtimeObj) error {
 // 处理失败后逻辑
 func (h *sqbPlaneHandler) ReconcileFail(obj runtimeObj, err error) {
 	in := obj.(*qav1alpha1.SQBPlane)
-	in.Status.ErrorInfo = err.Error()
+	markReconcileFail(&in.Status.Conditions, in.Generation, err)
+	in.Status.ObservedGeneration = in.Generation
 	_ = UpdateStatus(h.ctx, in)
 }
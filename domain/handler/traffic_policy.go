@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+
+	istionetworkingv1beta1 "github.com/wosai/elastic-env-operator/api/istio/networking/v1beta1"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// getPlaneTrafficPolicies 每个环境生效的流量策略，sqbdeployment的配置覆盖应用的配置
+func getPlaneTrafficPolicies(sqbapplication *qav1alpha1.SQBApplication,
+	sqbdeployments []qav1alpha1.SQBDeployment) map[string]*qav1alpha1.TrafficPolicy {
+	policies := map[string]*qav1alpha1.TrafficPolicy{}
+	for _, plane := range getPlanes(sqbdeployments) {
+		policies[plane] = sqbapplication.Spec.TrafficPolicy
+	}
+	for _, sqbdeployment := range sqbdeployments {
+		if plane := sqbdeployment.Labels[entity.PlaneKey]; plane != "" {
+			policies[plane] = sqbapplication.Spec.TrafficPolicy.Override(sqbdeployment.Spec.TrafficPolicy)
+		}
+	}
+	return policies
+}
+
+// meshTimeout 流量策略没有配置超时时间时使用istioTimeout
+func meshTimeout(policy *qav1alpha1.TrafficPolicy) string {
+	if policy == nil || policy.Timeout == nil {
+		return fmt.Sprintf("%ds", entity.ConfigMapData.IstioTimeout())
+	}
+	return meshDuration(policy.Timeout)
+}
+
+// meshDuration 网格的duration格式只支持以秒为单位，如"1.5s"
+func meshDuration(duration *metav1.Duration) string {
+	if duration == nil {
+		return ""
+	}
+	return strconv.FormatFloat(duration.Seconds(), 'f', -1, 64) + "s"
+}
+
+func istioRetries(policy *qav1alpha1.TrafficPolicy) *istionetworkingv1beta1.HTTPRetry {
+	if policy == nil || policy.Retries == nil {
+		return nil
+	}
+	return &istionetworkingv1beta1.HTTPRetry{
+		Attempts:      policy.Retries.Attempts,
+		PerTryTimeout: meshDuration(policy.Retries.PerTryTimeout),
+		RetryOn:       policy.Retries.RetryOn,
+	}
+}
+
+// istioTrafficPolicy 连接池和异常点检测都没有配置时为nil
+func istioTrafficPolicy(policy *qav1alpha1.TrafficPolicy) *istionetworkingv1beta1.TrafficPolicy {
+	if policy == nil || (policy.ConnectionPool == nil && policy.OutlierDetection == nil) {
+		return nil
+	}
+	trafficPolicy := &istionetworkingv1beta1.TrafficPolicy{}
+	if pool := policy.ConnectionPool; pool != nil {
+		trafficPolicy.ConnectionPool = &istionetworkingv1beta1.ConnectionPoolSettings{}
+		if pool.MaxConnections != 0 {
+			trafficPolicy.ConnectionPool.Tcp = &istionetworkingv1beta1.TCPSettings{MaxConnections: pool.MaxConnections}
+		}
+		if pool.MaxPendingRequests != 0 || pool.MaxRequests != 0 || pool.MaxRequestsPerConnection != 0 {
+			trafficPolicy.ConnectionPool.Http = &istionetworkingv1beta1.HTTPSettings{
+				Http1MaxPendingRequests:  pool.MaxPendingRequests,
+				Http2MaxRequests:         pool.MaxRequests,
+				MaxRequestsPerConnection: pool.MaxRequestsPerConnection,
+			}
+		}
+	}
+	if detection := policy.OutlierDetection; detection != nil {
+		trafficPolicy.OutlierDetection = &istionetworkingv1beta1.OutlierDetection{
+			Interval:           meshDuration(detection.Interval),
+			BaseEjectionTime:   meshDuration(detection.BaseEjectionTime),
+			MaxEjectionPercent: detection.MaxEjectionPercent,
+		}
+		if detection.Consecutive5xxErrors != nil {
+			errors := uint32(*detection.Consecutive5xxErrors)
+			trafficPolicy.OutlierDetection.Consecutive5xxErrors = &errors
+		}
+	}
+	return trafficPolicy
+}
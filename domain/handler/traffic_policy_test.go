@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	istionetworkingv1beta1 "github.com/wosai/elastic-env-operator/api/istio/networking/v1beta1"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestTrafficPolicy(t *testing.T) {
+	app := newIstioTestApplication(t)
+	app.Spec.TrafficPolicy = &qav1alpha1.TrafficPolicy{
+		Timeout:        &metav1.Duration{Duration: 1500 * time.Millisecond},
+		Retries:        &qav1alpha1.RetryPolicy{Attempts: 2, RetryOn: "5xx"},
+		ConnectionPool: &qav1alpha1.ConnectionPoolPolicy{MaxConnections: 100},
+	}
+	ctx := context.Background()
+	// 特性环境覆盖超时时间和异常点检测
+	feature := &qav1alpha1.SQBDeployment{}
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "demo-feature"}, feature))
+	feature.Spec.TrafficPolicy = &qav1alpha1.TrafficPolicy{
+		Timeout: &metav1.Duration{Duration: time.Minute},
+		OutlierDetection: &qav1alpha1.OutlierDetectionPolicy{
+			Consecutive5xxErrors: &[]int32{5}[0],
+			BaseEjectionTime:     &metav1.Duration{Duration: 30 * time.Second},
+		},
+	}
+	assert.Nil(t, k8sclient.Update(ctx, feature))
+
+	assert.Nil(t, NewVirtualServiceHandler(app, ctx).Handle())
+	virtualService := &istionetworkingv1beta1.VirtualService{}
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "demo"}, virtualService))
+	routes := map[string]istionetworkingv1beta1.HTTPRoute{}
+	for _, route := range virtualService.Spec.Http {
+		routes[route.Name] = route
+	}
+	assert.Equal(t, "1.5s", routes["base"].Timeout)
+	assert.Equal(t, &istionetworkingv1beta1.HTTPRetry{Attempts: 2, RetryOn: "5xx"}, routes["base"].Retries)
+	assert.Equal(t, "60s", routes["feature"].Timeout)
+	assert.Equal(t, int32(2), routes["feature"].Retries.Attempts)
+
+	assert.Nil(t, NewDestinationRuleHandler(app, ctx).Handle())
+	destinationRule := &istionetworkingv1beta1.DestinationRule{}
+	assert.Nil(t, k8sclient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "demo"}, destinationRule))
+	assert.Equal(t, int32(100), destinationRule.Spec.TrafficPolicy.ConnectionPool.Tcp.MaxConnections)
+	assert.Nil(t, destinationRule.Spec.TrafficPolicy.OutlierDetection)
+	assert.Nil(t, destinationRule.Spec.Subsets[0].TrafficPolicy)
+	subsetPolicy := destinationRule.Spec.Subsets[1].TrafficPolicy
+	assert.Equal(t, int32(100), subsetPolicy.ConnectionPool.Tcp.MaxConnections)
+	assert.Equal(t, uint32(5), *subsetPolicy.OutlierDetection.Consecutive5xxErrors)
+	assert.Equal(t, "30s", subsetPolicy.OutlierDetection.BaseEjectionTime)
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"context"
+
+	splitv1alpha2 "github.com/wosai/elastic-env-operator/api/smi/split/v1alpha2"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	"github.com/wosai/elastic-env-operator/domain/util"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// trafficSplitHandler 应用的service选择所有环境的pod，linkerd通过TrafficSplit把发到应用service的请求
+// 只转发到基础环境的service，特性环境通过{应用名}-{plane}的service访问
+type trafficSplitHandler struct {
+	sqbapplication *qav1alpha1.SQBApplication
+	ctx            context.Context
+}
+
+func NewTrafficSplitHandler(sqbapplication *qav1alpha1.SQBApplication, ctx context.Context) *trafficSplitHandler {
+	return &trafficSplitHandler{sqbapplication: sqbapplication, ctx: ctx}
+}
+
+// CreateOrUpdate 每个环境的service是一个backend，基础环境的权重为100，其他环境为0，灰度发布时gray环境分走一部分权重
+func (h *trafficSplitHandler) CreateOrUpdate() error {
+	sqbdeployments, err := getSqbdeployments(h.ctx, h.sqbapplication)
+	if err != nil {
+		return err
+	}
+	base := entity.ConfigMapData.BaseFlag()
+	hasBase := false
+	for _, sqbdeployment := range sqbdeployments {
+		if sqbdeployment.Labels[entity.PlaneKey] == base {
+			hasBase = true
+		}
+	}
+	// 没有基础环境时请求没有可以转发的backend，删除TrafficSplit由应用的service直接转发
+	if !hasBase {
+		return h.Delete()
+	}
+	trafficSplit := &splitv1alpha2.TrafficSplit{ObjectMeta: metav1.ObjectMeta{
+		Namespace: h.sqbapplication.Namespace,
+		Name:      h.sqbapplication.Name,
+	}}
+	err = k8sclient.Get(h.ctx, client.ObjectKey{Namespace: trafficSplit.Namespace, Name: trafficSplit.Name}, trafficSplit)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	planes := getPlanes(sqbdeployments)
+	canaryWeight := getCanaryWeight(sqbdeployments)
+	backends := make([]splitv1alpha2.TrafficSplitBackend, len(planes))
+	for i, plane := range planes {
+		backends[i] = splitv1alpha2.TrafficSplitBackend{Service: util.GetSubsetName(h.sqbapplication.Name, plane)}
+		switch plane {
+		case base:
+			backends[i].Weight = 100 - int(canaryWeight)
+		case qav1alpha1.CanaryPlane:
+			backends[i].Weight = int(canaryWeight)
+		}
+	}
+	trafficSplit.Spec = splitv1alpha2.TrafficSplitSpec{
+		Service:  h.sqbapplication.Name,
+		Backends: backends,
+	}
+	trafficSplit.Labels = util.MergeStringMap(trafficSplit.Labels, h.sqbapplication.Labels)
+	return CreateOrUpdate(h.ctx, trafficSplit)
+}
+
+func (h *trafficSplitHandler) Delete() error {
+	trafficSplit := &splitv1alpha2.TrafficSplit{}
+	err := k8sclient.Get(h.ctx, client.ObjectKey{Namespace: h.sqbapplication.Namespace, Name: h.sqbapplication.Name}, trafficSplit)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return Delete(h.ctx, trafficSplit)
+}
+
+func (h *trafficSplitHandler) Name() string {
+	return "TrafficSplit"
+}
+
+// Handle 集群没有安装网格时不处理，应用关闭注入后删除
+func (h *trafficSplitHandler) Handle() error {
+	if !entity.ConfigMapData.IstioEnable() {
+		return nil
+	}
+	if deleted, _ := IsDeleted(h.sqbapplication); deleted || !IsMeshInject(h.sqbapplication) {
+		return h.Delete()
+	}
+	return h.CreateOrUpdate()
+}
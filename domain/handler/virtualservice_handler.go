@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	istionetworkingv1beta1 "github.com/wosai/elastic-env-operator/api/istio/networking/v1beta1"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	"github.com/wosai/elastic-env-operator/domain/util"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 不能按请求头路由的协议，只能转发到基础环境
+var tcpProtocols = []string{"tcp", "mongo", "mysql", "redis"}
+
+type virtualServiceHandler struct {
+	sqbapplication *qav1alpha1.SQBApplication
+	ctx            context.Context
+}
+
+func NewVirtualServiceHandler(sqbapplication *qav1alpha1.SQBApplication, ctx context.Context) *virtualServiceHandler {
+	return &virtualServiceHandler{sqbapplication: sqbapplication, ctx: ctx}
+}
+
+// CreateOrUpdate 按x-env-flag请求头和特性环境入口的host路由到对应环境的subset，其他请求转发到基础环境
+func (h *virtualServiceHandler) CreateOrUpdate() error {
+	sqbdeployments, err := getSqbdeployments(h.ctx, h.sqbapplication)
+	if err != nil {
+		return err
+	}
+	virtualService := &istionetworkingv1beta1.VirtualService{ObjectMeta: metav1.ObjectMeta{
+		Namespace: h.sqbapplication.Namespace,
+		Name:      h.sqbapplication.Name,
+	}}
+	err = k8sclient.Get(h.ctx, client.ObjectKey{Namespace: virtualService.Namespace, Name: virtualService.Name}, virtualService)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	// 通过istio-ingressgateway访问的域名也需要加到hosts中
+	hosts := []string{h.sqbapplication.Name}
+	for _, domain := range h.sqbapplication.Spec.Domains {
+		if !util.ContainString(hosts, domain.Host) {
+			hosts = append(hosts, domain.Host)
+		}
+	}
+	for _, sqbdeployment := range sqbdeployments {
+		if host := publicEntryHost(&sqbdeployment); host != "" && !util.ContainString(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	virtualService.Spec = istionetworkingv1beta1.VirtualServiceSpec{
+		Hosts:    hosts,
+		Gateways: append([]string(nil), entity.ConfigMapData.IstioGateways()...),
+		Http:     h.getHTTPRoutes(sqbdeployments),
+		Tcp:      h.getTCPRoutes(),
+	}
+	if anno, ok := h.sqbapplication.Annotations[entity.VirtualServiceAnnotationKey]; ok {
+		virtualService.Annotations = make(map[string]string)
+		_ = json.Unmarshal([]byte(anno), &virtualService.Annotations)
+	} else {
+		virtualService.Annotations = nil
+	}
+	virtualService.Labels = util.MergeStringMap(virtualService.Labels, h.sqbapplication.Labels)
+	return CreateOrUpdate(h.ctx, virtualService)
+}
+
+// getHTTPRoutes 路由顺序：subpaths、特性环境入口的host、x-env-flag请求头、基础环境，灰度发布时基础环境的路由按权重分到gray环境。
+// subpaths使用应用的超时和重试配置，其他路由使用对应环境的配置，并把请求镜像到以该环境为来源的环境
+func (h *virtualServiceHandler) getHTTPRoutes(sqbdeployments []qav1alpha1.SQBDeployment) []istionetworkingv1beta1.HTTPRoute {
+	policies := getPlaneTrafficPolicies(h.sqbapplication, sqbdeployments)
+	mirrors := getMirrors(sqbdeployments)
+	base := entity.ConfigMapData.BaseFlag()
+	routes := make([]istionetworkingv1beta1.HTTPRoute, 0)
+	for _, subpath := range h.sqbapplication.Spec.Subpaths {
+		routes = append(routes, istionetworkingv1beta1.HTTPRoute{
+			Match: []istionetworkingv1beta1.HTTPMatchRequest{{Uri: &istionetworkingv1beta1.StringMatch{Prefix: subpath.Path}}},
+			Route: []istionetworkingv1beta1.HTTPRouteDestination{{
+				Destination: &istionetworkingv1beta1.Destination{
+					Host: subpath.ServiceName,
+					Port: &istionetworkingv1beta1.PortSelector{Number: uint32(subpath.ServicePort)},
+				},
+			}},
+			Timeout: meshTimeout(h.sqbapplication.Spec.TrafficPolicy),
+			Retries: istioRetries(h.sqbapplication.Spec.TrafficPolicy),
+		})
+	}
+	planeHTTPRoute := func(name, plane string, match []istionetworkingv1beta1.HTTPMatchRequest) istionetworkingv1beta1.HTTPRoute {
+		route := istionetworkingv1beta1.HTTPRoute{
+			Name:    name,
+			Match:   match,
+			Route:   h.planeRoute(plane),
+			Timeout: meshTimeout(policies[plane]),
+			Retries: istioRetries(policies[plane]),
+		}
+		if mirror, ok := mirrors[plane]; ok {
+			route.Mirror = &istionetworkingv1beta1.Destination{
+				Host:   h.sqbapplication.Name,
+				Subset: util.GetSubsetName(h.sqbapplication.Name, mirror.Labels[entity.PlaneKey]),
+			}
+			percent := int32(100)
+			if mirror.Spec.Mirror.Percent != nil {
+				percent = *mirror.Spec.Mirror.Percent
+			}
+			route.MirrorPercentage = &istionetworkingv1beta1.Percent{Value: float64(percent)}
+		}
+		return route
+	}
+	for _, sqbdeployment := range sqbdeployments {
+		host := publicEntryHost(&sqbdeployment)
+		if host == "" {
+			continue
+		}
+		routes = append(routes, planeHTTPRoute(sqbdeployment.Name, sqbdeployment.Labels[entity.PlaneKey],
+			[]istionetworkingv1beta1.HTTPMatchRequest{{Authority: &istionetworkingv1beta1.StringMatch{Exact: host}}}))
+	}
+	for _, plane := range getPlanes(sqbdeployments) {
+		if plane == base {
+			continue
+		}
+		routes = append(routes, planeHTTPRoute(plane, plane, []istionetworkingv1beta1.HTTPMatchRequest{{
+			Headers: map[string]istionetworkingv1beta1.StringMatch{entity.XEnvFlag: {Exact: plane}},
+		}}))
+	}
+	baseRoute := planeHTTPRoute(base, base, nil)
+	// 灰度发布时按gray环境当前的权重分流
+	if weight := getCanaryWeight(sqbdeployments); weight > 0 {
+		baseRoute.Route[0].Weight = 100 - weight
+		canaryRoute := h.planeRoute(qav1alpha1.CanaryPlane)[0]
+		canaryRoute.Weight = weight
+		baseRoute.Route = append(baseRoute.Route, canaryRoute)
+	}
+	return append(routes, baseRoute)
+}
+
+// getMirrors 按来源环境查找镜像到的sqbdeployment，istio的路由只能有一个镜像，同一个来源环境按环境名取第一个
+func getMirrors(sqbdeployments []qav1alpha1.SQBDeployment) map[string]*qav1alpha1.SQBDeployment {
+	mirrors := make(map[string]*qav1alpha1.SQBDeployment)
+	for i := range sqbdeployments {
+		sqbdeployment := &sqbdeployments[i]
+		mirror := sqbdeployment.Spec.Mirror
+		if mirror == nil || mirror.From == "" || mirror.From == sqbdeployment.Labels[entity.PlaneKey] {
+			continue
+		}
+		if exist, ok := mirrors[mirror.From]; ok && exist.Labels[entity.PlaneKey] < sqbdeployment.Labels[entity.PlaneKey] {
+			continue
+		}
+		mirrors[mirror.From] = sqbdeployment
+	}
+	return mirrors
+}
+
+// getTCPRoutes tcp协议的端口不能按请求头路由，转发到基础环境
+func (h *virtualServiceHandler) getTCPRoutes() []istionetworkingv1beta1.TCPRoute {
+	var routes []istionetworkingv1beta1.TCPRoute
+	for _, port := range h.sqbapplication.Spec.Ports {
+		if !util.ContainString(tcpProtocols, strings.Split(port.Name, "-")[0]) {
+			continue
+		}
+		routes = append(routes, istionetworkingv1beta1.TCPRoute{
+			Match: []istionetworkingv1beta1.L4MatchAttributes{{Port: uint32(port.Port)}},
+			Route: []istionetworkingv1beta1.RouteDestination{{
+				Destination: &istionetworkingv1beta1.Destination{
+					Host:   h.sqbapplication.Name,
+					Subset: util.GetSubsetName(h.sqbapplication.Name, entity.ConfigMapData.BaseFlag()),
+					Port:   &istionetworkingv1beta1.PortSelector{Number: uint32(port.Port)},
+				},
+			}},
+		})
+	}
+	return routes
+}
+
+func (h *virtualServiceHandler) planeRoute(plane string) []istionetworkingv1beta1.HTTPRouteDestination {
+	return []istionetworkingv1beta1.HTTPRouteDestination{{
+		Destination: &istionetworkingv1beta1.Destination{
+			Host:   h.sqbapplication.Name,
+			Subset: util.GetSubsetName(h.sqbapplication.Name, plane),
+		},
+	}}
+}
+
+func (h *virtualServiceHandler) Delete() error {
+	virtualService := &istionetworkingv1beta1.VirtualService{}
+	err := k8sclient.Get(h.ctx, client.ObjectKey{Namespace: h.sqbapplication.Namespace, Name: h.sqbapplication.Name}, virtualService)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return Delete(h.ctx, virtualService)
+}
+
+func (h *virtualServiceHandler) Name() string {
+	return "VirtualService"
+}
+
+// Handle 集群没有安装istio时不处理，应用关闭istio注入后删除
+func (h *virtualServiceHandler) Handle() error {
+	if !entity.ConfigMapData.IstioEnable() {
+		return nil
+	}
+	if deleted, _ := IsDeleted(h.sqbapplication); deleted || !IsMeshInject(h.sqbapplication) {
+		return h.Delete()
+	}
+	return h.CreateOrUpdate()
+}
+
+// publicEntryHost 开启了特性环境入口的sqbdeployment对应的host，没有开启时为空
+func publicEntryHost(sqbdeployment *qav1alpha1.SQBDeployment) string {
+	if !HasPublicEntry(sqbdeployment) {
+		return ""
+	}
+	return entity.ConfigMapData.GetDomainNameByClass(sqbdeployment.Name, SpecialVirtualServiceIngress(sqbdeployment))
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wosai/elastic-env-operator/api/istio"
+	istionetworkingv1beta1 "github.com/wosai/elastic-env-operator/api/istio/networking/v1beta1"
+	"github.com/wosai/elastic-env-operator/api/linkerd"
+	"github.com/wosai/elastic-env-operator/api/smi"
+	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	"github.com/wosai/elastic-env-operator/domain/entity"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newIstioTestApplication 创建部署了base和feature两个环境的应用，feature开启了特性环境入口
+func newIstioTestApplication(t *testing.T) *qav1alpha1.SQBApplication {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = qav1alpha1.AddToScheme(scheme)
+	_ = istio.AddToScheme(scheme)
+	_ = linkerd.AddToScheme(scheme)
+	_ = smi.AddToScheme(scheme)
+	SetK8sScheme(scheme)
+	newSqbdeployment := func(plane string) *qav1alpha1.SQBDeployment {
+		return &qav1alpha1.SQBDeployment{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "demo-" + plane,
+			Labels:    map[string]string{entity.AppKey: "demo", entity.PlaneKey: plane},
+		}}
+	}
+	feature := newSqbdeployment("feature")
+	feature.Annotations = map[string]string{entity.PublicEntryAnnotationKey: "true"}
+	SetK8sClient(fake.NewClientBuilder().WithScheme(scheme).WithObjects(newSqbdeployment("base"), feature).Build())
+	entity.ConfigMapData.FromMap(map[string]string{
+		"operatorDelay": "0",
+		"istioEnable":   "true",
+		"istioInject":   "true",
+		"domainPostfix": `{"nginx":"*.iwosai.com"}`,
+	})
+	t.Cleanup(func() {
+		SetK8sClient(nil)
+		entity.ConfigMapData.FromMap(map[string]string{"operatorDelay": "0"})
+	})
+	app := &qav1alpha1.SQBApplication{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "demo"}}
+	app.Spec.Domains = []qav1alpha1.Domain{{Class: "nginx", Host: "demo.iwosai.com"}}
+	return app
+}
+
+func TestVirtualService(t *testing.T) {
+	app := newIstioTestApplication(t)
+	app.Spec.Ports = []corev1.ServicePort{{Name: "http-80", Port: 80}, {Name: "tcp-3306", Port: 3306}}
+	ctx := context.Background()
+	assert.Nil(t, NewVirtualServiceHandler(app, ctx).Handle())
+	virtualService := &istionetworkingv1beta1.VirtualService{}
+	key := client.ObjectKey{Namespace: "default", Name: "demo"}
+	assert.Nil(t, k8sclient.Get(ctx, key, virtualService))
+	featureHost := entity.ConfigMapData.GetDomainNameByClass("demo-feature", "nginx")
+	assert.Equal(t, []string{"demo", "demo.iwosai.com", featureHost}, virtualService.Spec.Hosts)
+	assert.Equal(t, []string{"mesh"}, virtualService.Spec.Gateways)
+
+	routes := virtualService.Spec.Http
+	assert.Equal(t, 3, len(routes))
+	// 特性环境入口按host路由
+	assert.Equal(t, featureHost, routes[0].Match[0].Authority.Exact)
+	assert.Equal(t, "demo-feature", routes[0].Route[0].Destination.Subset)
+	// 按x-env-flag请求头路由
+	assert.Equal(t, "feature", routes[1].Match[0].Headers[entity.XEnvFlag].Exact)
+	assert.Equal(t, "demo-feature", routes[1].Route[0].Destination.Subset)
+	// 其他请求转发到基础环境
+	assert.Nil(t, routes[2].Match)
+	assert.Equal(t, "demo-base", routes[2].Route[0].Destination.Subset)
+	assert.Equal(t, "30s", routes[2].Timeout)
+	assert.Equal(t, uint32(3306), virtualService.Spec.Tcp[0].Match[0].Port)
+
+	// 关闭istio注入后删除
+	app.Annotations = map[string]string{entity.IstioInjectAnnotationKey: "false"}
+	assert.Nil(t, NewVirtualServiceHandler(app, ctx).Handle())
+	assert.True(t, apierrors.IsNotFound(k8sclient.Get(ctx, key, virtualService)))
+}
+
+func TestVirtualServiceMirror(t *testing.T) {
+	app := newIstioTestApplication(t)
+	ctx := context.Background()
+	feature := &qav1alpha1.SQBDeployment{}
+	featureKey := client.ObjectKey{Namespace: "default", Name: "demo-feature"}
+	assert.Nil(t, k8sclient.Get(ctx, featureKey, feature))
+	feature.Spec.Mirror = &qav1alpha1.MirrorSpec{From: "base", Percent: &[]int32{10}[0]}
+	assert.Nil(t, k8sclient.Update(ctx, feature))
+
+	assert.Nil(t, NewVirtualServiceHandler(app, ctx).Handle())
+	virtualService := &istionetworkingv1beta1.VirtualService{}
+	key := client.ObjectKey{Namespace: "default", Name: "demo"}
+	assert.Nil(t, k8sclient.Get(ctx, key, virtualService))
+	routes := virtualService.Spec.Http
+	// 基础环境的请求镜像到特性环境，特性环境自己的路由没有镜像
+	assert.Equal(t, "demo-feature", routes[2].Mirror.Subset)
+	assert.Equal(t, 10.0, routes[2].MirrorPercentage.Value)
+	assert.Nil(t, routes[1].Mirror)
+
+	// 删除特性环境后去掉镜像
+	// fake client不会设置creationTimestamp
+	virtualService.CreationTimestamp = metav1.Now()
+	assert.Nil(t, k8sclient.Update(ctx, virtualService))
+	assert.Nil(t, k8sclient.Delete(ctx, feature))
+	assert.Nil(t, NewVirtualServiceHandler(app, ctx).Handle())
+	assert.Nil(t, k8sclient.Get(ctx, key, virtualService))
+	assert.Equal(t, 1, len(virtualService.Spec.Http))
+	assert.Nil(t, virtualService.Spec.Http[0].Mirror)
+}
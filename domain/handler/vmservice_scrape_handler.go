@@ -28,7 +28,7 @@ func NewVMServiceScrapeHandler(sqbapplication *qav1alpha1.SQBApplication, ctx co
 }
 
 func NewGrayVMServiceScrapeHandler(sqbdeployment *qav1alpha1.SQBDeployment, ctx context.Context) SQBHandler {
-	return &grayVmServiceScrapeHandler{sqbdeployment: sqbdeployment, ctx: ctx, plane: "gray"}
+	return &grayVmServiceScrapeHandler{sqbdeployment: sqbdeployment, ctx: ctx, plane: qav1alpha1.CanaryPlane}
 }
 
 func (h *vmserviceScrapeHandler) CreateOrUpdate() error {
@@ -69,6 +69,10 @@ func (h *vmserviceScrapeHandler) Delete() error {
 	return Delete(h.ctx, service)
 }
 
+func (h *vmserviceScrapeHandler) Name() string {
+	return "VMServiceScrape"
+}
+
 func (h *vmserviceScrapeHandler) Handle() error {
 	if !entity.ConfigMapData.IsVictoriaMetricsEnable() {
 		return nil
@@ -125,6 +129,10 @@ func (h *grayVmServiceScrapeHandler) Delete() error {
 	return Delete(h.ctx, service)
 }
 
+func (h *grayVmServiceScrapeHandler) Name() string {
+	return "GrayVMServiceScrape"
+}
+
 func (h *grayVmServiceScrapeHandler) Handle() error {
 	if !entity.ConfigMapData.IsVictoriaMetricsEnable() {
 		return nil
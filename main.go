@@ -32,8 +32,14 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
+	"github.com/wosai/elastic-env-operator/api/certmanager"
 	"github.com/wosai/elastic-env-operator/api/cronhpa"
+	"github.com/wosai/elastic-env-operator/api/gatewayapi"
+	"github.com/wosai/elastic-env-operator/api/istio"
+	"github.com/wosai/elastic-env-operator/api/linkerd"
+	"github.com/wosai/elastic-env-operator/api/smi"
 	qav1alpha1 "github.com/wosai/elastic-env-operator/api/v1alpha1"
+	qav1beta1 "github.com/wosai/elastic-env-operator/api/v1beta1"
 	"github.com/wosai/elastic-env-operator/controllers"
 	"github.com/wosai/elastic-env-operator/domain/entity"
 	"github.com/wosai/elastic-env-operator/domain/handler"
@@ -47,10 +53,16 @@ var (
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(qav1alpha1.AddToScheme(scheme))
+	utilruntime.Must(qav1beta1.AddToScheme(scheme))
 	utilruntime.Must(v1.AddToScheme(scheme))
 	utilruntime.Must(prometheus.AddToScheme(scheme))
 	utilruntime.Must(victoriametrics.AddToScheme(scheme))
 	utilruntime.Must(cronhpa.AddToScheme(scheme))
+	utilruntime.Must(certmanager.AddToScheme(scheme))
+	utilruntime.Must(gatewayapi.AddToScheme(scheme))
+	utilruntime.Must(istio.AddToScheme(scheme))
+	utilruntime.Must(linkerd.AddToScheme(scheme))
+	utilruntime.Must(smi.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -121,10 +133,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	qav1alpha1.DefaultPlane = entity.ConfigMapData.BaseFlag
+	qav1alpha1.GatewayMode = entity.ConfigMapData.IsGatewayMode
 	if err = (&qav1alpha1.SQBDeployment{}).SetupWebhookWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", "SQBDeployment")
 		os.Exit(1)
 	}
+	if err = (&qav1alpha1.SQBApplication{}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "SQBApplication")
+		os.Exit(1)
+	}
 
 	go initConfig(mgr)
 	// +kubebuilder:scaffold:builder